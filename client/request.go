@@ -2,6 +2,9 @@ package client
 
 import (
 	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -37,6 +40,17 @@ func (r Request) String() string {
 	return s
 }
 
+// Errors returned by ReadRequest for requests which can't be read, a server
+// answers them with 400 Bad Request.
+var (
+	ErrMalformedRequest = errors.New("rtsp: malformed request")
+	ErrContentLength    = errors.New("rtsp: invalid Content-Length")
+)
+
+// maxContentLength bounds the body of a request, bodies are sdp and
+// parameters which are far smaller.
+const maxContentLength = 1 << 20
+
 // ReadRequest implements a super simple RTSP parser; would be nice if net/http would allow more general parsing
 func ReadRequest(r io.Reader) (req *Request, err error) {
 	req = new(Request)
@@ -50,14 +64,20 @@ func ReadRequest(r io.Reader) (req *Request, err error) {
 		return
 	}
 
-	parts := strings.SplitN(s, " ", 3)
+	parts := strings.Fields(s)
+	if len(parts) != 3 {
+		err = ErrMalformedRequest
+		return
+	}
 	req.Method = parts[0]
 	if req.URL, err = url.Parse(parts[1]); err != nil {
+		err = ErrMalformedRequest
 		return
 	}
 
 	req.Proto, req.ProtoMajor, req.ProtoMinor, err = ParseRTSPVersion(parts[2])
 	if err != nil {
+		err = ErrMalformedRequest
 		return
 	}
 
@@ -70,13 +90,23 @@ func ReadRequest(r io.Reader) (req *Request, err error) {
 		}
 
 		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			err = ErrMalformedRequest
+			return
+		}
 		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
 	}
 
-	req.ContentLength, _ = strconv.Atoi(req.Header.Get("Content-Length"))
-	fmt.Println("Content Length:", req.ContentLength)
+	if length := req.Header.Get("Content-Length"); length != "" {
+		if req.ContentLength, err = strconv.Atoi(length); err != nil ||
+			req.ContentLength < 0 || req.ContentLength > maxContentLength {
+			req.ContentLength = 0
+			err = ErrContentLength
+			return
+		}
+	}
 	req.Body = make([]byte, req.ContentLength)
-	_, err = io.ReadFull(r, req.Body)
+	_, err = io.ReadFull(b, req.Body)
 	return
 }
 
@@ -86,12 +116,41 @@ type DigestAuthencitation struct {
 	Password string
 	Realm    string
 	Nonce    string
+	// Qop is the quality of protection the server offers, responses use
+	// qop=auth with a nonce count when it's offered.
+	Qop        string
+	NonceCount int
 }
 
 // GetDigestResponse calculates a response for digest authenciation
 func (d DigestAuthencitation) GetDigestResponse(method, uri string) (response string) {
-	hash1 := codec.GetHash(codec.MD5, []byte(d.UserName+":"+d.Realm+":"+d.Password))
-	hash2 := codec.GetHash(codec.MD5, []byte(method+":"+uri))
-	response = codec.GetHash(codec.MD5, []byte(hash1.Hex()+":"+d.Nonce+":"+hash2.Hex())).Hex()
-	return
+	return d.DigestResponse(method, uri, "", "")
+}
+
+// DigestResponse calculates the response of RFC 2617 section 3.2.2.1, with
+// qop=auth when nc, the nonce count, is given along with the cnonce.
+func (d DigestAuthencitation) DigestResponse(method, uri, nc, cnonce string) string {
+	hash1 := codec.GetHash(codec.MD5, []byte(d.UserName+":"+d.Realm+":"+d.Password)).Hex()
+	hash2 := codec.GetHash(codec.MD5, []byte(method+":"+uri)).Hex()
+	if nc == "" {
+		return codec.GetHash(codec.MD5, []byte(hash1+":"+d.Nonce+":"+hash2)).Hex()
+	}
+	return codec.GetHash(codec.MD5, []byte(hash1+":"+d.Nonce+":"+nc+":"+cnonce+":auth:"+hash2)).Hex()
+}
+
+// Authorization returns the Authorization header of a request, every call
+// with qop=auth counts up the nonce count.
+func (d *DigestAuthencitation) Authorization(method, uri string) string {
+	if !strings.Contains(d.Qop, "auth") {
+		return fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"",
+			d.UserName, d.Realm, d.Nonce, uri, d.GetDigestResponse(method, uri))
+	}
+
+	d.NonceCount++
+	nc := fmt.Sprintf("%08x", d.NonceCount)
+	b := make([]byte, 8)
+	rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	return fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", qop=auth, nc=%s, cnonce=\"%s\", response=\"%s\"",
+		d.UserName, d.Realm, d.Nonce, uri, nc, cnonce, d.DigestResponse(method, uri, nc, cnonce))
 }
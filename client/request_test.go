@@ -0,0 +1,59 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadRequest(t *testing.T) {
+	req, err := ReadRequest(strings.NewReader("ANNOUNCE rtsp://host/live RTSP/1.0\r\n" +
+		"CSeq: 2\r\nContent-Length: 4\r\n\r\nv=0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != ANNOUNCE || req.URL.Path != "/live" || req.ProtoMajor != 1 || req.ProtoMinor != 0 ||
+		req.Header.Get("CSeq") != "2" || string(req.Body) != "v=0\n" {
+		t.Errorf("request %+v", req)
+	}
+}
+
+func TestReadRequestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"header without colon", "OPTIONS * RTSP/1.0\r\nCSeq 1\r\n\r\n", ErrMalformedRequest},
+		{"short request line", "OPTIONS *\r\n\r\n", ErrMalformedRequest},
+		{"empty request line", "\r\n\r\n", ErrMalformedRequest},
+		{"version without slash", "OPTIONS * RTSP\r\n\r\n", ErrMalformedRequest},
+		{"version without minor", "OPTIONS * RTSP/1\r\n\r\n", ErrMalformedRequest},
+		{"bad url", "OPTIONS rtsp://%zz RTSP/1.0\r\n\r\n", ErrMalformedRequest},
+		{"negative length", "OPTIONS * RTSP/1.0\r\nContent-Length: -1\r\n\r\n", ErrContentLength},
+		{"oversized length", "OPTIONS * RTSP/1.0\r\nContent-Length: " +
+			strconv.Itoa(maxContentLength+1) + "\r\n\r\n", ErrContentLength},
+		{"invalid length", "OPTIONS * RTSP/1.0\r\nContent-Length: x\r\n\r\n", ErrContentLength},
+	}
+	for _, test := range tests {
+		req, err := ReadRequest(strings.NewReader(test.data))
+		if err != test.err {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+		if req == nil {
+			t.Errorf("%s: no request to answer", test.name)
+		}
+	}
+}
+
+func TestDigestResponse(t *testing.T) {
+	// the example of RFC 2617 section 3.5.
+	d := DigestAuthencitation{UserName: "Mufasa", Password: "Circle Of Life", Realm: "testrealm@host.com",
+		Nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093"}
+	if response := d.DigestResponse("GET", "/dir/index.html", "00000001", "0a4f113b"); response != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("qop response %s", response)
+	}
+	if d.DigestResponse("GET", "/dir/index.html", "", "") != d.GetDigestResponse("GET", "/dir/index.html") {
+		t.Error("response without qop differs")
+	}
+}
@@ -74,3 +74,73 @@ func ReadResponse(r io.Reader) (res *Response, err error) {
 
 	return
 }
+
+// Write writes the response to w in wire format, followed by its body.
+func (res Response) Write(w io.Writer) error {
+	s := fmt.Sprintf("%s/%d.%d %d %s\r\n", res.Proto, res.ProtoMajor, res.ProtoMinor, res.StatusCode, res.Status)
+	for k, v := range res.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		for _, v := range v {
+			s += fmt.Sprintf("%s: %s\r\n", k, v)
+		}
+	}
+	if len(res.Body) > 0 {
+		s += fmt.Sprintf("Content-Length: %d\r\n", len(res.Body))
+	}
+	s += "\r\n"
+	_, err := io.WriteString(w, s+string(res.Body))
+	return err
+}
+
+// StatusText returns the reason phrase of a RTSP status code.
+func StatusText(code int) string {
+	return statusText[code]
+}
+
+var statusText = map[int]string{
+	Continue:                      "Continue",
+	OK:                            "OK",
+	Created:                       "Created",
+	LowOnStorageSpace:             "Low on Storage Space",
+	MultipleChoices:               "Multiple Choices",
+	MovedPermanently:              "Moved Permanently",
+	MovedTemporarily:              "Moved Temporarily",
+	SeeOther:                      "See Other",
+	UseProxy:                      "Use Proxy",
+	BadRequest:                    "Bad Request",
+	Unauthorized:                  "Unauthorized",
+	PaymentRequired:               "Payment Required",
+	Forbidden:                     "Forbidden",
+	NotFound:                      "Not Found",
+	MethodNotAllowed:              "Method Not Allowed",
+	NotAcceptable:                 "Not Acceptable",
+	ProxyAuthenticationRequired:   "Proxy Authentication Required",
+	RequestTimeout:                "Request Timeout",
+	Gone:                          "Gone",
+	LengthRequired:                "Length Required",
+	PreconditionFailed:            "Precondition Failed",
+	RequestEntityTooLarge:         "Request Entity Too Large",
+	RequestURITooLong:             "Request-URI Too Long",
+	UnsupportedMediaType:          "Unsupported Media Type",
+	Invalidparameter:              "Invalid parameter",
+	IllegalConferenceIdentifier:   "Illegal Conference Identifier",
+	NotEnoughBandwidth:            "Not Enough Bandwidth",
+	SessionNotFound:               "Session Not Found",
+	MethodNotValidInThisState:     "Method Not Valid In This State",
+	HeaderFieldNotValid:           "Header Field Not Valid",
+	InvalidRange:                  "Invalid Range",
+	ParameterIsReadOnly:           "Parameter Is Read-Only",
+	AggregateOperationNotAllowed:  "Aggregate Operation Not Allowed",
+	OnlyAggregateOperationAllowed: "Only Aggregate Operation Allowed",
+	UnsupportedTransport:          "Unsupported Transport",
+	DestinationUnreachable:        "Destination Unreachable",
+	InternalServerError:           "Internal Server Error",
+	NotImplemented:                "Not Implemented",
+	BadGateway:                    "Bad Gateway",
+	ServiceUnavailable:            "Service Unavailable",
+	GatewayTimeout:                "Gateway Timeout",
+	RTSPVersionNotSupported:       "RTSP Version Not Supported",
+	OptionNotsupport:              "Option not supported",
+}
@@ -0,0 +1,268 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solomondong/rtsp/rtcp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// ErrNoKeyframeRequest is returned by RequestKeyframe when the server takes
+// keyframe requests on none of the video streams it sends.
+var ErrNoKeyframeRequest = errors.New("rtsp: server takes no keyframe requests")
+
+// udpOverhead is the size of the IP and UDP headers, counted in the average
+// rtcp packet size.
+const udpOverhead = 28
+
+// cname returns the canonical name the client describes itself with.
+func cname() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("rtsp-%08x@%s", rand.Uint32(), host)
+}
+
+// rtcpBandwidth returns the share of the session bandwidth for rtcp in bytes
+// per second, from the b=RR (RFC 3556) or 5% of the b=AS lines of the
+// description, 0 if it doesn't tell.
+func (s *Session) rtcpBandwidth() float64 {
+	lines := s.sdp.BandwidthInformation
+	if len(lines) == 0 {
+		for _, stream := range s.streams {
+			lines = append(lines, stream.Sdp.BandwidthInformation...)
+		}
+	}
+	var as, rr float64
+	hasRR := false
+	for _, line := range lines {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			continue
+		}
+		switch kv[0] {
+		case "AS":
+			as += v * 1000 / 8
+		case "RR":
+			rr += v / 8
+			hasRR = true
+		}
+	}
+	if hasRR {
+		return rr
+	}
+	return as * 0.05
+}
+
+// sendReports sends the server a receiver report and the CNAME of the client
+// on each stream, followed by a BYE when the client leaves.
+func (s *Session) sendReports(bye bool) {
+	for idx := range s.streams {
+		var trailer []rtcp.Message
+		if bye {
+			trailer = append(trailer, &rtcp.Goodbye{Sources: []uint32{s.ssrc}})
+		}
+		if err := s.sendRtcp(idx, trailer...); err != nil {
+			fmt.Println("rtsp: rtcp report not sent", err)
+		}
+	}
+}
+
+// receiverRtt tells whether the server asks for receiver reference times on
+// a media with the rcvr-rtt format of a=rtcp-xr, at the media or else the
+// session level (RFC 3611 section 5.1). The client sends no rtp, so
+// rcvr-rtt=sender leaves it out.
+func receiverRtt(session sdp.SessionSection, media sdp.SessionSectionMedia) bool {
+	values := media.Attributes.Values("rtcp-xr")
+	if values == nil {
+		values = session.Attributes.Values("rtcp-xr")
+	}
+	for _, value := range values {
+		for _, format := range strings.Fields(value) {
+			if format == "rcvr-rtt=all" || strings.HasPrefix(format, "rcvr-rtt=all:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendRtcp sends the server a compound packet on a stream: a receiver report,
+// the CNAME of the client and, when the server asks for it, a receiver
+// reference time, followed by trailer.
+// The FIRs of trailer are numbered in sequence on the stream.
+func (s *Session) sendRtcp(idx int, trailer ...rtcp.Message) error {
+	s.reportMu.Lock()
+	defer s.reportMu.Unlock()
+	for _, message := range trailer {
+		if fir, ok := message.(*rtcp.FullIntraRequest); ok {
+			s.streams[idx].firSeq++
+			for i := range fir.Entries {
+				fir.Entries[i].SequenceNumber = s.streams[idx].firSeq
+			}
+		}
+	}
+	now := time.Now()
+	messages := []rtcp.Message{
+		&rtcp.ReceiverReport{SSRC: s.ssrc, Reports: s.stats.ReceptionReports(uint(idx), now)},
+		&rtcp.SourceDescription{Chunks: []rtcp.SourceDescriptionChunk{{
+			Source: s.ssrc,
+			Items:  []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: s.cname}},
+		}}},
+	}
+	if receiverRtt(s.sdp, s.streams[idx].Sdp) {
+		// the server answers the reference time with a DLRR, telling the
+		// round trip time.
+		messages = append(messages, &rtcp.ExtendedReport{SSRC: s.ssrc, Blocks: []rtcp.ReportBlock{
+			&rtcp.ReceiverReferenceTimeBlock{NTPTime: rtcp.ToNTP(now)},
+		}})
+	}
+	buf, err := rtcp.MarshalCompound(append(messages, trailer...)...)
+	if err != nil {
+		return err
+	}
+	s.avgRtcpSize += (float64(len(buf)+udpOverhead) - s.avgRtcpSize) / 16
+
+	if stream := s.streams[idx]; stream.interleaved {
+		if stream.srtp != nil {
+			if buf, err = stream.srtp.EncryptRTCP(nil, buf); err != nil {
+				return err
+			}
+		}
+		return s.writeInterleaved(stream.rtcpChannel, buf)
+	} else if s.udp != nil {
+		return s.udp.WriteRTCP(uint(idx), buf)
+	}
+	return nil
+}
+
+// RequestKeyframe asks the server for a keyframe on the video streams, with
+// a PLI or a FIR as their a=rtcp-fb lines allow, so the picture recovers
+// after a loss without waiting for the next scheduled one.
+func (s *Session) RequestKeyframe() error {
+	if s.state < StateSetuped {
+		return errors.New("not setup yet")
+	}
+	requested := false
+	for idx, stream := range s.streams {
+		if stream.Sdp.Type != "video" {
+			continue
+		}
+		pli, fir := keyframeFeedback(stream.Sdp)
+		if !pli && !fir {
+			continue
+		}
+		ssrc, ok := s.mediaSSRC(uint(idx))
+		if !ok {
+			continue
+		}
+		var feedback rtcp.Message
+		if pli {
+			feedback = &rtcp.PictureLossIndication{SenderSSRC: s.ssrc, MediaSSRC: ssrc}
+		} else {
+			// sendRtcp numbers the request.
+			feedback = &rtcp.FullIntraRequest{SenderSSRC: s.ssrc, Entries: []rtcp.FIREntry{{SSRC: ssrc}}}
+		}
+		if err := s.sendRtcp(idx, feedback); err != nil {
+			return err
+		}
+		requested = true
+	}
+	if !requested {
+		return ErrNoKeyframeRequest
+	}
+	return nil
+}
+
+// keyframeFeedback tells whether the server takes PLIs and FIRs for the
+// payload type of a media.
+func keyframeFeedback(media sdp.SessionSectionMedia) (pli, fir bool) {
+	for _, fb := range media.RtcpFbs {
+		if fb.PayloadType != "*" && fb.PayloadType != strconv.Itoa(media.PayloadType) {
+			continue
+		}
+		switch {
+		case fb.Type == "nack" && fb.Parameter == "pli":
+			pli = true
+		case fb.Type == "ccm" && fb.Parameter == "fir":
+			fir = true
+		}
+	}
+	return
+}
+
+// mediaSSRC returns the source of a stream the latest packet came from.
+func (s *Session) mediaSSRC(idx uint) (ssrc uint32, ok bool) {
+	var last time.Time
+	for _, stats := range s.stats.Stats() {
+		if stats.StreamIdx == idx && !stats.LastPacket.Before(last) {
+			ssrc, last, ok = stats.SSRC, stats.LastPacket, true
+		}
+	}
+	return
+}
+
+// handleExtendedReport measures the round trip time to the sender of an
+// extended report from the DLRR answering the reference time of the client.
+func (s *Session) handleExtendedReport(xr *rtcp.ExtendedReport, arrival time.Time) {
+	for _, block := range xr.Blocks {
+		dlrr, ok := block.(*rtcp.DLRRBlock)
+		if !ok {
+			continue
+		}
+		for _, report := range dlrr.Reports {
+			if report.SSRC != s.ssrc {
+				continue
+			}
+			if rtt, ok := rtcp.RoundTripTime(arrival, report.LastReceiverTime, report.Delay); ok {
+				s.stats.RoundTrip(xr.SSRC, rtt)
+			}
+		}
+	}
+}
+
+// writeInterleaved sends a packet on a channel of the rtsp connection.
+func (s *Session) writeInterleaved(channel byte, buf []byte) error {
+	frame := make([]byte, 4, 4+len(buf))
+	frame[0] = '$'
+	frame[1] = channel
+	frame[2] = byte(len(buf) >> 8)
+	frame[3] = byte(len(buf))
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.conn.Write(append(frame, buf...))
+	return err
+}
+
+// report sends receiver reports at the interval of RFC 3550 section 6.2
+// until the session is closed.
+func (s *Session) report() {
+	bandwidth := s.rtcpBandwidth()
+	initial := true
+	for {
+		// sendRtcp updates the average size on other goroutines too.
+		s.reportMu.Lock()
+		avgRtcpSize := s.avgRtcpSize
+		s.reportMu.Unlock()
+		// the client and the server, which sends.
+		interval := rtcp.ReportInterval(2, 1, bandwidth, avgRtcpSize, false, initial)
+		initial = false
+		select {
+		case <-time.After(interval):
+			s.sendReports(false)
+		case <-s.done:
+			return
+		}
+	}
+}
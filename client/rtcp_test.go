@@ -0,0 +1,232 @@
+package client
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solomondong/rtsp/rtcp"
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// newTestSession creates a set up session of a description, its streams
+// come over conn when it's not nil.
+func newTestSession(t *testing.T, description string, conn net.Conn) *Session {
+	p, err := sdp.ParseSdp(strings.NewReader(description))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{
+		conn:    conn,
+		state:   StateSetuped,
+		sdp:     p,
+		rtpChan: make(chan rtp.Packet, 10),
+		eof:     make(chan struct{}),
+		done:    make(chan struct{}),
+		stats:   rtp.NewReceiverStats(),
+		ssrc:    0x11223344,
+		cname:   "client@test",
+	}
+	timeline := &timeline{}
+	for idx, media := range p.Medias {
+		stream := &Stream{Sdp: media, timeline: timeline}
+		if conn != nil {
+			stream.interleaved = true
+			stream.rtcpChannel = byte(2*idx + 1)
+		}
+		s.streams = append(s.streams, stream)
+	}
+	return s
+}
+
+// readCompound reads the rtcp the client sent on an interleaved channel.
+func readCompound(t *testing.T, conn net.Conn) (channel byte, messages []rtcp.Message) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, int(header[2])<<8|int(header[3]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := rtcp.ParseCompound(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header[1], messages
+}
+
+func TestRtcpBandwidth(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		bandwidth   float64
+	}{
+		{"b=RR of the session", "v=0\r\nb=AS:1000\r\nb=RR:8000\r\nm=video 0 RTP/AVP 96\r\n", 1000},
+		// 5% of the media bandwidth, in bytes.
+		{"b=AS of the session", "v=0\r\nb=AS:256\r\nm=video 0 RTP/AVP 96\r\n", 256 * 1000 / 8 * 0.05},
+		{"b=AS of the medias", "v=0\r\nm=video 0 RTP/AVP 96\r\nb=AS:200\r\nm=audio 0 RTP/AVP 0\r\nb=AS:56\r\n",
+			256 * 1000 / 8 * 0.05},
+		{"b=RR of a media", "v=0\r\nm=video 0 RTP/AVP 96\r\nb=AS:200\r\nb=RR:4000\r\n", 500},
+		{"no bandwidth", "v=0\r\nm=video 0 RTP/AVP 96\r\n", 0},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, test.description, nil)
+		if bandwidth := s.rtcpBandwidth(); bandwidth != test.bandwidth {
+			t.Errorf("%s: %v bytes/s, want %v", test.name, bandwidth, test.bandwidth)
+		}
+	}
+}
+
+func TestSendReports(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	s := newTestSession(t, "v=0\r\na=rtcp-xr:rcvr-rtt=all\r\nm=video 0 RTP/AVP 96\r\n", client)
+	// a source is valid after a few packets in sequence.
+	for i := 0; i < 3; i++ {
+		s.stats.Update(rtp.Packet{SequenceNumber: uint(1 + i), Timestamp: 90000, SyncSource: 0xcafe}, time.Now(), 90000)
+	}
+
+	go s.sendReports(true)
+	channel, messages := readCompound(t, server)
+	if channel != 1 || len(messages) != 4 {
+		t.Fatalf("channel %d, messages %v", channel, messages)
+	}
+	rr, ok := messages[0].(*rtcp.ReceiverReport)
+	if !ok || rr.SSRC != s.ssrc || len(rr.Reports) != 1 || rr.Reports[0].SSRC != 0xcafe {
+		t.Errorf("receiver report %+v", messages[0])
+	}
+	sdes, ok := messages[1].(*rtcp.SourceDescription)
+	if !ok || sdes.Chunks[0].Items[0].Text != s.cname {
+		t.Errorf("source description %+v", messages[1])
+	}
+	xr, ok := messages[2].(*rtcp.ExtendedReport)
+	if !ok || xr.SSRC != s.ssrc || len(xr.Blocks) != 1 {
+		t.Errorf("extended report %+v", messages[2])
+	} else if _, ok = xr.Blocks[0].(*rtcp.ReceiverReferenceTimeBlock); !ok {
+		t.Errorf("extended report block %+v", xr.Blocks[0])
+	}
+	bye, ok := messages[3].(*rtcp.Goodbye)
+	if !ok || len(bye.Sources) != 1 || bye.Sources[0] != s.ssrc {
+		t.Errorf("goodbye %+v", messages[3])
+	}
+}
+
+func TestKeyframeFeedback(t *testing.T) {
+	tests := []struct {
+		attributes string
+		pli, fir   bool
+	}{
+		{"a=rtcp-fb:96 nack pli\r\n", true, false},
+		{"a=rtcp-fb:96 ccm fir\r\n", false, true},
+		{"a=rtcp-fb:* nack pli\r\na=rtcp-fb:* ccm fir\r\n", true, true},
+		{"a=rtcp-fb:96 nack\r\n", false, false},
+		// the feedback of another format.
+		{"a=rtcp-fb:97 nack pli\r\n", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, "v=0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n"+test.attributes, nil)
+		if pli, fir := keyframeFeedback(s.streams[0].Sdp); pli != test.pli || fir != test.fir {
+			t.Errorf("%q: pli %v fir %v", test.attributes, pli, fir)
+		}
+	}
+}
+
+func TestRequestKeyframe(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	s := newTestSession(t, "v=0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=rtcp-fb:96 ccm fir\r\n"+
+		"m=audio 0 RTP/AVP 0\r\n", client)
+	if err := s.RequestKeyframe(); err != ErrNoKeyframeRequest {
+		t.Errorf("request without a source: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		s.stats.Update(rtp.Packet{SequenceNumber: uint(1 + i), SyncSource: 0xcafe}, time.Now(), 90000)
+	}
+
+	// the requests sent at once are numbered one after another.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- s.RequestKeyframe() }()
+	}
+	var seqs []uint8
+	for i := 0; i < 2; i++ {
+		channel, messages := readCompound(t, server)
+		fir, ok := messages[len(messages)-1].(*rtcp.FullIntraRequest)
+		if channel != 1 || !ok || fir.SenderSSRC != s.ssrc || len(fir.Entries) != 1 || fir.Entries[0].SSRC != 0xcafe {
+			t.Fatalf("channel %d, request %+v", channel, messages[len(messages)-1])
+		}
+		seqs = append(seqs, fir.Entries[0].SequenceNumber)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if seqs[0] != 1 || seqs[1] != 2 {
+		t.Errorf("sequence numbers %v", seqs)
+	}
+}
+
+func TestReceiverRtt(t *testing.T) {
+	tests := []struct {
+		session, media string
+		rtt            bool
+	}{
+		{"", "a=rtcp-xr:rcvr-rtt=all\r\n", true},
+		{"", "a=rtcp-xr:pkt-loc rcvr-rtt=all:10000 stat-summary\r\n", true},
+		{"a=rtcp-xr:rcvr-rtt=all\r\n", "", true},
+		// the media overrides the session.
+		{"a=rtcp-xr:rcvr-rtt=all\r\n", "a=rtcp-xr:stat-summary\r\n", false},
+		// the client sends no rtp.
+		{"", "a=rtcp-xr:rcvr-rtt=sender\r\n", false},
+		{"", "a=rtcp-xr:pkt-loc\r\n", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, "v=0\r\n"+test.session+"m=video 0 RTP/AVP 96\r\n"+test.media, nil)
+		if rtt := receiverRtt(s.sdp, s.streams[0].Sdp); rtt != test.rtt {
+			t.Errorf("%q %q: %v", test.session, test.media, rtt)
+		}
+	}
+}
+
+func TestHandleExtendedReport(t *testing.T) {
+	s := newTestSession(t, "v=0\r\nm=video 0 RTP/AVP 96\r\n", nil)
+	for i := 0; i < 3; i++ {
+		s.stats.Update(rtp.Packet{SequenceNumber: uint(1 + i), SyncSource: 0xcafe}, time.Now(), 90000)
+	}
+	roundTrip := func() time.Duration {
+		for _, stats := range s.stats.Stats() {
+			if stats.SSRC == 0xcafe {
+				return stats.RoundTripTime
+			}
+		}
+		t.Fatal("no stats of the source")
+		return 0
+	}
+
+	// the reference time was sent at sent, held half a second by the server
+	// and answered at arrival.
+	sent := time.Unix(1700000000, 0)
+	arrival := sent.Add(800 * time.Millisecond)
+	dlrr := func(ssrc uint32) *rtcp.ExtendedReport {
+		return &rtcp.ExtendedReport{SSRC: 0xcafe, Blocks: []rtcp.ReportBlock{&rtcp.DLRRBlock{Reports: []rtcp.DLRRReport{
+			{SSRC: ssrc, LastReceiverTime: uint32(rtcp.ToNTP(sent) >> 16), Delay: 65536 / 2},
+		}}}}
+	}
+	s.handleExtendedReport(dlrr(0x55667788), arrival)
+	if rtt := roundTrip(); rtt != 0 {
+		t.Errorf("round trip %v from a report to another receiver", rtt)
+	}
+	s.handleExtendedReport(dlrr(s.ssrc), arrival)
+	if rtt := roundTrip(); rtt < 299*time.Millisecond || rtt > 301*time.Millisecond {
+		t.Errorf("round trip %v, want 300ms", rtt)
+	}
+}
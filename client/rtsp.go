@@ -3,14 +3,18 @@ package client
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/WUMUXIAN/go-common-utils/timeutil"
 	"github.com/solomondong/rtsp/rtcp"
@@ -167,9 +171,36 @@ type Session struct {
 	state int
 
 	streams []*Stream
-
-	rtpChan  chan rtp.Packet
-	rtcpChan chan rtcp.Packet
+	// sdp is the description of the presentation.
+	sdp sdp.SessionSection
+
+	rtpChan chan rtp.Packet
+
+	// OnRTCP, when set before Play, is called with the rtcp packets
+	// received on the streams. It is called from the goroutines reading the
+	// streams and must not block.
+	OnRTCP func(rtcp.Compound)
+	// OnStreamEvent, when set before Play, is called when the source of a
+	// stream changes or leaves. It is called from the goroutines reading the
+	// streams and must not block.
+	OnStreamEvent func(StreamEvent)
+	// eof is closed once the sources of all streams left.
+	eof     chan struct{}
+	eofOnce sync.Once
+
+	stats *rtp.ReceiverStats
+
+	// udp receives the streams set up over udp.
+	udp  *rtp.UDPSession
+	done chan struct{}
+
+	// ssrc and cname identify the client in the rtcp it sends.
+	ssrc        uint32
+	cname       string
+	reportMu    sync.Mutex
+	avgRtcpSize float64
+	// writeMu keeps the requests and the interleaved packets apart.
+	writeMu sync.Mutex
 
 	resChan chan Response
 	errChan chan error
@@ -195,7 +226,7 @@ func NewSession(rtspAddr string) (session *Session, err error) {
 	if err != nil {
 		return nil, err
 	}
-	if url.Scheme != "rtsp" {
+	if url.Scheme != "rtsp" && url.Scheme != "rtsps" {
 		return nil, errors.New("invalid rtsp address")
 	}
 	session = new(Session)
@@ -208,18 +239,31 @@ func NewSession(rtspAddr string) (session *Session, err error) {
 	session.uri = rtspAddr //url.Scheme + "://" + url.Host
 	session.host = url.Host
 
-	session.conn, err = net.Dial("tcp", session.host)
+	if url.Scheme == "rtsps" {
+		if url.Port() == "" {
+			session.host = net.JoinHostPort(url.Hostname(), "322")
+		}
+		session.conn, err = tls.Dial("tcp", session.host, &tls.Config{ServerName: url.Hostname()})
+	} else {
+		if url.Port() == "" {
+			session.host = net.JoinHostPort(url.Hostname(), "554")
+		}
+		session.conn, err = net.Dial("tcp", session.host)
+	}
 	if err != nil {
 		return
 	}
 	session.bufConn = bufio.NewReader(session.conn)
 
 	rtpChan := make(chan rtp.Packet, 10)
-	rtcpChan := make(chan rtcp.Packet, 10)
 	resChan := make(chan Response, 10)
 
 	session.rtpChan = rtpChan
-	session.rtcpChan = rtcpChan
+	session.stats = rtp.NewReceiverStats()
+	session.done = make(chan struct{})
+	session.eof = make(chan struct{})
+	session.ssrc = rand.Uint32()
+	session.cname = cname()
 	session.resChan = resChan
 	session.errChan = make(chan error, 100)
 
@@ -261,10 +305,9 @@ func (s *Session) nextCSeq() string {
 
 func (s *Session) injectAuthencitationInfo(request *Request, method string) {
 	if s.Digest.Nonce != "" {
-		digest := fmt.Sprintf("Digest username=\"%s\", realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"",
-			s.Digest.UserName, s.Digest.Realm, s.Digest.Nonce, s.uri, s.Digest.GetDigestResponse(method, s.uri))
+		// the digest covers the url of the request itself.
 		request.Header["Authorization"] = []string{
-			digest,
+			s.Digest.Authorization(method, request.URL.String()),
 		}
 	}
 }
@@ -284,6 +327,10 @@ func (s *Session) handleUnauthorized(response Response) {
 					}
 					if dgFieldDetails[0] == "nonce" {
 						s.Digest.Nonce = strings.Trim(dgFieldDetails[1], "\"")
+						s.Digest.NonceCount = 0
+					}
+					if dgFieldDetails[0] == "qop" {
+						s.Digest.Qop = strings.Trim(dgFieldDetails[1], "\"")
 					}
 				}
 			}
@@ -297,6 +344,8 @@ func (s *Session) sendRequest(req *Request) error {
 		return errors.New("connection not established")
 	}
 	fmt.Println(req)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
 	_, err := io.WriteString(s.conn, req.String())
 	return err
 }
@@ -340,11 +389,16 @@ func (s *Session) Describe() error {
 	}
 
 	// let's parse the SDP response and create the stream
-	p, err := sdp.ParseSdp(bytes.NewBuffer(res.Body))
+	// cameras write invalid descriptions, what can be parsed is kept.
+	p, warnings, err := sdp.Parse(bytes.NewBuffer(res.Body), false)
 	if err != nil {
 		return err
 	}
+	for _, warning := range warnings {
+		fmt.Println("rtsp:", warning)
+	}
 
+	s.sdp = p
 	if p.Originator.SessionID != "" {
 		s.SessionID = p.Originator.SessionID
 	}
@@ -352,12 +406,13 @@ func (s *Session) Describe() error {
 	fmt.Printf("The Parsed Sdp: %+v\n", p)
 
 	// After describing, we can create the stream already.
+	timeline := &timeline{}
 	for _, media := range p.Medias {
-		stream := &Stream{Sdp: media}
+		stream := &Stream{Sdp: media, fec: newFECDecoder(media), timeline: timeline}
 		stream.MakeCodecData()
 		s.streams = append(s.streams, stream)
 		if media.Type == "video" {
-			// Control:rtsp Framerate:0 Rtpmap:96 CodecType:H264 TimeScale:90000 
+			// Control:rtsp://host/trackID=0 Framerate:0 Rtpmap:96 CodecType:H264 TimeScale:90000 
 			s.MediaControl = media.Control
 			s.Framerate = media.Framerate
 			s.Rtpmap = media.Rtpmap
@@ -376,24 +431,85 @@ func (s *Session) Setup() error {
 	if s.state != StateDescribed {
 		return errors.New("not described yet")
 	}
+	if s.udp == nil {
+		s.udp = rtp.NewUDPSessionConfig(rtp.DefaultUDPConfig)
+		s.udp.SSRC = s.ssrc
+		go s.readUDP(s.udp, s.rtpChan, s.done)
+		go s.report()
+	}
+	host, _, _ := net.SplitHostPort(s.conn.RemoteAddr().String())
 	// setup all streams.
-	for _, stream := range s.streams {
+	for idx, stream := range s.streams {
 		// req, err := s.newRequest(SETUP, s.uri+"/"+stream.Sdp.Control, s.nextCSeq(), nil)
 		req, err := s.newRequest(SETUP, stream.Sdp.Control, s.nextCSeq(), nil)
 		if err != nil {
 			return err
 		}
+		rtpConn, rtcpConn, err := rtp.ListenUDPPair()
+		if err != nil {
+			return err
+		}
 		// req.Header.Add("Transport", stream.Sdp.Procotol+"/TCP")
-		req.Header.Add("Transport", stream.Sdp.Procotol+";unicast;client_port=56732-56733")
+		req.Header.Add("Transport", fmt.Sprintf("%s;unicast;client_port=%d-%d", stream.Sdp.Procotol,
+			rtpConn.LocalAddr().(*net.UDPAddr).Port, rtcpConn.LocalAddr().(*net.UDPAddr).Port))
+		if s.session != "" {
+			req.Header.Add("Session", s.session)
+		}
 		err = s.sendRequest(req)
 		if err != nil {
+			rtpConn.Close()
+			rtcpConn.Close()
 			return err
 		}
 
-		_, err = s.readResponse()
+		res, err := s.readResponse()
 		if err != nil {
+			rtpConn.Close()
+			rtcpConn.Close()
 			return err
 		}
+		transport := res.Header.Get("Transport")
+		ssrc := parseTransportSSRC(transport)
+		if ssrc != 0 {
+			stream.ssrcs.lock(ssrc)
+		}
+		if isSecureProfile(stream.Sdp.Procotol) {
+			if stream.srtp, err = newSRTPContext(stream.Sdp, s.sdp, ssrc); err != nil {
+				rtpConn.Close()
+				rtcpConn.Close()
+				return err
+			}
+		}
+		if channel, ok := parseTransportInterleaved(transport); ok {
+			// the server sends the stream over the rtsp connection instead.
+			rtpConn.Close()
+			rtcpConn.Close()
+			stream.interleaved = true
+			stream.rtcpChannel = channel
+			continue
+		}
+		rtpSource, rtcpSource := parseTransportSource(transport, net.ParseIP(host))
+		rtx, nack := retransmission(stream.Sdp)
+		udpStream := &rtp.UDPStream{
+			Idx:        uint(idx),
+			Rtp:        rtpConn,
+			Rtcp:       rtcpConn,
+			RtpSource:  rtpSource,
+			RtcpSource: rtcpSource,
+			SRTP:       stream.srtp,
+			RTX:        rtx,
+			NACK:       nack,
+			FEC:        stream.fec,
+		}
+		if err = s.udp.AddStream(udpStream); err != nil {
+			rtpConn.Close()
+			rtcpConn.Close()
+			return err
+		}
+		// open the way through NATs for the packets of the server.
+		if err = s.udp.Punch(udpStream); err != nil {
+			fmt.Println("rtsp: udp pinhole failed", err)
+		}
 	}
 	s.state = StateSetuped
 	return nil
@@ -473,13 +589,55 @@ func (s *Session) poll() {
 				return
 			}
 
+			var stream *Stream
+			if idx := int(channel / 2); idx < len(s.streams) {
+				stream = s.streams[idx]
+			}
+
+			// secure streams are decrypted first.
+			if stream != nil && stream.srtp != nil {
+				if channel%2 == 0 {
+					data, err = stream.srtp.DecryptRTP(data, data)
+				} else {
+					data, err = stream.srtp.DecryptRTCP(data, data)
+				}
+				if err != nil {
+					fmt.Println("err, srtp packet rejected", err)
+					continue
+				}
+			}
+
 			// this means it's RTP
 			if channel%2 == 0 {
-				s.rtpChan <- rtp.ParsePacket(data, channel/2)
+				rtpPacket, err := rtp.ParsePacket(data, channel/2)
+				if err != nil {
+					fmt.Println("err, malformed rtp packet", err)
+					continue
+				}
+				packets := []rtp.Packet{rtpPacket}
+				if stream != nil && stream.fec != nil {
+					packets = stream.fec.Push(rtpPacket)
+				}
+				for _, rtpPacket := range packets {
+					// the decoder keeps a copy of the repair packets.
+					if stream != nil && stream.fec != nil && stream.fec.IsRepair(rtpPacket) {
+						rtp.Release(rtpPacket)
+						continue
+					}
+					if !s.trackSource(rtpPacket) {
+						rtp.Release(rtpPacket)
+						continue
+					}
+					s.updateStats(rtpPacket)
+					s.rtpChan <- rtpPacket
+				}
 			} else {
-				s.rtcpChan <- rtcp.ParsePacket(data)
-				// TODO: remove this if rtcp packet is used later.
-				<-s.rtcpChan
+				messages, err := rtcp.ParseCompound(data)
+				if err != nil {
+					fmt.Println("err, malformed rtcp packet", err)
+					continue
+				}
+				s.handleRtcp(rtcp.Compound{StreamIdx: channel / 2, Messages: messages})
 			}
 		} else if b == 'R' {
 			header := make([]byte, 3)
@@ -539,6 +697,44 @@ func (s *Session) poll() {
 	}
 }
 
+// handleRtcp keeps the sender reports received on a stream for the receiver
+// reports and the capture times, measures the round trip time from the
+// extended reports, ends the stream on a BYE of its source, and hands the
+// rtcp packets over to OnRTCP.
+func (s *Session) handleRtcp(compound rtcp.Compound) {
+	now := time.Now()
+	for _, message := range compound.Messages {
+		switch message := message.(type) {
+		case *rtcp.SenderReport:
+			s.stats.SenderReport(message.SSRC, message.NTPTime, now)
+			if int(compound.StreamIdx) < len(s.streams) {
+				s.streams[compound.StreamIdx].HandleSenderReport(message)
+			}
+		case *rtcp.ExtendedReport:
+			s.handleExtendedReport(message, now)
+		case *rtcp.Goodbye:
+			s.handleBye(int(compound.StreamIdx), message)
+		}
+	}
+	if s.OnRTCP != nil {
+		s.OnRTCP(compound)
+	}
+}
+
+// updateStats accounts for a received rtp packet in the session statistics.
+func (s *Session) updateStats(packet rtp.Packet) {
+	clockRate := 0
+	if int(packet.StreamIdx) < len(s.streams) {
+		clockRate = s.streams[packet.StreamIdx].timeScale(packet.PayloadType)
+	}
+	s.stats.Update(packet, time.Now(), clockRate)
+}
+
+// Stats returns the reception statistics of the rtp sources of the session.
+func (s *Session) Stats() []rtp.SourceStats {
+	return s.stats.Stats()
+}
+
 func (s *Session) keepAlive() {
 	req, err := s.newRequest(GETPARAMETER, s.uri, s.nextCSeq(), nil)
 	if err != nil {
@@ -559,6 +755,18 @@ func (s *Session) keepAlive() {
 
 // ReadAVPacket tried to read an av packet for the stream
 func (s *Session) ReadAVPacket() (avPacket *av.Packet, err error) {
+	packet, err := s.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return &packet.Packet, nil
+}
+
+// ReadPacket reads an av packet of the streams along with the time it was
+// captured at. The times of the packets of all streams are on one timeline
+// once the sender reports map them. It returns io.EOF once the sources of
+// all streams left with a RTCP BYE.
+func (s *Session) ReadPacket() (packet *Packet, err error) {
 	if s.state < StateWaitCodecData {
 		return nil, errors.New("stream not played yet")
 	}
@@ -575,8 +783,12 @@ func (s *Session) ReadAVPacket() (avPacket *av.Packet, err error) {
 	for {
 		if s.state != StateReadyForAVPacket {
 			// Let's read a RTP packet out.
-			rtpPacket := <-s.rtpChan
+			var rtpPacket rtp.Packet
+			if rtpPacket, err = s.readRtp(); err != nil {
+				return
+			}
 			s.streams[rtpPacket.StreamIdx].HandleRtpPacket(rtpPacket)
+			rtp.Release(rtpPacket)
 		} else {
 			break
 		}
@@ -588,15 +800,21 @@ func (s *Session) ReadAVPacket() (avPacket *av.Packet, err error) {
 	}
 
 	for {
-		rtpPacket := <-s.rtpChan
+		var rtpPacket rtp.Packet
+		if rtpPacket, err = s.readRtp(); err != nil {
+			return
+		}
 		var pkt av.Packet
 		var ok bool
-		pkt, ok, err = s.streams[rtpPacket.StreamIdx].HandleRtpPacket(rtpPacket)
+		stream := s.streams[rtpPacket.StreamIdx]
+		pkt, ok, err = stream.HandleRtpPacket(rtpPacket)
+		// the stream copies what it keeps, the buffer can be reused.
+		rtp.Release(rtpPacket)
 		if err != nil {
 			return
 		}
 		if ok {
-			avPacket = &pkt
+			packet = &Packet{Packet: pkt, CaptureTime: stream.CaptureTime()}
 			return
 		}
 	}
@@ -630,11 +848,19 @@ func (s *Session) readResponse() (res *Response, err error) {
 func ParseRTSPVersion(s string) (proto string, major int, minor int, err error) {
 	parts := strings.SplitN(s, "/", 2)
 	proto = parts[0]
-	parts = strings.SplitN(parts[1], ".", 2)
+	if len(parts) != 2 {
+		err = errors.New("rtsp: malformed version " + s)
+		return
+	}
+	parts = strings.SplitN(strings.TrimSpace(parts[1]), ".", 2)
+	if len(parts) != 2 {
+		err = errors.New("rtsp: malformed version " + s)
+		return
+	}
 	if major, err = strconv.Atoi(parts[0]); err != nil {
 		return
 	}
-	if minor, err = strconv.Atoi(parts[0]); err != nil {
+	if minor, err = strconv.Atoi(parts[1]); err != nil {
 		return
 	}
 	return
@@ -649,9 +875,16 @@ func toUint(arr []byte) (ret uint) {
 
 func (s *Session) Close() {
 	if s != nil {
+		if s.state >= StateSetuped {
+			// tell the server the client leaves.
+			s.sendReports(true)
+		}
+		close(s.done)
+		if s.udp != nil {
+			s.udp.Close()
+		}
 		s.bufConn = nil
 		s.rtpChan = nil
-		s.rtcpChan = nil
 		s.resChan = nil
 		s.errChan = nil
 		s.conn.Close()
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// isSecureProfile tells whether a transport profile carries srtp.
+func isSecureProfile(profile string) bool {
+	return strings.HasPrefix(profile, "RTP/SAVP")
+}
+
+// parseMIKEY decodes a MIKEY message sent in a key-mgmt attribute or a k=
+// line, "mikey <base64>" and "base64:<base64>" are accepted.
+func parseMIKEY(value string) (*rtp.MIKEY, bool) {
+	var data string
+	switch {
+	case strings.HasPrefix(value, "mikey "):
+		data = strings.TrimPrefix(value, "mikey ")
+	case strings.HasPrefix(value, "base64:"):
+		data = strings.TrimPrefix(value, "base64:")
+	default:
+		return nil, false
+	}
+	msg, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, false
+	}
+	mikey, err := rtp.ParseMIKEY(msg)
+	return mikey, err == nil
+}
+
+// newSRTPContext creates the srtp context of a secure stream from the keys
+// of its SDP, looked up in the crypto attributes, then the key-mgmt
+// attributes and k= lines of the media and of the session. ssrc selects the
+// MIKEY crypto session, 0 when the server didn't tell.
+func newSRTPContext(media sdp.SessionSectionMedia, session sdp.SessionSection, ssrc uint32) (*rtp.SRTPContext, error) {
+	for _, crypto := range media.Cryptos {
+		if c, err := rtp.NewSRTPContextSDES(crypto.Suite, crypto.KeyParams); err == nil {
+			return c, nil
+		}
+	}
+
+	var candidates []string
+	for _, keyMgmt := range media.KeyMgmts {
+		if keyMgmt.Protocol == "mikey" {
+			candidates = append(candidates, "mikey "+keyMgmt.Data)
+		}
+	}
+	candidates = append(candidates, media.EncryptionKey)
+	for _, keyMgmt := range session.KeyMgmts {
+		if keyMgmt.Protocol == "mikey" {
+			candidates = append(candidates, "mikey "+keyMgmt.Data)
+		}
+	}
+	candidates = append(candidates, session.EncryptionKey)
+	for _, candidate := range candidates {
+		if mikey, ok := parseMIKEY(candidate); ok {
+			return mikey.SRTPContext(ssrc)
+		}
+	}
+	return nil, errors.New("rtsp: no usable srtp key for " + media.Type)
+}
+
+// parseTransportSSRC returns the ssrc of the Transport header of a SETUP
+// response, 0 if there's none.
+func parseTransportSSRC(header string) uint32 {
+	for _, param := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && kv[0] == "ssrc" {
+			ssrc, _ := strconv.ParseUint(kv[1], 16, 32)
+			return uint32(ssrc)
+		}
+	}
+	return 0
+}
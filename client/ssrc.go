@@ -0,0 +1,145 @@
+package client
+
+import (
+	"io"
+	"sync"
+
+	"github.com/solomondong/rtsp/rtcp"
+	"github.com/solomondong/rtsp/rtp"
+)
+
+// Types of stream events.
+const (
+	// StreamEventSSRCChange tells a stream comes from a new source, such as
+	// a camera whose encoder restarted. The time of its packets goes on
+	// from the time of the previous source.
+	StreamEventSSRCChange = iota + 1
+	// StreamEventBye tells the source of a stream left with a RTCP BYE.
+	StreamEventBye
+)
+
+// StreamEvent is a change of the source of a stream.
+type StreamEvent struct {
+	Type      int
+	StreamIdx int
+	SSRC      uint32
+	// PreviousSSRC is the source before a change.
+	PreviousSSRC uint32
+	// Reason is the reason of a BYE, if it gives one.
+	Reason string
+}
+
+// ssrcTracker follows the source a stream comes from, it is shared by the
+// goroutines reading the rtp and rtcp of the stream.
+type ssrcTracker struct {
+	mu    sync.Mutex
+	ssrc  uint32
+	known bool
+	// locked takes the packets of ssrc only, as given by the Transport
+	// header of the SETUP response.
+	locked bool
+	ended  bool
+}
+
+// lock takes the packets of ssrc only.
+func (t *ssrcTracker) lock(ssrc uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ssrc, t.known, t.locked = ssrc, true, true
+}
+
+// update follows the source of a packet, telling whether the packet is taken
+// and the event it makes, if any.
+func (t *ssrcTracker) update(packet rtp.Packet) (ok bool, event *StreamEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ssrc := uint32(packet.SyncSource)
+	switch {
+	case !t.known:
+		t.ssrc, t.known = ssrc, true
+	case ssrc == t.ssrc:
+		// late packets of a source that left are dropped.
+		return !t.ended, nil
+	case t.locked:
+		return false, nil
+	default:
+		event = &StreamEvent{Type: StreamEventSSRCChange, StreamIdx: int(packet.StreamIdx), SSRC: ssrc, PreviousSSRC: t.ssrc}
+		t.ssrc = ssrc
+		t.ended = false
+	}
+	return true, event
+}
+
+// bye ends the stream if the current source is one of those leaving.
+func (t *ssrcTracker) bye(sources []uint32) (ssrc uint32, ended bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.known || t.ended {
+		return 0, false
+	}
+	for _, source := range sources {
+		if source == t.ssrc {
+			t.ended = true
+			return source, true
+		}
+	}
+	return 0, false
+}
+
+// isEnded tells whether the source of the stream left.
+func (t *ssrcTracker) isEnded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ended
+}
+
+// trackSource follows the source of a packet of a stream, telling whether
+// the packet is taken.
+func (s *Session) trackSource(packet rtp.Packet) bool {
+	if int(packet.StreamIdx) >= len(s.streams) {
+		return false
+	}
+	ok, event := s.streams[packet.StreamIdx].ssrcs.update(packet)
+	if event != nil && s.OnStreamEvent != nil {
+		s.OnStreamEvent(*event)
+	}
+	return ok
+}
+
+// handleBye ends a stream when its source leaves, and the session once all
+// the streams ended.
+func (s *Session) handleBye(idx int, bye *rtcp.Goodbye) {
+	if idx >= len(s.streams) {
+		return
+	}
+	ssrc, ended := s.streams[idx].ssrcs.bye(bye.Sources)
+	if !ended {
+		return
+	}
+	if s.OnStreamEvent != nil {
+		s.OnStreamEvent(StreamEvent{Type: StreamEventBye, StreamIdx: idx, SSRC: ssrc, Reason: bye.Reason})
+	}
+	for _, stream := range s.streams {
+		if !stream.ssrcs.isEnded() {
+			return
+		}
+	}
+	s.eofOnce.Do(func() { close(s.eof) })
+}
+
+// readRtp reads the next rtp packet of the streams, io.EOF once the sources
+// of all of them left.
+func (s *Session) readRtp() (rtp.Packet, error) {
+	select {
+	case packet := <-s.rtpChan:
+		return packet, nil
+	case <-s.eof:
+		// the packets received before the BYE go first.
+		select {
+		case packet := <-s.rtpChan:
+			return packet, nil
+		default:
+			return rtp.Packet{}, io.EOF
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/solomondong/rtsp/rtcp"
+	"github.com/solomondong/rtsp/rtp"
+)
+
+func TestSSRCTracker(t *testing.T) {
+	var tracker ssrcTracker
+	if ok, event := tracker.update(rtp.Packet{SyncSource: 1}); !ok || event != nil {
+		t.Errorf("first source: %v %+v", ok, event)
+	}
+	if ok, event := tracker.update(rtp.Packet{SyncSource: 1}); !ok || event != nil {
+		t.Errorf("same source: %v %+v", ok, event)
+	}
+	// a new source takes over the stream.
+	ok, event := tracker.update(rtp.Packet{SyncSource: 2, StreamIdx: 1})
+	want := &StreamEvent{Type: StreamEventSSRCChange, StreamIdx: 1, SSRC: 2, PreviousSSRC: 1}
+	if !ok || !reflect.DeepEqual(event, want) {
+		t.Errorf("new source: %v %+v", ok, event)
+	}
+
+	// a BYE of another source leaves the stream on.
+	if _, ended := tracker.bye([]uint32{1, 3}); ended || tracker.isEnded() {
+		t.Error("ended by the BYE of another source")
+	}
+	if ssrc, ended := tracker.bye([]uint32{3, 2}); !ended || ssrc != 2 || !tracker.isEnded() {
+		t.Errorf("BYE of the source: %d %v", ssrc, ended)
+	}
+	if _, ended := tracker.bye([]uint32{2}); ended {
+		t.Error("ended twice")
+	}
+	// the late packets of the source are dropped, until another one comes.
+	if ok, _ := tracker.update(rtp.Packet{SyncSource: 2}); ok {
+		t.Error("packet taken after the BYE")
+	}
+	if ok, event := tracker.update(rtp.Packet{SyncSource: 4}); !ok || event == nil || tracker.isEnded() {
+		t.Errorf("source after the BYE: %v %+v", ok, event)
+	}
+}
+
+func TestSSRCTrackerLock(t *testing.T) {
+	var tracker ssrcTracker
+	tracker.lock(5)
+	if ok, _ := tracker.update(rtp.Packet{SyncSource: 6}); ok {
+		t.Error("packet of another source taken")
+	}
+	if ok, event := tracker.update(rtp.Packet{SyncSource: 5}); !ok || event != nil {
+		t.Errorf("packet of the source: %v %+v", ok, event)
+	}
+	if _, ended := tracker.bye([]uint32{6}); ended {
+		t.Error("ended by the BYE of another source")
+	}
+}
+
+func TestReadRtpBye(t *testing.T) {
+	s := newTestSession(t, "v=0\r\nm=video 0 RTP/AVP 96\r\nm=audio 0 RTP/AVP 0\r\n", nil)
+	var events []StreamEvent
+	s.OnStreamEvent = func(event StreamEvent) { events = append(events, event) }
+	for _, packet := range []rtp.Packet{
+		{StreamIdx: 0, SyncSource: 10, SequenceNumber: 1},
+		{StreamIdx: 1, SyncSource: 20, SequenceNumber: 1},
+		{StreamIdx: 0, SyncSource: 10, SequenceNumber: 2},
+	} {
+		if !s.trackSource(packet) {
+			t.Fatalf("packet not taken %+v", packet)
+		}
+		s.rtpChan <- packet
+	}
+
+	s.handleRtcp(rtcp.Compound{StreamIdx: 0, Messages: []rtcp.Message{&rtcp.Goodbye{Sources: []uint32{10}}}})
+	select {
+	case <-s.eof:
+		t.Fatal("session ended with a stream left")
+	default:
+	}
+	s.handleRtcp(rtcp.Compound{StreamIdx: 1, Messages: []rtcp.Message{&rtcp.Goodbye{Sources: []uint32{20}, Reason: "done"}}})
+	want := []StreamEvent{
+		{Type: StreamEventBye, StreamIdx: 0, SSRC: 10},
+		{Type: StreamEventBye, StreamIdx: 1, SSRC: 20, Reason: "done"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events %+v", events)
+	}
+
+	// the packets received before the BYEs go first.
+	for i := 0; i < 3; i++ {
+		if _, err := s.readRtp(); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+	}
+	if _, err := s.readRtp(); err != io.EOF {
+		t.Errorf("read after the BYEs: %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/solomondong/rtsp/rtp"
@@ -29,15 +31,78 @@ type Stream struct {
 	spsChanged bool
 	ppsChanged bool
 
-	gotpkt         bool
-	pkt            av.Packet
-	timestamp      uint32
-	firsttimestamp uint32
+	gotpkt    bool
+	pkt       av.Packet
+	timestamp int64
+
+	// sources keeps the extended sequence numbers and timestamps by SSRC.
+	sources  map[uint]*source
+	lastssrc uint
 
 	lasttime time.Duration
+
+	// srtp decrypts the packets of a secure (RTP/SAVP) stream.
+	srtp *rtp.SRTPContext
+	// fec unwraps RED packets and rebuilds the lost ones from FEC.
+	fec *rtp.FECDecoder
+
+	// interleaved tells the stream comes over the rtsp connection, its rtcp
+	// is sent on rtcpChannel.
+	interleaved bool
+	rtcpChannel byte
+
+	// reports maps the rtp timestamps of the sources to the wall clock, as
+	// their sender reports tell.
+	srMu        sync.Mutex
+	reports     map[uint]senderReport
+	capturetime time.Time
+	// timeline, when set, is shared with the other streams of the session.
+	timeline *timeline
+
+	// firSeq numbers the full intra requests of the stream.
+	firSeq uint8
+
+	// ssrcs follows the source the stream comes from.
+	ssrcs ssrcTracker
+}
+
+// source is a sender of the stream, identified by its SSRC.
+type source struct {
+	seq            rtp.SequenceTracker
+	ts             rtp.TimestampUnwrapper
+	lastseq        uint64
+	hasfirst       bool
+	firsttimestamp int64
+	// timeoffset keeps the stream time going on when the sender changes.
+	timeoffset time.Duration
+}
+
+// source finds the state of the sender of a packet, a new sender starts at
+// the time the previous one stopped.
+func (self *Stream) source(ssrc uint) *source {
+	if self.sources == nil {
+		self.sources = make(map[uint]*source)
+	}
+	if ssrc != self.lastssrc {
+		// fragments of the previous sender can't be completed, and its
+		// timestamps are of no use to the next one: a sender coming back
+		// starts over.
+		self.fuStarted = false
+		delete(self.sources, self.lastssrc)
+		self.srMu.Lock()
+		delete(self.reports, self.lastssrc)
+		self.srMu.Unlock()
+		self.lastssrc = ssrc
+	}
+	src, ok := self.sources[ssrc]
+	if !ok {
+		src = &source{timeoffset: self.lasttime}
+		self.sources[ssrc] = src
+	}
+	return src
 }
 
-func (self *Stream) handleH264Payload(timestamp uint32, packet []byte) (err error) {
+func (self *Stream) handleH264Payload(timestamp int64, packet []byte) (err error) {
 	if len(packet) < 2 {
 		err = fmt.Errorf("rtp: h264 packet too short")
 		return
@@ -82,22 +147,22 @@ func (self *Stream) handleH264Payload(timestamp uint32, packet []byte) (err erro
 	case naluType == 7: // sps
 		fmt.Println("rtsp: got sps")
 		if len(self.sps) == 0 {
-			self.sps = packet
+			self.sps = append([]byte(nil), packet...)
 			// self.MakeCodecData()
 		} else if bytes.Compare(self.sps, packet) != 0 {
 			self.spsChanged = true
-			self.sps = packet
+			self.sps = append([]byte(nil), packet...)
 			fmt.Println("rtsp: sps changed")
 		}
 
 	case naluType == 8: // pps
 		fmt.Println("rtsp: got pps")
 		if len(self.pps) == 0 {
-			self.pps = packet
+			self.pps = append([]byte(nil), packet...)
 			// self.MakeCodecData()
 		} else if bytes.Compare(self.pps, packet) != 0 {
 			self.ppsChanged = true
-			self.pps = packet
+			self.pps = append([]byte(nil), packet...)
 			fmt.Println("rtsp: pps changed")
 		}
 
@@ -218,7 +283,7 @@ func (self *Stream) handleH264Payload(timestamp uint32, packet []byte) (err erro
 	return
 }
 
-func (self *Stream) handleBuggyAnnexbH264Packet(timestamp uint32, packet []byte) (isBuggy bool, err error) {
+func (self *Stream) handleBuggyAnnexbH264Packet(timestamp int64, packet []byte) (isBuggy bool, err error) {
 	if len(packet) >= 4 && packet[0] == 0 && packet[1] == 0 && packet[2] == 0 && packet[3] == 1 {
 		isBuggy = true
 		if nalus, typ := h264parser.SplitNALUs(packet); typ != h264parser.NALU_RAW {
@@ -234,63 +299,94 @@ func (self *Stream) handleBuggyAnnexbH264Packet(timestamp uint32, packet []byte)
 	return
 }
 
+// format finds the format of a payload type of the stream, the first one of
+// the media when the sdp doesn't describe it.
+func (self *Stream) format(payloadType int) sdp.Format {
+	if format, ok := self.Sdp.Format(payloadType); ok {
+		return format
+	}
+	if len(self.Sdp.Formats) > 0 {
+		return self.Sdp.Formats[0]
+	}
+	return sdp.Format{
+		PayloadType:        payloadType,
+		EncodingName:       self.Sdp.CodecType,
+		ClockRate:          self.Sdp.TimeScale,
+		Config:             self.Sdp.Config,
+		SpropParameterSets: self.Sdp.SpropParameterSets,
+	}
+}
+
+// MakeCodecData makes the codec data of the first format of the media the
+// stream can depacketize.
 func (self *Stream) MakeCodecData() (err error) {
-	media := self.Sdp
+	formats := self.Sdp.Formats
+	if len(formats) == 0 {
+		formats = []sdp.Format{self.format(self.Sdp.PayloadType)}
+	}
+	for i, format := range formats {
+		e := self.makeCodecData(format)
+		if e == nil {
+			return nil
+		}
+		if i == 0 {
+			err = e
+		}
+	}
+	return
+}
 
-	if media.PayloadType >= 96 && media.PayloadType <= 127 {
-		switch media.CodecType {
-		case av.H264.String():
-			// this section is mainly used to set the sps and pps sections.
-			for _, nalu := range media.SpropParameterSets {
-				if len(nalu) > 0 {
-					self.handleH264Payload(0, nalu)
-				}
+func (self *Stream) makeCodecData(format sdp.Format) (err error) {
+	switch strings.ToUpper(format.EncodingName) {
+	case "H264":
+		// this section is mainly used to set the sps and pps sections.
+		for _, nalu := range format.SpropParameterSets {
+			if len(nalu) > 0 {
+				self.handleH264Payload(0, nalu)
 			}
+		}
 
-			// if sps and pps are all 0, we need to get the nalus from the Config and assign sps and pps again.
-			if len(self.sps) == 0 || len(self.pps) == 0 {
-				if nalus, typ := h264parser.SplitNALUs(media.Config); typ != h264parser.NALU_RAW {
-					for _, nalu := range nalus {
-						if len(nalu) > 0 {
-							self.handleH264Payload(0, nalu)
-						}
+		// if sps and pps are all 0, we need to get the nalus from the Config and assign sps and pps again.
+		if len(self.sps) == 0 || len(self.pps) == 0 {
+			if nalus, typ := h264parser.SplitNALUs(format.Config); typ != h264parser.NALU_RAW {
+				for _, nalu := range nalus {
+					if len(nalu) > 0 {
+						self.handleH264Payload(0, nalu)
 					}
 				}
 			}
+		}
 
-			// let's get it going.
-			if len(self.sps) > 0 && len(self.pps) > 0 {
-				if self.CodecData, err = h264parser.NewCodecDataFromSPSAndPPS(self.sps, self.pps); err != nil {
-					err = fmt.Errorf("rtsp: h264 sps/pps invalid: %s", err)
-					return
-				}
-			} else {
-				err = fmt.Errorf("rtsp: missing h264 sps or pps")
-				return
-			}
-
-		case av.AAC.String():
-			if len(media.Config) == 0 {
-				err = fmt.Errorf("rtsp: aac sdp config missing")
-				return
-			}
-			if self.CodecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(media.Config); err != nil {
-				err = fmt.Errorf("rtsp: aac sdp config invalid: %s", err)
+		// let's get it going.
+		if len(self.sps) > 0 && len(self.pps) > 0 {
+			if self.CodecData, err = h264parser.NewCodecDataFromSPSAndPPS(self.sps, self.pps); err != nil {
+				err = fmt.Errorf("rtsp: h264 sps/pps invalid: %s", err)
 				return
 			}
+		} else {
+			err = fmt.Errorf("rtsp: missing h264 sps or pps")
+			return
 		}
-	} else {
-		switch media.PayloadType {
-		case 0:
-			self.CodecData = codec.NewPCMMulawCodecData()
-
-		case 8:
-			self.CodecData = codec.NewPCMAlawCodecData()
 
-		default:
-			err = fmt.Errorf("rtsp: PayloadType=%d unsupported", media.PayloadType)
+	case "MPEG4-GENERIC":
+		if len(format.Config) == 0 {
+			err = fmt.Errorf("rtsp: aac sdp config missing")
+			return
+		}
+		if self.CodecData, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(format.Config); err != nil {
+			err = fmt.Errorf("rtsp: aac sdp config invalid: %s", err)
 			return
 		}
+
+	case "PCMU":
+		self.CodecData = codec.NewPCMMulawCodecData()
+
+	case "PCMA":
+		self.CodecData = codec.NewPCMAlawCodecData()
+
+	default:
+		err = fmt.Errorf("rtsp: PayloadType=%d %s unsupported", format.PayloadType, format.EncodingName)
+		return
 	}
 
 	return
@@ -303,8 +399,21 @@ func (self *Stream) isCodecDataChange() bool {
 	return false
 }
 
-func (self *Stream) timeScale() int {
-	t := self.Sdp.TimeScale
+// Extensions maps the rtp header extension ids of the stream to their URIs,
+// from the extmap attributes of its media description.
+func (self *Stream) Extensions() rtp.ExtensionMap {
+	m := make(rtp.ExtensionMap)
+	for _, extmap := range self.Sdp.Extmaps {
+		if extmap.ID > 0 && extmap.ID < 256 {
+			m[byte(extmap.ID)] = extmap.URI
+		}
+	}
+	return m
+}
+
+// timeScale returns the clock rate of the timestamps of a payload type.
+func (self *Stream) timeScale(payloadType byte) int {
+	t := self.format(int(payloadType)).ClockRate
 	if t == 0 {
 		// https://tools.ietf.org/html/rfc5391
 		t = 8000
@@ -318,7 +427,14 @@ func (self *Stream) HandleRtpPacket(packet rtp.Packet) (avPacket av.Packet, ok b
 		return
 	}
 
-	timestamp := packet.Timestamp
+	src := self.source(packet.SyncSource)
+	seq, _ := src.seq.Update(uint16(packet.SequenceNumber))
+	if self.fuStarted && seq != src.lastseq+1 {
+		// a fragment is missing, the nalu being reassembled is incomplete.
+		self.fuStarted = false
+	}
+	src.lastseq = seq
+	timestamp := src.ts.Unwrap(uint32(packet.Timestamp))
 	payload := packet.Payload
 
 	/*
@@ -363,48 +479,62 @@ func (self *Stream) HandleRtpPacket(packet rtp.Packet) (avPacket av.Packet, ok b
 		77-95	Unassigned	?
 		96-127	dynamic	?			[RFC3551]
 	*/
-	//payloadType := packet[1]&0x7f
-
-	switch self.Sdp.CodecType {
-	case av.H264.String():
-		if err = self.handleH264Payload(uint32(timestamp), payload); err != nil {
+	// the depacketizer follows the payload type of each packet, a media may
+	// carry several formats.
+	switch strings.ToUpper(self.format(int(packet.PayloadType)).EncodingName) {
+	case "H264":
+		if err = self.handleH264Payload(timestamp, payload); err != nil {
 			return
 		}
 
-	case av.AAC.String():
+	case "MPEG4-GENERIC":
 		if len(payload) < 4 {
 			err = fmt.Errorf("rtp: aac packet too short")
 			return
 		}
 		payload = payload[4:] // TODO: remove this hack
 		self.gotpkt = true
-		self.pkt.Data = payload
-		self.timestamp = uint32(timestamp)
+		// the rtp buffer may be reused once the packet is handled.
+		self.pkt.Data = append([]byte(nil), payload...)
+		self.timestamp = timestamp
 
 	default:
 		self.gotpkt = true
-		self.pkt.Data = payload
-		self.timestamp = uint32(timestamp)
+		self.pkt.Data = append([]byte(nil), payload...)
+		self.timestamp = timestamp
 	}
 
 	if self.gotpkt {
-		/*
-			TODO: sync AV by rtcp NTP timestamp
-			TODO: handle timestamp overflow
-			https://tools.ietf.org/html/rfc3550
-			A receiver can then synchronize presentation of the audio and video packets by relating
-			their RTP timestamps using the timestamp pairs in RTCP SR packets.
-		*/
-		if self.firsttimestamp == 0 {
-			self.firsttimestamp = self.timestamp
+		if !src.hasfirst {
+			src.hasfirst = true
+			src.firsttimestamp = self.timestamp
 		}
-		self.timestamp -= self.firsttimestamp
+		elapsed := self.timestamp - src.firsttimestamp
+		scale := int64(self.timeScale(packet.PayloadType))
 
 		ok = true
 		avPacket = self.pkt
-		avPacket.Time = time.Duration(self.timestamp) * time.Second / time.Duration(self.timeScale())
+		// split the division so long sessions don't overflow the duration.
+		avPacket.Time = src.timeoffset + time.Duration(elapsed/scale)*time.Second +
+			time.Duration(elapsed%scale)*time.Second/time.Duration(scale)
 		avPacket.Idx = int8(packet.StreamIdx)
 
+		// https://tools.ietf.org/html/rfc3550
+		// A receiver can then synchronize presentation of the audio and video packets by relating
+		// their RTP timestamps using the timestamp pairs in RTCP SR packets.
+		var synced bool
+		if self.capturetime, synced = self.captureTime(packet.SyncSource, packet.PayloadType, self.timestamp); synced {
+			if self.timeline == nil {
+				self.timeline = &timeline{}
+			}
+			// the first stream mapped goes on with its time, the others
+			// line up with it.
+			avPacket.Time = self.capturetime.Sub(self.timeline.base(self.capturetime.Add(-avPacket.Time)))
+			if avPacket.Time < self.lasttime {
+				avPacket.Time = self.lasttime
+			}
+		}
+
 		if avPacket.Time < self.lasttime || avPacket.Time-self.lasttime > time.Minute*30 {
 			err = fmt.Errorf("rtp: time invalid stream#%d time=%v lasttime=%v", avPacket.Idx, avPacket.Time, self.lasttime)
 			return
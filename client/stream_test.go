@@ -0,0 +1,39 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// testStream creates the stream of the first media of an sdp.
+func testStream(t *testing.T, description string) *Stream {
+	session, err := sdp.ParseSdp(strings.NewReader(description))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &Stream{Sdp: session.Medias[0]}
+	if err = stream.MakeCodecData(); err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+func TestHandleRtpPacketCopiesPayload(t *testing.T) {
+	stream := testStream(t, "v=0\r\nm=audio 0 RTP/AVP 0\r\n")
+	payload := bytes.Repeat([]byte{1}, 160)
+	pkt, ok, err := stream.HandleRtpPacket(rtp.Packet{SequenceNumber: 1, Timestamp: 8000, Payload: payload})
+	if err != nil || !ok {
+		t.Fatalf("packet not handled: %v", err)
+	}
+	// the buffer of a released packet is reused.
+	for i := range payload {
+		payload[i] = 2
+	}
+	if !bytes.Equal(pkt.Data, bytes.Repeat([]byte{1}, 160)) {
+		t.Error("av packet shares the rtp buffer")
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/solomondong/rtsp/rtcp"
+)
+
+// Packet is an av packet with the wall clock time it was captured at.
+type Packet struct {
+	av.Packet
+	// CaptureTime is the NTP time of the sender the packet was captured at,
+	// zero until a sender report maps the rtp timestamps of its stream.
+	CaptureTime time.Time
+}
+
+// timeline is the timeline shared by the streams of a session, so the
+// packets of all of them line up: it starts at the wall clock time of the
+// first packet mapped by a sender report.
+type timeline struct {
+	mu    sync.Mutex
+	start time.Time
+}
+
+// base returns the wall clock time of the start of the timeline, set to
+// start if it isn't yet.
+func (t *timeline) base(start time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.start.IsZero() {
+		t.start = start
+	}
+	return t.start
+}
+
+// senderReport maps a rtp timestamp of a source to the wall clock.
+type senderReport struct {
+	ntpTime time.Time
+	rtpTime uint32
+}
+
+// HandleSenderReport keeps the NTP and rtp timestamp pair of a sender report
+// of the stream, to tell the capture time of the following packets.
+func (self *Stream) HandleSenderReport(sr *rtcp.SenderReport) {
+	self.srMu.Lock()
+	defer self.srMu.Unlock()
+	if self.reports == nil {
+		self.reports = make(map[uint]senderReport)
+	}
+	self.reports[uint(sr.SSRC)] = senderReport{ntpTime: rtcp.FromNTP(sr.NTPTime), rtpTime: sr.RTPTime}
+}
+
+// captureTime maps a rtp timestamp of a source and payload type to the wall
+// clock, when a sender report of the source came.
+func (self *Stream) captureTime(ssrc uint, payloadType byte, timestamp int64) (time.Time, bool) {
+	self.srMu.Lock()
+	sr, ok := self.reports[ssrc]
+	self.srMu.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	// the timestamps are at most half their range from the report.
+	delta := int64(int32(uint32(timestamp) - sr.rtpTime))
+	scale := int64(self.timeScale(payloadType))
+	return sr.ntpTime.Add(time.Duration(delta/scale)*time.Second +
+		time.Duration(delta%scale)*time.Second/time.Duration(scale)), true
+}
+
+// CaptureTime returns the capture time of the last packet given out by
+// HandleRtpPacket, zero if no sender report maps it.
+func (self *Stream) CaptureTime() time.Time {
+	return self.capturetime
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/solomondong/rtsp/rtcp"
+	"github.com/solomondong/rtsp/rtp"
+)
+
+func TestCaptureTimeWrap(t *testing.T) {
+	stream := testStream(t, "v=0\r\nm=audio 0 RTP/AVP 0\r\n")
+	ntpTime := time.Unix(1700000000, 0)
+	stream.HandleSenderReport(&rtcp.SenderReport{SSRC: 0x1234, NTPTime: rtcp.ToNTP(ntpTime), RTPTime: 0xffffff00})
+
+	tests := []struct {
+		timestamp int64
+		want      time.Duration
+	}{
+		{0xffffff00, 0},
+		// past the wrap, extended or not.
+		{1<<32 + 0x100, 64 * time.Millisecond},
+		{0x100, 64 * time.Millisecond},
+		{1<<32 + 8000 - 0x100, time.Second},
+		// before the report.
+		{0xfffffe00, -32 * time.Millisecond},
+	}
+	for _, test := range tests {
+		capture, ok := stream.captureTime(0x1234, 0, test.timestamp)
+		if !ok || capture.Sub(ntpTime) != test.want {
+			t.Errorf("%#x: captured at %v, want %v", test.timestamp, capture.Sub(ntpTime), test.want)
+		}
+	}
+	if _, ok := stream.captureTime(0x5678, 0, 0); ok {
+		t.Error("mapped a source without sender report")
+	}
+}
+
+func TestCaptureTimeScale(t *testing.T) {
+	stream := testStream(t, "v=0\r\nm=audio 0 RTP/AVP 0 101\r\na=rtpmap:101 opus/48000/2\r\n")
+	ntpTime := time.Unix(1700000000, 0)
+	stream.HandleSenderReport(&rtcp.SenderReport{SSRC: 1, NTPTime: rtcp.ToNTP(ntpTime), RTPTime: 0})
+	// the timestamps of each payload type go at its own clock rate.
+	for _, test := range []struct {
+		payloadType byte
+		timestamp   int64
+	}{{0, 8000}, {101, 48000}} {
+		if capture, _ := stream.captureTime(1, test.payloadType, test.timestamp); capture.Sub(ntpTime) != time.Second {
+			t.Errorf("payload type %d captured at %v", test.payloadType, capture.Sub(ntpTime))
+		}
+	}
+}
+
+func TestSharedTimeline(t *testing.T) {
+	timeline := &timeline{}
+	audio := testStream(t, "v=0\r\nm=audio 0 RTP/AVP 0\r\n")
+	other := testStream(t, "v=0\r\nm=audio 0 RTP/AVP 8\r\n")
+	audio.timeline, other.timeline = timeline, timeline
+
+	ntpTime := time.Unix(1700000000, 0)
+	audio.HandleSenderReport(&rtcp.SenderReport{SSRC: 1, NTPTime: rtcp.ToNTP(ntpTime), RTPTime: 1000})
+	other.HandleSenderReport(&rtcp.SenderReport{SSRC: 2, NTPTime: rtcp.ToNTP(ntpTime), RTPTime: 50000})
+
+	// the first stream starts the timeline at its first packet, captured a
+	// second after the reports.
+	pkt, ok, err := audio.HandleRtpPacket(rtp.Packet{SequenceNumber: 1, Timestamp: 9000, SyncSource: 1,
+		Payload: make([]byte, 160)})
+	if err != nil || !ok || pkt.Time != 0 || !audio.CaptureTime().Equal(ntpTime.Add(time.Second)) {
+		t.Fatalf("first packet at %v, captured at %v, %v", pkt.Time, audio.CaptureTime(), err)
+	}
+	// the other, captured a second later, lines up with it whatever its
+	// timestamps.
+	pkt, ok, err = other.HandleRtpPacket(rtp.Packet{SequenceNumber: 7, Timestamp: 66000, SyncSource: 2,
+		Payload: make([]byte, 160)})
+	if err != nil || !ok || pkt.Time != time.Second {
+		t.Fatalf("other packet at %v, %v", pkt.Time, err)
+	}
+	pkt, ok, err = audio.HandleRtpPacket(rtp.Packet{SequenceNumber: 2, Timestamp: 9160, SyncSource: 1,
+		Payload: make([]byte, 160)})
+	if err != nil || !ok || pkt.Time != 20*time.Millisecond {
+		t.Errorf("second packet at %v, %v", pkt.Time, err)
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// parseTransportSource finds where the server sends a udp stream from in the
+// Transport header of a SETUP response, the port is 0 if the server didn't
+// tell.
+func parseTransportSource(header string, host net.IP) (rtpSource, rtcpSource *net.UDPAddr) {
+	rtpSource = &net.UDPAddr{IP: host}
+	rtcpSource = &net.UDPAddr{IP: host}
+	for _, param := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "source":
+			if ip := net.ParseIP(kv[1]); ip != nil {
+				rtpSource.IP, rtcpSource.IP = ip, ip
+			}
+		case "server_port":
+			ports := strings.SplitN(kv[1], "-", 2)
+			rtpSource.Port, _ = strconv.Atoi(ports[0])
+			if len(ports) == 2 {
+				rtcpSource.Port, _ = strconv.Atoi(ports[1])
+			} else if rtpSource.Port != 0 {
+				rtcpSource.Port = rtpSource.Port + 1
+			}
+		}
+	}
+	return
+}
+
+// parseTransportInterleaved finds the rtcp channel of a stream the server
+// sends over the rtsp connection, from the Transport header of a SETUP
+// response.
+func parseTransportInterleaved(header string) (rtcpChannel byte, ok bool) {
+	for _, param := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 || kv[0] != "interleaved" {
+			continue
+		}
+		channels := strings.SplitN(kv[1], "-", 2)
+		rtp, err := strconv.Atoi(channels[0])
+		if err != nil {
+			return 0, false
+		}
+		rtcp := rtp + 1
+		if len(channels) == 2 {
+			if rtcp, err = strconv.Atoi(channels[1]); err != nil {
+				return 0, false
+			}
+		}
+		return byte(rtcp), true
+	}
+	return 0, false
+}
+
+// retransmission tells how lost packets of a media can be recovered: the
+// payload types of its rtx stream, and whether the server takes NACKs, as
+// it must when it offers rtx.
+func retransmission(media sdp.SessionSectionMedia) (rtx map[byte]byte, nack bool) {
+	for pt, apt := range media.Rtx {
+		if apt >= 0 {
+			if rtx == nil {
+				rtx = make(map[byte]byte)
+			}
+			rtx[byte(pt)] = byte(apt)
+		}
+	}
+	nack = rtx != nil
+	for _, fb := range media.RtcpFbs {
+		if fb.Type == "nack" && fb.Parameter == "" && (fb.PayloadType == "*" || fb.PayloadType == strconv.Itoa(media.PayloadType)) {
+			nack = true
+		}
+	}
+	return
+}
+
+// newFECDecoder creates the decoder of the redundancy of a media, if it has
+// any.
+func newFECDecoder(media sdp.SessionSectionMedia) *rtp.FECDecoder {
+	config := rtp.FECConfig{
+		REDPayloadType:     byte(media.RedPayloadType),
+		ULPFECPayloadType:  byte(media.UlpfecPayloadType),
+		FlexFECPayloadType: byte(media.FlexfecPayloadType),
+	}
+	for _, group := range media.SsrcGroups {
+		// the protected source comes first, then the FlexFEC one.
+		if group.Semantics == "FEC-FR" && len(group.SSRCs) == 2 {
+			config.FlexFECSSRC = uint(group.SSRCs[1])
+		}
+	}
+	if config == (rtp.FECConfig{}) {
+		return nil
+	}
+	return rtp.NewFECDecoder(config)
+}
+
+// readUDP hands the packets received over udp to the session until it closes.
+func (s *Session) readUDP(udp *rtp.UDPSession, rtpChan chan rtp.Packet, done chan struct{}) {
+	go func() {
+		for compound := range udp.RtcpChan {
+			s.handleRtcp(compound)
+		}
+	}()
+	for packet := range udp.RtpChan {
+		if !s.trackSource(packet) {
+			rtp.Release(packet)
+			continue
+		}
+		s.updateStats(packet)
+		select {
+		case rtpChan <- packet:
+		case <-done:
+		}
+	}
+}
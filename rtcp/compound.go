@@ -0,0 +1,358 @@
+package rtcp
+
+import (
+	"errors"
+)
+
+// Packet types of RFC 3550.
+const (
+	TypeSenderReport       = 200
+	TypeReceiverReport     = 201
+	TypeSourceDescription  = 202
+	TypeGoodbye            = 203
+	TypeApplicationDefined = 204
+)
+
+// Errors returned by ParseCompound
+var (
+	ErrFirstNotReport   = errors.New("rtcp: compound packet not starting with a report")
+	ErrPaddingNotLast   = errors.New("rtcp: padding before the last packet")
+	ErrInvalidLength    = errors.New("rtcp: packet length invalid")
+	ErrInvalidSDESChunk = errors.New("rtcp: sdes chunk invalid")
+)
+
+// Message is a typed packet of a compound packet. Packet types without a
+// type of their own come as a Packet.
+type Message interface {
+	// Type returns the packet type.
+	Type() uint
+	// Marshal returns the packet in wire format.
+	Marshal() ([]byte, error)
+}
+
+// Compound is a compound packet received on a stream.
+type Compound struct {
+	StreamIdx uint
+	Messages  []Message
+}
+
+// Type returns the packet type.
+func (r Packet) Type() uint { return r.PacketType }
+
+// ReceptionReport is a report block, the reception statistics of a source.
+type ReceptionReport struct {
+	SSRC         uint32
+	FractionLost uint8
+	// TotalLost is the cumulative number of packets lost, a signed 24 bit
+	// value as duplicates make it negative.
+	TotalLost        int32
+	LastSequence     uint32 // extended highest sequence number received
+	Jitter           uint32
+	LastSenderReport uint32 // middle 32 bits of the NTP time of the last SR
+	Delay            uint32 // since the last SR, in 1/65536 seconds
+}
+
+func parseReceptionReports(buf []byte, count int) ([]ReceptionReport, []byte, error) {
+	if len(buf) < 24*count {
+		return nil, nil, ErrInvalidLength
+	}
+	reports := make([]ReceptionReport, count)
+	for i := range reports {
+		b := buf[24*i:]
+		lost := int32(toUint(b[5:8]))
+		if lost&0x800000 != 0 {
+			lost -= 1 << 24
+		}
+		reports[i] = ReceptionReport{
+			SSRC:             uint32(toUint(b[0:4])),
+			FractionLost:     b[4],
+			TotalLost:        lost,
+			LastSequence:     uint32(toUint(b[8:12])),
+			Jitter:           uint32(toUint(b[12:16])),
+			LastSenderReport: uint32(toUint(b[16:20])),
+			Delay:            uint32(toUint(b[20:24])),
+		}
+	}
+	return reports, buf[24*count:], nil
+}
+
+// SenderReport is a SR, the transmission and reception statistics of a
+// sender (RFC 3550 section 6.4.1).
+type SenderReport struct {
+	SSRC        uint32
+	NTPTime     uint64
+	RTPTime     uint32
+	PacketCount uint32
+	OctetCount  uint32
+	Reports     []ReceptionReport
+	// ProfileExtensions follows the report blocks.
+	ProfileExtensions []byte
+}
+
+// Type returns the packet type.
+func (r *SenderReport) Type() uint { return TypeSenderReport }
+
+// ReceiverReport is a RR, the reception statistics of a participant that
+// doesn't send (RFC 3550 section 6.4.2).
+type ReceiverReport struct {
+	SSRC              uint32
+	Reports           []ReceptionReport
+	ProfileExtensions []byte
+}
+
+// Type returns the packet type.
+func (r *ReceiverReport) Type() uint { return TypeReceiverReport }
+
+// SDES item types.
+const (
+	SDESEnd   = 0
+	SDESCNAME = 1
+	SDESName  = 2
+	SDESEmail = 3
+	SDESPhone = 4
+	SDESLoc   = 5
+	SDESTool  = 6
+	SDESNote  = 7
+	SDESPriv  = 8
+)
+
+// SourceDescriptionItem is an item of a SDES chunk, such as the CNAME.
+type SourceDescriptionItem struct {
+	Type byte
+	Text string
+}
+
+// SourceDescriptionChunk describes a source.
+type SourceDescriptionChunk struct {
+	Source uint32
+	Items  []SourceDescriptionItem
+}
+
+// SourceDescription is a SDES packet (RFC 3550 section 6.5).
+type SourceDescription struct {
+	Chunks []SourceDescriptionChunk
+}
+
+// Type returns the packet type.
+func (r *SourceDescription) Type() uint { return TypeSourceDescription }
+
+// CNAME returns the canonical name of a source, if it is described.
+func (r *SourceDescription) CNAME(ssrc uint32) (string, bool) {
+	for _, chunk := range r.Chunks {
+		if chunk.Source != ssrc {
+			continue
+		}
+		for _, item := range chunk.Items {
+			if item.Type == SDESCNAME {
+				return item.Text, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Goodbye is a BYE packet, the sources leaving (RFC 3550 section 6.6).
+type Goodbye struct {
+	Sources []uint32
+	Reason  string
+}
+
+// Type returns the packet type.
+func (r *Goodbye) Type() uint { return TypeGoodbye }
+
+// ApplicationDefined is an APP packet (RFC 3550 section 6.7).
+type ApplicationDefined struct {
+	SubType byte
+	SSRC    uint32
+	Name    string // four ASCII characters
+	Data    []byte
+}
+
+// Type returns the packet type.
+func (r *ApplicationDefined) Type() uint { return TypeApplicationDefined }
+
+// ParseCompound parses a compound packet into its typed packets, checking it
+// as RFC 3550 section 6.4 and appendix A.2 do: all packets are version 2,
+// the first one is a SR or RR, only the last one may have padding and the
+// lengths add up to the size of buf. The packets refer to buf.
+func ParseCompound(buf []byte) ([]Message, error) {
+	var messages []Message
+	for len(buf) > 0 {
+		if len(buf) < 4 {
+			return nil, ErrPacketTooShort
+		}
+		if buf[0]>>6 != 2 {
+			return nil, ErrUnsupportedVersion
+		}
+		count := int(buf[0] & 0x1f)
+		packetType := uint(buf[1])
+		end := (int(toUint(buf[2:4])) + 1) * 4
+		if len(buf) < end {
+			return nil, ErrPacketTooShort
+		}
+		body := buf[4:end]
+		if buf[0]&0x20 != 0 {
+			if end != len(buf) {
+				return nil, ErrPaddingNotLast
+			}
+			padding := int(buf[end-1])
+			if padding == 0 || padding > len(body) {
+				return nil, ErrInvalidPadding
+			}
+			body = body[:len(body)-padding]
+		}
+		if len(messages) == 0 && packetType != TypeSenderReport && packetType != TypeReceiverReport {
+			return nil, ErrFirstNotReport
+		}
+
+		message, err := parseMessage(buf[:end], packetType, count, body)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+		buf = buf[end:]
+	}
+	if len(messages) == 0 {
+		return nil, ErrPacketTooShort
+	}
+	return messages, nil
+}
+
+// parseMessage parses the body of a packet, following its header.
+func parseMessage(buf []byte, packetType uint, count int, body []byte) (Message, error) {
+	switch packetType {
+	case TypeSenderReport:
+		if len(body) < 24 {
+			return nil, ErrInvalidLength
+		}
+		sr := &SenderReport{
+			SSRC:        uint32(toUint(body[0:4])),
+			NTPTime:     uint64(toUint(body[4:8]))<<32 | uint64(toUint(body[8:12])),
+			RTPTime:     uint32(toUint(body[12:16])),
+			PacketCount: uint32(toUint(body[16:20])),
+			OctetCount:  uint32(toUint(body[20:24])),
+		}
+		var err error
+		if sr.Reports, sr.ProfileExtensions, err = parseReceptionReports(body[24:], count); err != nil {
+			return nil, err
+		}
+		return sr, nil
+
+	case TypeReceiverReport:
+		if len(body) < 4 {
+			return nil, ErrInvalidLength
+		}
+		rr := &ReceiverReport{SSRC: uint32(toUint(body[0:4]))}
+		var err error
+		if rr.Reports, rr.ProfileExtensions, err = parseReceptionReports(body[4:], count); err != nil {
+			return nil, err
+		}
+		return rr, nil
+
+	case TypeSourceDescription:
+		sdes := &SourceDescription{}
+		for i := 0; i < count; i++ {
+			if len(body) < 4 {
+				return nil, ErrInvalidSDESChunk
+			}
+			chunk := SourceDescriptionChunk{Source: uint32(toUint(body[0:4]))}
+			j := 4
+			for {
+				if j >= len(body) {
+					return nil, ErrInvalidSDESChunk
+				}
+				if body[j] == SDESEnd {
+					break
+				}
+				if j+2 > len(body) || j+2+int(body[j+1]) > len(body) {
+					return nil, ErrInvalidSDESChunk
+				}
+				chunk.Items = append(chunk.Items, SourceDescriptionItem{
+					Type: body[j],
+					Text: string(body[j+2 : j+2+int(body[j+1])]),
+				})
+				j += 2 + int(body[j+1])
+			}
+			// the null items pad the chunk to a 32 bit boundary.
+			j = (j/4 + 1) * 4
+			if j > len(body) {
+				return nil, ErrInvalidSDESChunk
+			}
+			sdes.Chunks = append(sdes.Chunks, chunk)
+			body = body[j:]
+		}
+		return sdes, nil
+
+	case TypeGoodbye:
+		if len(body) < 4*count {
+			return nil, ErrInvalidLength
+		}
+		bye := &Goodbye{}
+		for i := 0; i < count; i++ {
+			bye.Sources = append(bye.Sources, uint32(toUint(body[4*i:4*i+4])))
+		}
+		if rest := body[4*count:]; len(rest) > 0 {
+			if len(rest) < 1+int(rest[0]) {
+				return nil, ErrInvalidLength
+			}
+			bye.Reason = string(rest[1 : 1+int(rest[0])])
+		}
+		return bye, nil
+
+	case TypeApplicationDefined:
+		if len(body) < 8 {
+			return nil, ErrInvalidLength
+		}
+		return &ApplicationDefined{
+			SubType: byte(count),
+			SSRC:    uint32(toUint(body[0:4])),
+			Name:    string(body[4:8]),
+			Data:    body[8:],
+		}, nil
+
+	case TypeExtendedReport:
+		return parseExtendedReport(body)
+
+	case TypeTransportFeedback:
+		packet, err := ParsePacket(buf)
+		if err != nil {
+			return nil, err
+		}
+		if count == FormatGenericNack {
+			nack, err := ParseGenericNack(packet)
+			if err != nil {
+				return nil, err
+			}
+			return &nack, nil
+		}
+		return packet, nil
+
+	case TypePayloadFeedback:
+		packet, err := ParsePacket(buf)
+		if err != nil {
+			return nil, err
+		}
+		switch count {
+		case FormatPictureLossIndication:
+			pli, err := ParsePictureLossIndication(packet)
+			if err != nil {
+				return nil, err
+			}
+			return &pli, nil
+		case FormatFullIntraRequest:
+			fir, err := ParseFullIntraRequest(packet)
+			if err != nil {
+				return nil, err
+			}
+			return &fir, nil
+		case FormatApplicationLayer:
+			// other application layer messages stay packets.
+			if remb, err := ParseReceiverEstimatedMaxBitrate(packet); err == nil {
+				return &remb, nil
+			}
+		}
+		return packet, nil
+	}
+
+	return ParsePacket(buf)
+}
@@ -0,0 +1,130 @@
+package rtcp
+
+import (
+	"reflect"
+	"testing"
+)
+
+var (
+	// a SR with one report block.
+	testSR = []byte{
+		0x81, 200, 0, 12,
+		0xde, 0xad, 0xbe, 0xef,
+		0xe6, 0xd5, 0x2c, 0x80, 0x40, 0, 0, 0, // NTP time
+		0, 1, 0x5f, 0x90, // RTP time
+		0, 0, 0, 100, // packets
+		0, 0, 0x27, 0x10, // octets
+		0xca, 0xfe, 0xba, 0xbe,
+		0x40, 0xff, 0xff, 0xfe, // 25% lost, total -2
+		0, 1, 0, 5,
+		0, 0, 0, 42,
+		0x2c, 0x80, 0x40, 0,
+		0, 1, 0, 0,
+	}
+	// SDES with a CNAME and a NOTE, then padding to the 32 bit boundary.
+	testSDES = []byte{
+		0x81, 202, 0, 4,
+		0xde, 0xad, 0xbe, 0xef,
+		1, 4, 'c', 'a', 'm', '1',
+		7, 2, 'h', 'i',
+		0, 0,
+	}
+	// BYE with a reason, padded.
+	testBYE = []byte{
+		0xa1, 203, 0, 3,
+		0xde, 0xad, 0xbe, 0xef,
+		3, 'e', 'n', 'd',
+		0, 0, 0, 4,
+	}
+	testAPP = []byte{
+		0x83, 204, 0, 3,
+		0xde, 0xad, 0xbe, 0xef,
+		'O', 'N', 'V', 'F',
+		1, 2, 3, 4,
+	}
+)
+
+func concat(bufs ...[]byte) (buf []byte) {
+	for _, b := range bufs {
+		buf = append(buf, b...)
+	}
+	return
+}
+
+func TestParseCompound(t *testing.T) {
+	messages, err := ParseCompound(concat(testSR, testSDES, testAPP, testBYE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Message{
+		&SenderReport{
+			SSRC:        0xdeadbeef,
+			NTPTime:     0xe6d52c8040000000,
+			RTPTime:     90000,
+			PacketCount: 100,
+			OctetCount:  10000,
+			Reports: []ReceptionReport{{
+				SSRC:             0xcafebabe,
+				FractionLost:     0x40,
+				TotalLost:        -2,
+				LastSequence:     0x10005,
+				Jitter:           42,
+				LastSenderReport: 0x2c804000,
+				Delay:            0x10000,
+			}},
+			ProfileExtensions: []byte{},
+		},
+		&SourceDescription{Chunks: []SourceDescriptionChunk{{
+			Source: 0xdeadbeef,
+			Items:  []SourceDescriptionItem{{Type: SDESCNAME, Text: "cam1"}, {Type: SDESNote, Text: "hi"}},
+		}}},
+		&ApplicationDefined{SubType: 3, SSRC: 0xdeadbeef, Name: "ONVF", Data: []byte{1, 2, 3, 4}},
+		&Goodbye{Sources: []uint32{0xdeadbeef}, Reason: "end"},
+	}
+	if !reflect.DeepEqual(messages, want) {
+		for i := range messages {
+			t.Errorf("%d: %+v", i, messages[i])
+		}
+	}
+	if cname, ok := messages[1].(*SourceDescription).CNAME(0xdeadbeef); !ok || cname != "cam1" {
+		t.Errorf("cname %q", cname)
+	}
+}
+
+func TestParseCompoundFeedback(t *testing.T) {
+	rr := []byte{0x80, 201, 0, 1, 0, 0, 0, 1}
+	nack := GenericNack{SenderSSRC: 1, MediaSSRC: 2, Nacks: []NackPair{{PacketID: 3}}}
+	pli := []byte{0x81, 206, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2}
+	messages, err := ParseCompound(concat(rr, mustMarshal(t, &nack), pli))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || !reflect.DeepEqual(messages[1], &nack) || messages[2].Type() != TypePayloadFeedback {
+		t.Errorf("messages %+v", messages)
+	}
+	if r := messages[0].(*ReceiverReport); r.SSRC != 1 || len(r.Reports) != 0 {
+		t.Errorf("rr %+v", r)
+	}
+}
+
+func TestParseCompoundInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		err  error
+	}{
+		{"empty", nil, ErrPacketTooShort},
+		{"not starting with a report", concat(testSDES, testSR), ErrFirstNotReport},
+		{"padding not last", concat(testSR, testBYE, testSDES), ErrPaddingNotLast},
+		{"trailing bytes", concat(testSR, []byte{0x80, 202}), ErrPacketTooShort},
+		{"length beyond buffer", testSR[:40], ErrPacketTooShort},
+		{"version", concat(testSR, []byte{0x40, 202, 0, 0}), ErrUnsupportedVersion},
+		{"report blocks beyond length", concat([]byte{0x82, 201, 0, 1, 0, 0, 0, 1}), ErrInvalidLength},
+		{"sdes item beyond chunk", concat(testSR, []byte{0x81, 202, 0, 2, 0, 0, 0, 1, 1, 9, 'a', 'b'}), ErrInvalidSDESChunk},
+	}
+	for _, tst := range tests {
+		if _, err := ParseCompound(tst.buf); err != tst.err {
+			t.Errorf("%s: error %v, expected %v", tst.name, err, tst.err)
+		}
+	}
+}
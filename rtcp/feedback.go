@@ -0,0 +1,283 @@
+package rtcp
+
+import (
+	"errors"
+	"sort"
+)
+
+// Packet types and formats of the feedback messages of RFC 4585.
+const (
+	TypeTransportFeedback = 205
+	TypePayloadFeedback   = 206
+
+	FormatGenericNack = 1
+
+	FormatPictureLossIndication = 1
+	FormatFullIntraRequest      = 4
+	FormatApplicationLayer      = 15
+)
+
+// ErrNotFeedback is returned when a packet isn't the expected feedback message.
+var ErrNotFeedback = errors.New("rtcp: not the expected feedback message")
+
+// NackPair is a Generic NACK entry, it reports PacketID lost along with the
+// following 16 packets whose bits are set in LostPackets (BLP).
+type NackPair struct {
+	PacketID    uint16
+	LostPackets uint16
+}
+
+// PacketList returns the sequence numbers reported lost by the entry.
+func (n NackPair) PacketList() []uint16 {
+	list := []uint16{n.PacketID}
+	for i := uint16(0); i < 16; i++ {
+		if n.LostPackets&(1<<i) != 0 {
+			list = append(list, n.PacketID+i+1)
+		}
+	}
+	return list
+}
+
+// NackPairsFromSequences packs lost sequence numbers, which may wrap around,
+// into as few Generic NACK entries as possible.
+func NackPairsFromSequences(seqs []uint16) []NackPair {
+	if len(seqs) == 0 {
+		return nil
+	}
+	sorted := append([]uint16(nil), seqs...)
+	ref := sorted[0]
+	sort.Slice(sorted, func(i, j int) bool { return int16(sorted[i]-ref) < int16(sorted[j]-ref) })
+	var pairs []NackPair
+	for _, seq := range sorted {
+		if n := len(pairs); n > 0 {
+			last := &pairs[n-1]
+			diff := seq - last.PacketID
+			if diff == 0 {
+				continue
+			}
+			if diff <= 16 {
+				last.LostPackets |= 1 << (diff - 1)
+				continue
+			}
+		}
+		pairs = append(pairs, NackPair{PacketID: seq})
+	}
+	return pairs
+}
+
+// GenericNack is a transport layer feedback message asking the sender of
+// MediaSSRC to retransmit packets (RFC 4585 section 6.2.1).
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |V=2|P| FMT=1   |   PT=RTPFB    |          length               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                  SSRC of packet sender                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                  SSRC of media source                         |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |            PID                |             BLP               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type GenericNack struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+	Nacks      []NackPair
+}
+
+// ParseGenericNack reads a Generic NACK out of a parsed packet.
+func ParseGenericNack(packet Packet) (GenericNack, error) {
+	if packet.PacketType != TypeTransportFeedback || packet.ReceiptionReport != FormatGenericNack ||
+		len(packet.Payload) < 4 || len(packet.Payload)%4 != 0 {
+		return GenericNack{}, ErrNotFeedback
+	}
+	nack := GenericNack{
+		SenderSSRC: uint32(packet.SyncSource),
+		MediaSSRC:  uint32(toUint(packet.Payload[0:4])),
+	}
+	for fci := packet.Payload[4:]; len(fci) >= 4; fci = fci[4:] {
+		nack.Nacks = append(nack.Nacks, NackPair{
+			PacketID:    uint16(toUint(fci[0:2])),
+			LostPackets: uint16(toUint(fci[2:4])),
+		})
+	}
+	return nack, nil
+}
+
+// Marshal returns the packet in wire format.
+func (n GenericNack) Marshal() ([]byte, error) {
+	buf := make([]byte, 12+4*len(n.Nacks))
+	feedbackHeader(buf, FormatGenericNack, TypeTransportFeedback, n.SenderSSRC, n.MediaSSRC)
+	for i, pair := range n.Nacks {
+		putUint(buf[12+4*i:14+4*i], uint(pair.PacketID))
+		putUint(buf[14+4*i:16+4*i], uint(pair.LostPackets))
+	}
+	return buf, nil
+}
+
+// feedbackHeader writes the header of a feedback message of size bytes.
+func feedbackHeader(buf []byte, format int, packetType uint, sender, media uint32) {
+	header(buf, format, packetType)
+	putUint(buf[4:8], uint(sender))
+	putUint(buf[8:12], uint(media))
+}
+
+// PictureLossIndication is a payload specific feedback message telling the
+// sender of MediaSSRC pictures were lost, so it sends a keyframe (RFC 4585
+// section 6.3.1). It has no FCI.
+type PictureLossIndication struct {
+	SenderSSRC uint32
+	MediaSSRC  uint32
+}
+
+// ParsePictureLossIndication reads a PLI out of a parsed packet.
+func ParsePictureLossIndication(packet Packet) (PictureLossIndication, error) {
+	if packet.PacketType != TypePayloadFeedback || packet.ReceiptionReport != FormatPictureLossIndication ||
+		len(packet.Payload) < 4 {
+		return PictureLossIndication{}, ErrNotFeedback
+	}
+	return PictureLossIndication{
+		SenderSSRC: uint32(packet.SyncSource),
+		MediaSSRC:  uint32(toUint(packet.Payload[0:4])),
+	}, nil
+}
+
+// Marshal returns the packet in wire format.
+func (p PictureLossIndication) Marshal() ([]byte, error) {
+	buf := make([]byte, 12)
+	feedbackHeader(buf, FormatPictureLossIndication, TypePayloadFeedback, p.SenderSSRC, p.MediaSSRC)
+	return buf, nil
+}
+
+// Type returns the packet type.
+func (p *PictureLossIndication) Type() uint { return TypePayloadFeedback }
+
+// FIREntry asks SSRC for a keyframe, SequenceNumber tells the requests
+// apart and goes up by one with each new one.
+type FIREntry struct {
+	SSRC           uint32
+	SequenceNumber uint8
+}
+
+// FullIntraRequest is a payload specific feedback message asking the
+// sources of its entries for a keyframe (RFC 5104 section 4.3.1). The media
+// SSRC of the header is unused, the sources are in the FCI.
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                              SSRC                             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// | Seq nr.       |    Reserved                                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type FullIntraRequest struct {
+	SenderSSRC uint32
+	Entries    []FIREntry
+}
+
+// ParseFullIntraRequest reads a FIR out of a parsed packet.
+func ParseFullIntraRequest(packet Packet) (FullIntraRequest, error) {
+	if packet.PacketType != TypePayloadFeedback || packet.ReceiptionReport != FormatFullIntraRequest ||
+		len(packet.Payload) < 4 || len(packet.Payload)%8 != 4 {
+		return FullIntraRequest{}, ErrNotFeedback
+	}
+	fir := FullIntraRequest{SenderSSRC: uint32(packet.SyncSource)}
+	for fci := packet.Payload[4:]; len(fci) >= 8; fci = fci[8:] {
+		fir.Entries = append(fir.Entries, FIREntry{
+			SSRC:           uint32(toUint(fci[0:4])),
+			SequenceNumber: fci[4],
+		})
+	}
+	return fir, nil
+}
+
+// Marshal returns the packet in wire format.
+func (f FullIntraRequest) Marshal() ([]byte, error) {
+	buf := make([]byte, 12+8*len(f.Entries))
+	feedbackHeader(buf, FormatFullIntraRequest, TypePayloadFeedback, f.SenderSSRC, 0)
+	for i, entry := range f.Entries {
+		putUint(buf[12+8*i:16+8*i], uint(entry.SSRC))
+		buf[16+8*i] = entry.SequenceNumber
+	}
+	return buf, nil
+}
+
+// Type returns the packet type.
+func (f *FullIntraRequest) Type() uint { return TypePayloadFeedback }
+
+// rembIdentifier starts the FCI of a REMB message.
+const rembIdentifier = "REMB"
+
+// ReceiverEstimatedMaxBitrate is an application layer feedback message
+// telling the bitrate the receiver estimates the network takes, in bits per
+// second, for the sources listed (draft-alvestrand-rmcat-remb).
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  Unique identifier 'R' 'E' 'M' 'B'                            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  Num SSRC     | BR Exp    |  BR Mantissa                      |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |   SSRC feedback                                               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type ReceiverEstimatedMaxBitrate struct {
+	SenderSSRC uint32
+	Bitrate    uint64
+	SSRCs      []uint32
+}
+
+// ParseReceiverEstimatedMaxBitrate reads a REMB out of a parsed packet.
+func ParseReceiverEstimatedMaxBitrate(packet Packet) (ReceiverEstimatedMaxBitrate, error) {
+	if packet.PacketType != TypePayloadFeedback || packet.ReceiptionReport != FormatApplicationLayer ||
+		len(packet.Payload) < 12 || string(packet.Payload[4:8]) != rembIdentifier {
+		return ReceiverEstimatedMaxBitrate{}, ErrNotFeedback
+	}
+	fci := packet.Payload[8:]
+	count := int(fci[0])
+	if len(fci) < 4+4*count {
+		return ReceiverEstimatedMaxBitrate{}, ErrNotFeedback
+	}
+	exp := fci[1] >> 2
+	mantissa := uint64(toUint(fci[1:4]) & 0x3ffff)
+	remb := ReceiverEstimatedMaxBitrate{
+		SenderSSRC: uint32(packet.SyncSource),
+		Bitrate:    mantissa << exp,
+	}
+	for i := 0; i < count; i++ {
+		remb.SSRCs = append(remb.SSRCs, uint32(toUint(fci[4+4*i:8+4*i])))
+	}
+	return remb, nil
+}
+
+// Marshal returns the packet in wire format, the bitrate is rounded down to
+// the 18 bits of the mantissa.
+func (r ReceiverEstimatedMaxBitrate) Marshal() ([]byte, error) {
+	if len(r.SSRCs) > 255 {
+		return nil, ErrTooManyReports
+	}
+	buf := make([]byte, 20+4*len(r.SSRCs))
+	feedbackHeader(buf, FormatApplicationLayer, TypePayloadFeedback, r.SenderSSRC, 0)
+	copy(buf[12:16], rembIdentifier)
+	exp, mantissa := uint(0), r.Bitrate
+	for mantissa >= 1<<18 {
+		mantissa >>= 1
+		exp++
+	}
+	buf[16] = byte(len(r.SSRCs))
+	putUint(buf[17:20], exp<<18|uint(mantissa))
+	for i, ssrc := range r.SSRCs {
+		putUint(buf[20+4*i:24+4*i], uint(ssrc))
+	}
+	return buf, nil
+}
+
+// Type returns the packet type.
+func (r *ReceiverEstimatedMaxBitrate) Type() uint { return TypePayloadFeedback }
+
+// putUint is the reverse of toUint, it writes v big endian over the whole of arr.
+func putUint(arr []byte, v uint) {
+	for i := range arr {
+		arr[i] = byte(v >> (8 * uint(len(arr)-i-1)))
+	}
+}
+
+// Type returns the packet type.
+func (n *GenericNack) Type() uint { return TypeTransportFeedback }
@@ -0,0 +1,80 @@
+package rtcp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNackPairsFromSequences(t *testing.T) {
+	pairs := NackPairsFromSequences([]uint16{65535, 3, 0, 1, 17, 3, 40})
+	want := []NackPair{{PacketID: 65535, LostPackets: 0x0001 | 0x0002 | 0x0008}, {PacketID: 17}, {PacketID: 40}}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Fatalf("pairs %+v", pairs)
+	}
+	if list := pairs[0].PacketList(); !reflect.DeepEqual(list, []uint16{65535, 0, 1, 3}) {
+		t.Errorf("packet list %v", list)
+	}
+	if pairs := NackPairsFromSequences(nil); pairs != nil {
+		t.Errorf("pairs of nothing %+v", pairs)
+	}
+}
+
+func TestGenericNack(t *testing.T) {
+	nack := GenericNack{SenderSSRC: 0x01020304, MediaSSRC: 0xcafe, Nacks: []NackPair{{PacketID: 100, LostPackets: 0x8001}, {PacketID: 200}}}
+	buf := mustMarshal(t, &nack)
+	if len(buf) != 20 || buf[0] != 0x81 || buf[1] != 205 || buf[3] != 4 {
+		t.Fatalf("marshalled % x", buf)
+	}
+	packet, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseGenericNack(packet)
+	if err != nil || !reflect.DeepEqual(got, nack) {
+		t.Errorf("parsed %+v: %v", got, err)
+	}
+
+	packet.ReceiptionReport = 15
+	if _, err := ParseGenericNack(packet); err != ErrNotFeedback {
+		t.Errorf("other feedback: %v", err)
+	}
+}
+
+func TestKeyframeRequests(t *testing.T) {
+	pli := PictureLossIndication{SenderSSRC: 1, MediaSSRC: 0xcafe}
+	fir := FullIntraRequest{SenderSSRC: 1, Entries: []FIREntry{{SSRC: 0xcafe, SequenceNumber: 7}, {SSRC: 0xbeef, SequenceNumber: 255}}}
+	buf := concat([]byte{0x80, 201, 0, 1, 0, 0, 0, 1}, mustMarshal(t, &pli), mustMarshal(t, &fir))
+	if b := mustMarshal(t, &fir); len(b) != 28 || b[0] != 0x84 || b[1] != 206 || b[3] != 6 {
+		t.Fatalf("fir % x", b)
+	}
+	messages, err := ParseCompound(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || !reflect.DeepEqual(messages[1], &pli) || !reflect.DeepEqual(messages[2], &fir) {
+		t.Errorf("messages %+v", messages)
+	}
+}
+
+func TestReceiverEstimatedMaxBitrate(t *testing.T) {
+	remb := ReceiverEstimatedMaxBitrate{SenderSSRC: 1, Bitrate: 1500000, SSRCs: []uint32{0xcafe, 0xbeef}}
+	buf := mustMarshal(t, &remb)
+	if len(buf) != 28 || buf[0] != 0x8f || string(buf[12:16]) != "REMB" || buf[16] != 2 {
+		t.Fatalf("marshalled % x", buf)
+	}
+	packet, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseReceiverEstimatedMaxBitrate(packet)
+	// 1500000 takes 21 bits, the 3 lowest are dropped.
+	remb.Bitrate = 1500000 &^ 7
+	if err != nil || !reflect.DeepEqual(got, remb) {
+		t.Errorf("parsed %+v: %v", got, err)
+	}
+
+	copy(packet.Payload[4:8], "GOOG")
+	if _, err := ParseReceiverEstimatedMaxBitrate(packet); err != ErrNotFeedback {
+		t.Errorf("other application layer feedback: %v", err)
+	}
+}
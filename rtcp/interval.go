@@ -0,0 +1,46 @@
+package rtcp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Constants of the report interval computation of RFC 3550 appendix A.7.
+const (
+	minReportInterval = 5 * time.Second
+	// compensation makes up for the timer reconsideration converging to a
+	// value below the average.
+	compensation = 2.71828 - 1.5
+)
+
+// ReportInterval computes the randomized time to wait before sending the
+// next report (RFC 3550 section 6.3.1). rtcpBandwidth is the share of the
+// session bandwidth for rtcp in bytes per second, 0 if unknown, and
+// avgPacketSize the average size of the compound packets sent and received.
+func ReportInterval(members, senders int, rtcpBandwidth, avgPacketSize float64, weSent, initial bool) time.Duration {
+	minimum := minReportInterval.Seconds()
+	if initial {
+		minimum /= 2
+	}
+
+	// senders get a quarter of the bandwidth when they are few.
+	n := float64(members)
+	if senders > 0 && float64(senders) <= n*0.25 {
+		if weSent {
+			rtcpBandwidth *= 0.25
+			n = float64(senders)
+		} else {
+			rtcpBandwidth *= 0.75
+			n -= float64(senders)
+		}
+	}
+
+	t := minimum
+	if rtcpBandwidth > 0 {
+		if d := avgPacketSize * n / rtcpBandwidth; d > t {
+			t = d
+		}
+	}
+	t = t * (rand.Float64() + 0.5) / compensation
+	return time.Duration(t * float64(time.Second))
+}
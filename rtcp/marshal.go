@@ -0,0 +1,228 @@
+package rtcp
+
+import (
+	"errors"
+)
+
+// Errors returned when marshalling
+var (
+	ErrTooManyReports   = errors.New("rtcp: more than 31 report blocks or sources")
+	ErrItemTooLong      = errors.New("rtcp: sdes item or reason longer than 255 bytes")
+	ErrInvalidName      = errors.New("rtcp: app name not 4 characters")
+	ErrPacketTooLong    = errors.New("rtcp: packet longer than its length word allows")
+	ErrInvalidBlockSize = errors.New("rtcp: padding block size invalid")
+)
+
+// header writes the common header of buf, a packet whose size is a
+// multiple of 4.
+func header(buf []byte, count int, packetType uint) {
+	buf[0] = 2<<6 | byte(count)
+	buf[1] = byte(packetType)
+	putUint(buf[2:4], uint(len(buf)/4-1))
+}
+
+func marshalReceptionReports(buf []byte, reports []ReceptionReport) {
+	for i, r := range reports {
+		b := buf[24*i:]
+		putUint(b[0:4], uint(r.SSRC))
+		b[4] = r.FractionLost
+		lost := r.TotalLost
+		if lost > 0x7fffff {
+			lost = 0x7fffff
+		} else if lost < -0x800000 {
+			lost = -0x800000
+		}
+		putUint(b[5:8], uint(uint32(lost)&0xffffff))
+		putUint(b[8:12], uint(r.LastSequence))
+		putUint(b[12:16], uint(r.Jitter))
+		putUint(b[16:20], uint(r.LastSenderReport))
+		putUint(b[20:24], uint(r.Delay))
+	}
+}
+
+// Marshal returns the packet in wire format. The payload follows the sender
+// SSRC, it is padded to the 32 bit boundary when Padding is set and has to
+// end there otherwise. The length is computed, Length is ignored.
+func (r Packet) Marshal() ([]byte, error) {
+	if r.ReceiptionReport > 31 {
+		return nil, ErrTooManyReports
+	}
+	size := 8 + len(r.Payload)
+	if r.Padding {
+		size += 4 - size%4
+	} else if size%4 != 0 {
+		return nil, ErrInvalidLength
+	}
+	buf := make([]byte, size)
+	header(buf, int(r.ReceiptionReport), r.PacketType)
+	putUint(buf[4:8], r.SyncSource)
+	copy(buf[8:], r.Payload)
+	if r.Padding {
+		buf[0] |= 0x20
+		buf[size-1] = byte(size - 8 - len(r.Payload))
+	}
+	return buf, nil
+}
+
+// Marshal returns the packet in wire format.
+func (r *SenderReport) Marshal() ([]byte, error) {
+	if len(r.Reports) > 31 {
+		return nil, ErrTooManyReports
+	}
+	if len(r.ProfileExtensions)%4 != 0 {
+		return nil, ErrInvalidLength
+	}
+	buf := make([]byte, 28+24*len(r.Reports)+len(r.ProfileExtensions))
+	header(buf, len(r.Reports), TypeSenderReport)
+	putUint(buf[4:8], uint(r.SSRC))
+	putUint(buf[8:12], uint(r.NTPTime>>32))
+	putUint(buf[12:16], uint(r.NTPTime&0xffffffff))
+	putUint(buf[16:20], uint(r.RTPTime))
+	putUint(buf[20:24], uint(r.PacketCount))
+	putUint(buf[24:28], uint(r.OctetCount))
+	marshalReceptionReports(buf[28:], r.Reports)
+	copy(buf[28+24*len(r.Reports):], r.ProfileExtensions)
+	return buf, nil
+}
+
+// Marshal returns the packet in wire format.
+func (r *ReceiverReport) Marshal() ([]byte, error) {
+	if len(r.Reports) > 31 {
+		return nil, ErrTooManyReports
+	}
+	if len(r.ProfileExtensions)%4 != 0 {
+		return nil, ErrInvalidLength
+	}
+	buf := make([]byte, 8+24*len(r.Reports)+len(r.ProfileExtensions))
+	header(buf, len(r.Reports), TypeReceiverReport)
+	putUint(buf[4:8], uint(r.SSRC))
+	marshalReceptionReports(buf[8:], r.Reports)
+	copy(buf[8+24*len(r.Reports):], r.ProfileExtensions)
+	return buf, nil
+}
+
+// Marshal returns the packet in wire format.
+func (r *SourceDescription) Marshal() ([]byte, error) {
+	if len(r.Chunks) > 31 {
+		return nil, ErrTooManyReports
+	}
+	buf := make([]byte, 4)
+	for _, chunk := range r.Chunks {
+		start := len(buf)
+		buf = append(buf, 0, 0, 0, 0)
+		putUint(buf[start:start+4], uint(chunk.Source))
+		for _, item := range chunk.Items {
+			if len(item.Text) > 255 {
+				return nil, ErrItemTooLong
+			}
+			buf = append(buf, item.Type, byte(len(item.Text)))
+			buf = append(buf, item.Text...)
+		}
+		// at least one null octet ends the items, up to the 32 bit boundary.
+		buf = append(buf, 0)
+		for len(buf)%4 != 0 {
+			buf = append(buf, 0)
+		}
+	}
+	header(buf, len(r.Chunks), TypeSourceDescription)
+	return buf, nil
+}
+
+// Marshal returns the packet in wire format.
+func (r *Goodbye) Marshal() ([]byte, error) {
+	if len(r.Sources) > 31 {
+		return nil, ErrTooManyReports
+	}
+	if len(r.Reason) > 255 {
+		return nil, ErrItemTooLong
+	}
+	buf := make([]byte, 4+4*len(r.Sources))
+	for i, ssrc := range r.Sources {
+		putUint(buf[4+4*i:8+4*i], uint(ssrc))
+	}
+	if r.Reason != "" {
+		buf = append(buf, byte(len(r.Reason)))
+		buf = append(buf, r.Reason...)
+		for len(buf)%4 != 0 {
+			buf = append(buf, 0)
+		}
+	}
+	header(buf, len(r.Sources), TypeGoodbye)
+	return buf, nil
+}
+
+// Marshal returns the packet in wire format.
+func (r *ApplicationDefined) Marshal() ([]byte, error) {
+	if r.SubType > 31 {
+		return nil, ErrTooManyReports
+	}
+	if len(r.Name) != 4 {
+		return nil, ErrInvalidName
+	}
+	if len(r.Data)%4 != 0 {
+		return nil, ErrInvalidLength
+	}
+	buf := make([]byte, 12+len(r.Data))
+	header(buf, int(r.SubType), TypeApplicationDefined)
+	putUint(buf[4:8], uint(r.SSRC))
+	copy(buf[8:12], r.Name)
+	copy(buf[12:], r.Data)
+	return buf, nil
+}
+
+// MarshalCompound assembles messages into a compound packet, checking it
+// starts with a SR or RR as RFC 3550 section 6.1 requires.
+func MarshalCompound(messages ...Message) ([]byte, error) {
+	if len(messages) == 0 {
+		return nil, ErrPacketTooShort
+	}
+	if t := messages[0].Type(); t != TypeSenderReport && t != TypeReceiverReport {
+		return nil, ErrFirstNotReport
+	}
+	var buf []byte
+	for _, message := range messages {
+		b, err := message.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 4*(0xffff+1) {
+			return nil, ErrPacketTooLong
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// Pad pads the last packet of a compound packet so its size is a multiple
+// of blockSize, as some encryption algorithms require (RFC 3550 section
+// 6.4.1). The padding bit of the packet is set, and its length covers it.
+func Pad(buf []byte, blockSize int) ([]byte, error) {
+	if blockSize <= 0 || blockSize%4 != 0 || blockSize > 255 {
+		return nil, ErrInvalidBlockSize
+	}
+	// find the last packet.
+	last := 0
+	for i := 0; i < len(buf); {
+		if len(buf)-i < 4 {
+			return nil, ErrPacketTooShort
+		}
+		last = i
+		i += (int(toUint(buf[i+2:i+4])) + 1) * 4
+		if i > len(buf) {
+			return nil, ErrPacketTooShort
+		}
+	}
+	if len(buf) == 0 || buf[last]&0x20 != 0 {
+		return nil, ErrInvalidPadding
+	}
+	padding := blockSize - len(buf)%blockSize
+	if padding == blockSize {
+		return buf, nil
+	}
+	out := make([]byte, len(buf)+padding)
+	copy(out, buf)
+	out[len(out)-1] = byte(padding)
+	out[last] |= 0x20
+	putUint(out[last+2:last+4], uint((len(out)-last)/4-1))
+	return out, nil
+}
@@ -0,0 +1,177 @@
+package rtcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustMarshal marshals a message, failing the test on error.
+func mustMarshal(t *testing.T, message Message) []byte {
+	t.Helper()
+	buf, err := message.Marshal()
+	if err != nil {
+		t.Fatalf("%T: %v", message, err)
+	}
+	if len(buf)%4 != 0 || int(toUint(buf[2:4])) != len(buf)/4-1 {
+		t.Fatalf("%T: length word %d for %d bytes", message, toUint(buf[2:4]), len(buf))
+	}
+	return buf
+}
+
+// compound packets in the shape common senders use.
+var testCompounds = map[string]string{
+	// live555: SR without report blocks, CNAME and TOOL.
+	"live555": "80c800065e3a1f7ce8f1c2a51b3c4d003a2b1c0d000005f3001c4ca881ca000e" +
+		"5e3a1f7c010963616d6572612d303106234c4956453535352053747265616d69" +
+		"6e67204d656469612076323032302e30382e313900000000",
+	// GStreamer rtpbin: RR with a report block, CNAME and TOOL.
+	"gstreamer": "81c900079d2c4e115e3a1f7c0c0000250001f4a2000000d3c2a51b3c00012345" +
+		"81ca00099d2c4e110112757365723132333440686f73742d3536373806094753" +
+		"747265616d657200",
+	// browsers: an empty RR, REMB, NACK and PLI.
+	"browser": "80c90001000000018fce0005000000010000000052454d42010fd0902b7a61e0" +
+		"81cd0003000000012b7a61e01267000581ce0002000000012b7a61e0",
+	// a server leaving: SR with two report blocks, CNAME and BYE with a
+	// reason.
+	"teardown": "82c8001211223344e8f1c2b00000000000015f9000000bb800249f00aabbccdd" +
+		"000000000001006400000008c2a5f00000008000aabbccdeffffffff00000064" +
+		"00000001000000000000000081ca000511223344010c7372764031302e302e30" +
+		"2e32000081cb0004112233440873687574646f776e000000",
+	// an empty RR, XR with RRTR and DLRR, and an APP.
+	"xr": "80c900017777777780cf00087777777704000002e8f1c2b08000000005000003" +
+		"12345678c2b080000000400082cc0003777777774f4e564600000001",
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for name, s := range testCompounds {
+		buf, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages, err := ParseCompound(buf)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for _, message := range messages {
+			if _, ok := message.(Packet); ok {
+				t.Errorf("%s: %d not typed", name, message.Type())
+			}
+		}
+		out, err := MarshalCompound(messages...)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(out, buf) {
+			t.Errorf("%s: marshalled\n% x\nexpected\n% x", name, out, buf)
+		}
+	}
+}
+
+func TestMarshalCompound(t *testing.T) {
+	rr := &ReceiverReport{SSRC: 0x1234, Reports: []ReceptionReport{{
+		SSRC: 0xcafe, FractionLost: 23, TotalLost: -2, LastSequence: 0x10005,
+		Jitter: 40, LastSenderReport: 0x03040506, Delay: 98304,
+	}}}
+	sr := &SenderReport{SSRC: 0x1234, NTPTime: 0x0102030405060708, RTPTime: 9, PacketCount: 10, OctetCount: 11,
+		Reports: rr.Reports, ProfileExtensions: []byte{1, 2, 3, 4}}
+	sdes := &SourceDescription{Chunks: []SourceDescriptionChunk{
+		{Source: 0x1234, Items: []SourceDescriptionItem{{Type: SDESCNAME, Text: "user@host"}}},
+		{Source: 0x5678, Items: []SourceDescriptionItem{{Type: SDESCNAME, Text: "abc"}, {Type: SDESTool, Text: "x"}}},
+	}}
+	app := &ApplicationDefined{SubType: 3, SSRC: 0x1234, Name: "TEST", Data: []byte{5, 6, 7, 8}}
+	bye := &Goodbye{Sources: []uint32{0x1234}, Reason: "teardown"}
+
+	for _, first := range []Message{rr, sr} {
+		buf, err := MarshalCompound(first, sdes, app, bye)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages, err := ParseCompound(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the profile extensions parse as an empty slice.
+		if rr.ProfileExtensions == nil {
+			rr.ProfileExtensions = []byte{}
+		}
+		if !reflect.DeepEqual(messages, []Message{first, sdes, app, bye}) {
+			t.Errorf("parsed %+v", messages)
+		}
+	}
+
+	if _, err := MarshalCompound(sdes, rr); err != ErrFirstNotReport {
+		t.Errorf("not starting with a report: %v", err)
+	}
+	if _, err := MarshalCompound(rr, &ApplicationDefined{Name: "LONGER"}); err != ErrInvalidName {
+		t.Errorf("app name: %v", err)
+	}
+	if _, err := MarshalCompound(rr, &Goodbye{Reason: strings.Repeat("x", 256)}); err != ErrItemTooLong {
+		t.Errorf("reason: %v", err)
+	}
+}
+
+func TestMarshalPacket(t *testing.T) {
+	packet := Packet{Version: 2, Padding: true, ReceiptionReport: 1, PacketType: 210, SyncSource: 0xcafe, Payload: []byte{1, 2, 3, 4, 5}}
+	buf, err := packet.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{0xa1, 210, 0, 3, 0, 0, 0xca, 0xfe, 1, 2, 3, 4, 5, 0, 0, 3}) {
+		t.Fatalf("marshalled % x", buf)
+	}
+	parsed, err := ParsePacket(buf)
+	if err != nil || !bytes.Equal(parsed.Payload, packet.Payload) || parsed.SyncSource != 0xcafe {
+		t.Errorf("parsed %+v: %v", parsed, err)
+	}
+
+	packet.Padding = false
+	if _, err := packet.Marshal(); err != ErrInvalidLength {
+		t.Errorf("unaligned payload: %v", err)
+	}
+}
+
+func TestPad(t *testing.T) {
+	rr := mustMarshal(t, &ReceiverReport{SSRC: 1})
+	bye := mustMarshal(t, &Goodbye{Sources: []uint32{1}})
+	// already a multiple of the block size.
+	buf, err := Pad(concat(rr, bye), 16)
+	if err != nil || !bytes.Equal(buf, concat(rr, bye)) {
+		t.Fatalf("padded % x: %v", buf, err)
+	}
+	buf, err = Pad(concat(rr, bye), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 32 || buf[31] != 16 || toUint(buf[10:12]) != 5 {
+		t.Fatalf("padded % x", buf)
+	}
+	messages, err := ParseCompound(buf)
+	if err != nil || len(messages) != 2 || !reflect.DeepEqual(messages[1], &Goodbye{Sources: []uint32{1}}) {
+		t.Errorf("parsed %+v: %v", messages, err)
+	}
+	if _, err := Pad(buf, 64); err != ErrInvalidPadding {
+		t.Errorf("padded twice: %v", err)
+	}
+}
+
+func TestReportInterval(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		// the minimum, halved at first, randomized.
+		if d := ReportInterval(2, 1, 0, 100, false, false); d < 2*time.Second || d > 6200*time.Millisecond {
+			t.Fatalf("interval %v", d)
+		}
+		if d := ReportInterval(2, 1, 0, 100, false, true); d < time.Second || d > 3100*time.Millisecond {
+			t.Fatalf("initial interval %v", d)
+		}
+		// 100 members sharing 100 bytes per second take 100 seconds.
+		if d := ReportInterval(100, 0, 100, 100, false, false); d < 40*time.Second || d > 125*time.Second {
+			t.Fatalf("interval %v", d)
+		}
+	}
+}
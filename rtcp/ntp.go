@@ -0,0 +1,24 @@
+package rtcp
+
+import (
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds from the NTP epoch (1900) to the
+// unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// ToNTP converts a time into a 64-bit NTP timestamp, 32 bits of seconds
+// followed by 32 bits of fraction.
+func ToNTP(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// FromNTP converts a 64-bit NTP timestamp into a time.
+func FromNTP(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanos := (ntp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(seconds, int64(nanos))
+}
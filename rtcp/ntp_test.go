@@ -0,0 +1,18 @@
+package rtcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNTP(t *testing.T) {
+	// 2000-01-01 00:00:00.5 UTC.
+	ntp := uint64(3155673600)<<32 | 0x80000000
+	want := time.Date(2000, 1, 1, 0, 0, 0, 5e8, time.UTC)
+	if got := FromNTP(ntp); !got.Equal(want) {
+		t.Errorf("time %v", got)
+	}
+	if got := ToNTP(want); got != ntp {
+		t.Errorf("ntp %x", got)
+	}
+}
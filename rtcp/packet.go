@@ -1,6 +1,9 @@
 package rtcp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // Packet defines a rtcp packet
 // 0                   1                   2                   3
@@ -62,15 +65,49 @@ func toUint(arr []byte) (ret uint) {
 	return ret
 }
 
-// ParsePacket parses data frame into RTCP packet
-func ParsePacket(buf []byte) Packet {
+// Errors returned by ParsePacket
+var (
+	ErrPacketTooShort     = errors.New("rtcp: packet too short")
+	ErrUnsupportedVersion = errors.New("rtcp: unsupported version")
+	ErrInvalidPadding     = errors.New("rtcp: invalid padding")
+)
+
+// ParsePacket parses data frame into RTCP packet. Only the first packet of a
+// compound packet is parsed, its payload follows the sender SSRC and refers
+// to buf with the padding, if any, stripped off.
+func ParsePacket(buf []byte) (Packet, error) {
+	if len(buf) < 4 {
+		return Packet{}, ErrPacketTooShort
+	}
 	packet := Packet{
 		Version:          buf[0] >> 6,
 		Padding:          buf[0]>>5&1 != 0,
 		ReceiptionReport: buf[0] & 0x1f,
 		PacketType:       toUint(buf[1:2]),
 		Length:           toUint(buf[2:4]),
-		SyncSource:       toUint(buf[4:8]),
 	}
-	return packet
+	if packet.Version != 2 {
+		return Packet{}, ErrUnsupportedVersion
+	}
+
+	// The length counts 32 bit words minus one, the header included.
+	end := (int(packet.Length) + 1) * 4
+	if len(buf) < end {
+		return Packet{}, ErrPacketTooShort
+	}
+	if packet.Padding {
+		padding := int(buf[end-1])
+		if padding == 0 || padding > end-4 {
+			return Packet{}, ErrInvalidPadding
+		}
+		end -= padding
+	}
+
+	i := 4
+	if end >= 8 {
+		packet.SyncSource = toUint(buf[4:8])
+		i = 8
+	}
+	packet.Payload = buf[i:end]
+	return packet, nil
 }
@@ -0,0 +1,50 @@
+package rtcp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParsePacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		buf     []byte
+		err     error
+		ssrc    uint
+		payload []byte
+	}{
+		// an empty receiver report
+		{"rr", []byte{0x80, 0xc9, 0x00, 0x01, 0xde, 0xad, 0xbe, 0xef}, nil, 0xdeadbeef, []byte{}},
+		// compound packets only parse the first one
+		{"compound", []byte{0x80, 0xc9, 0x00, 0x01, 0xde, 0xad, 0xbe, 0xef, 0x81, 0xca, 0x00, 0x00}, nil, 0xdeadbeef, []byte{}},
+		{"padding", []byte{0xa0, 0xcc, 0x00, 0x02, 0xde, 0xad, 0xbe, 0xef, 1, 0, 0, 3}, nil, 0xdeadbeef, []byte{1}},
+		{"padding too long", []byte{0xa0, 0xcc, 0x00, 0x02, 0xde, 0xad, 0xbe, 0xef, 1, 0, 0, 13}, ErrInvalidPadding, 0, nil},
+		{"length beyond buffer", []byte{0x80, 0xc8, 0x00, 0x06, 0xde, 0xad, 0xbe, 0xef}, ErrPacketTooShort, 0, nil},
+		{"short header", []byte{0x80, 0xc8, 0x00}, ErrPacketTooShort, 0, nil},
+		{"version", []byte{0x40, 0xc9, 0x00, 0x01, 0xde, 0xad, 0xbe, 0xef}, ErrUnsupportedVersion, 0, nil},
+	}
+	for _, tst := range tests {
+		packet, err := ParsePacket(tst.buf)
+		if err != tst.err {
+			t.Errorf("%s: error %v, expected %v", tst.name, err, tst.err)
+			continue
+		}
+		if err == nil && (packet.SyncSource != tst.ssrc || !bytes.Equal(packet.Payload, tst.payload)) {
+			t.Errorf("%s: ssrc %x payload % x", tst.name, packet.SyncSource, packet.Payload)
+		}
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+	f.Add([]byte{0x80, 0xc9, 0x00, 0x01, 0xde, 0xad, 0xbe, 0xef})
+	f.Add([]byte{0xa0, 0xcc, 0x00, 0x02, 0xde, 0xad, 0xbe, 0xef, 1, 0, 0, 3})
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		packet, err := ParsePacket(buf)
+		if err != nil {
+			return
+		}
+		if int(packet.Length+1)*4 > len(buf) {
+			t.Fatalf("length %d exceeds the %d bytes parsed", packet.Length, len(buf))
+		}
+	})
+}
@@ -0,0 +1,414 @@
+package rtcp
+
+import (
+	"errors"
+	"time"
+)
+
+// TypeExtendedReport is the packet type of RFC 3611 extended reports.
+const TypeExtendedReport = 207
+
+// Block types of the extended report blocks.
+const (
+	BlockLossRLE               = 1
+	BlockDuplicateRLE          = 2
+	BlockPacketReceiptTimes    = 3
+	BlockReceiverReferenceTime = 4
+	BlockDLRR                  = 5
+	BlockStatisticsSummary     = 6
+)
+
+// ErrInvalidReportBlock is returned when an extended report block is
+// malformed.
+var ErrInvalidReportBlock = errors.New("rtcp: extended report block invalid")
+
+// ReportBlock is a block of an extended report. Block types without a type
+// of their own come as an UnknownReportBlock.
+type ReportBlock interface {
+	// BlockType returns the block type.
+	BlockType() uint8
+}
+
+// ExtendedReport is a XR packet, reports of blocks of various types from
+// the participant SSRC (RFC 3611 section 2).
+type ExtendedReport struct {
+	SSRC   uint32
+	Blocks []ReportBlock
+}
+
+// Type returns the packet type.
+func (r *ExtendedReport) Type() uint { return TypeExtendedReport }
+
+// Chunk is a chunk of a run length encoded block (RFC 3611 section 4.1.1).
+// A run length chunk starts with a 0 bit, then the bit of the run and its
+// 14 bit length. A bit vector chunk starts with a 1 bit followed by 15 bits
+// for as many packets. The null chunk, 0, pads the chunks.
+type Chunk uint16
+
+// rleMaxRun is the longest run of a run length chunk.
+const rleMaxRun = 1<<14 - 1
+
+// RLEReportBlock is a Loss RLE or Duplicate RLE block, telling for each
+// packet of the sequence numbers from BeginSeq to EndSeq, excluded, whether
+// it was received or duplicated (RFC 3611 sections 4.1 and 4.2).
+type RLEReportBlock struct {
+	Type     uint8 // BlockLossRLE or BlockDuplicateRLE
+	Thinning uint8 // only the packets multiple of 2^Thinning are reported
+	SSRC     uint32
+	BeginSeq uint16
+	EndSeq   uint16
+	Chunks   []Chunk
+}
+
+// BlockType returns the block type.
+func (b *RLEReportBlock) BlockType() uint8 { return b.Type }
+
+// Bits returns the bits of the chunks in order, one per packet reported: a
+// set bit tells the packet was received, or duplicated. The last bit vector
+// may hold bits beyond EndSeq.
+func (b *RLEReportBlock) Bits() []bool {
+	var bits []bool
+	for _, chunk := range b.Chunks {
+		switch {
+		case chunk == 0:
+		case chunk&0x8000 != 0:
+			for i := 14; i >= 0; i-- {
+				bits = append(bits, chunk&(1<<uint(i)) != 0)
+			}
+		default:
+			bit := chunk&0x4000 != 0
+			for i := 0; i < int(chunk&0x3fff); i++ {
+				bits = append(bits, bit)
+			}
+		}
+	}
+	return bits
+}
+
+// EncodeChunks run length encodes bits, one per packet reported, into as
+// few chunks as it takes runs and bit vectors.
+func EncodeChunks(bits []bool) []Chunk {
+	var chunks []Chunk
+	for len(bits) > 0 {
+		run := 1
+		for run < len(bits) && run < rleMaxRun && bits[run] == bits[0] {
+			run++
+		}
+		// a bit vector takes 15 packets, shorter runs go in one.
+		if run < 15 && len(bits) > run {
+			chunk := Chunk(0x8000)
+			for i := 0; i < 15 && i < len(bits); i++ {
+				if bits[i] {
+					chunk |= 1 << uint(14-i)
+				}
+			}
+			chunks = append(chunks, chunk)
+			if len(bits) < 15 {
+				break
+			}
+			bits = bits[15:]
+			continue
+		}
+		chunk := Chunk(run)
+		if bits[0] {
+			chunk |= 0x4000
+		}
+		chunks = append(chunks, chunk)
+		bits = bits[run:]
+	}
+	return chunks
+}
+
+// PacketReceiptTimesBlock tells the arrival times of the packets from
+// BeginSeq to EndSeq, excluded, in the rtp timestamp units of the source
+// (RFC 3611 section 4.3).
+type PacketReceiptTimesBlock struct {
+	Thinning     uint8
+	SSRC         uint32
+	BeginSeq     uint16
+	EndSeq       uint16
+	ReceiptTimes []uint32
+}
+
+// BlockType returns the block type.
+func (b *PacketReceiptTimesBlock) BlockType() uint8 { return BlockPacketReceiptTimes }
+
+// ReceiverReferenceTimeBlock carries the NTP time a receiver sent the report
+// at, the sender answers with a DLRR block (RFC 3611 section 4.4).
+type ReceiverReferenceTimeBlock struct {
+	NTPTime uint64
+}
+
+// BlockType returns the block type.
+func (b *ReceiverReferenceTimeBlock) BlockType() uint8 { return BlockReceiverReferenceTime }
+
+// DLRRReport is a sub-block of a DLRR block, answering the last receiver
+// reference time block of SSRC.
+type DLRRReport struct {
+	SSRC             uint32
+	LastReceiverTime uint32 // middle 32 bits of the NTP time of the last RRTR
+	Delay            uint32 // since the last RRTR, in 1/65536 seconds
+}
+
+// DLRRBlock is a delay since last receiver report block (RFC 3611 section
+// 4.5).
+type DLRRBlock struct {
+	Reports []DLRRReport
+}
+
+// BlockType returns the block type.
+func (b *DLRRBlock) BlockType() uint8 { return BlockDLRR }
+
+// StatisticsSummaryBlock summarizes the reception of the packets from
+// BeginSeq to EndSeq, excluded (RFC 3611 section 4.6). The flags tell which
+// of the fields are given; ToH is 1 for the IPv4 TTL and 2 for the IPv6 hop
+// limit.
+type StatisticsSummaryBlock struct {
+	LossReport      bool
+	DuplicateReport bool
+	JitterReport    bool
+	ToH             uint8
+	SSRC            uint32
+	BeginSeq        uint16
+	EndSeq          uint16
+	LostPackets     uint32
+	DupPackets      uint32
+	MinJitter       uint32
+	MaxJitter       uint32
+	MeanJitter      uint32
+	DevJitter       uint32
+	MinTTL          uint8
+	MaxTTL          uint8
+	MeanTTL         uint8
+	DevTTL          uint8
+}
+
+// BlockType returns the block type.
+func (b *StatisticsSummaryBlock) BlockType() uint8 { return BlockStatisticsSummary }
+
+// UnknownReportBlock is a block of a type that isn't parsed.
+type UnknownReportBlock struct {
+	Type         uint8
+	TypeSpecific uint8
+	Content      []byte
+}
+
+// BlockType returns the block type.
+func (b *UnknownReportBlock) BlockType() uint8 { return b.Type }
+
+// RoundTripTime computes the round trip time from the time a report of the
+// remote party arrived, and the middle 32 bits of the NTP time and the delay
+// it answers with: the LSR and DLSR of a reception report, or the LRR and
+// DLRR of a DLRR report. It is false when the remote party didn't answer.
+func RoundTripTime(arrival time.Time, last, delay uint32) (time.Duration, bool) {
+	if last == 0 {
+		return 0, false
+	}
+	rtt := int32(uint32(ToNTP(arrival)>>16) - last - delay)
+	if rtt < 0 {
+		rtt = 0
+	}
+	return time.Duration(rtt) * time.Second / 65536, true
+}
+
+// parseExtendedReport parses the body of a XR packet.
+func parseExtendedReport(body []byte) (*ExtendedReport, error) {
+	if len(body) < 4 {
+		return nil, ErrInvalidLength
+	}
+	xr := &ExtendedReport{SSRC: uint32(toUint(body[0:4]))}
+	for body = body[4:]; len(body) > 0; {
+		if len(body) < 4 {
+			return nil, ErrInvalidReportBlock
+		}
+		blockType, typeSpecific := body[0], body[1]
+		end := 4 + 4*int(toUint(body[2:4]))
+		if len(body) < end {
+			return nil, ErrInvalidReportBlock
+		}
+		block, err := parseReportBlock(blockType, typeSpecific, body[4:end])
+		if err != nil {
+			return nil, err
+		}
+		xr.Blocks = append(xr.Blocks, block)
+		body = body[end:]
+	}
+	return xr, nil
+}
+
+// parseReportBlock parses the content of a block, following its header.
+func parseReportBlock(blockType, typeSpecific uint8, content []byte) (ReportBlock, error) {
+	switch blockType {
+	case BlockLossRLE, BlockDuplicateRLE:
+		if len(content) < 8 {
+			return nil, ErrInvalidReportBlock
+		}
+		block := &RLEReportBlock{
+			Type:     blockType,
+			Thinning: typeSpecific & 0x0f,
+			SSRC:     uint32(toUint(content[0:4])),
+			BeginSeq: uint16(toUint(content[4:6])),
+			EndSeq:   uint16(toUint(content[6:8])),
+		}
+		for c := content[8:]; len(c) >= 2; c = c[2:] {
+			if chunk := Chunk(toUint(c[0:2])); chunk != 0 {
+				block.Chunks = append(block.Chunks, chunk)
+			}
+		}
+		return block, nil
+
+	case BlockPacketReceiptTimes:
+		if len(content) < 8 {
+			return nil, ErrInvalidReportBlock
+		}
+		block := &PacketReceiptTimesBlock{
+			Thinning: typeSpecific & 0x0f,
+			SSRC:     uint32(toUint(content[0:4])),
+			BeginSeq: uint16(toUint(content[4:6])),
+			EndSeq:   uint16(toUint(content[6:8])),
+		}
+		for c := content[8:]; len(c) >= 4; c = c[4:] {
+			block.ReceiptTimes = append(block.ReceiptTimes, uint32(toUint(c[0:4])))
+		}
+		return block, nil
+
+	case BlockReceiverReferenceTime:
+		if len(content) != 8 {
+			return nil, ErrInvalidReportBlock
+		}
+		return &ReceiverReferenceTimeBlock{
+			NTPTime: uint64(toUint(content[0:4]))<<32 | uint64(toUint(content[4:8])),
+		}, nil
+
+	case BlockDLRR:
+		if len(content)%12 != 0 {
+			return nil, ErrInvalidReportBlock
+		}
+		block := &DLRRBlock{}
+		for c := content; len(c) >= 12; c = c[12:] {
+			block.Reports = append(block.Reports, DLRRReport{
+				SSRC:             uint32(toUint(c[0:4])),
+				LastReceiverTime: uint32(toUint(c[4:8])),
+				Delay:            uint32(toUint(c[8:12])),
+			})
+		}
+		return block, nil
+
+	case BlockStatisticsSummary:
+		if len(content) != 36 {
+			return nil, ErrInvalidReportBlock
+		}
+		return &StatisticsSummaryBlock{
+			LossReport:      typeSpecific&0x80 != 0,
+			DuplicateReport: typeSpecific&0x40 != 0,
+			JitterReport:    typeSpecific&0x20 != 0,
+			ToH:             typeSpecific >> 3 & 0x03,
+			SSRC:            uint32(toUint(content[0:4])),
+			BeginSeq:        uint16(toUint(content[4:6])),
+			EndSeq:          uint16(toUint(content[6:8])),
+			LostPackets:     uint32(toUint(content[8:12])),
+			DupPackets:      uint32(toUint(content[12:16])),
+			MinJitter:       uint32(toUint(content[16:20])),
+			MaxJitter:       uint32(toUint(content[20:24])),
+			MeanJitter:      uint32(toUint(content[24:28])),
+			DevJitter:       uint32(toUint(content[28:32])),
+			MinTTL:          content[32],
+			MaxTTL:          content[33],
+			MeanTTL:         content[34],
+			DevTTL:          content[35],
+		}, nil
+	}
+
+	return &UnknownReportBlock{Type: blockType, TypeSpecific: typeSpecific, Content: content}, nil
+}
+
+// Marshal returns the packet in wire format.
+func (r *ExtendedReport) Marshal() ([]byte, error) {
+	buf := make([]byte, 8)
+	putUint(buf[4:8], uint(r.SSRC))
+	for _, block := range r.Blocks {
+		var err error
+		if buf, err = appendReportBlock(buf, block); err != nil {
+			return nil, err
+		}
+	}
+	header(buf, 0, TypeExtendedReport)
+	return buf, nil
+}
+
+// appendReportBlock appends a block in wire format to buf.
+func appendReportBlock(buf []byte, block ReportBlock) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, block.BlockType(), 0, 0, 0)
+	var u32 [4]byte
+	put32 := func(v uint32) {
+		putUint(u32[:], uint(v))
+		buf = append(buf, u32[:]...)
+	}
+	put16 := func(a, b uint16) { put32(uint32(a)<<16 | uint32(b)) }
+
+	switch b := block.(type) {
+	case *RLEReportBlock:
+		buf[start+1] = b.Thinning & 0x0f
+		put32(b.SSRC)
+		put16(b.BeginSeq, b.EndSeq)
+		for _, chunk := range b.Chunks {
+			buf = append(buf, byte(chunk>>8), byte(chunk))
+		}
+		// null chunks pad to the 32 bit boundary.
+		for len(buf)%4 != 0 {
+			buf = append(buf, 0)
+		}
+
+	case *PacketReceiptTimesBlock:
+		buf[start+1] = b.Thinning & 0x0f
+		put32(b.SSRC)
+		put16(b.BeginSeq, b.EndSeq)
+		for _, t := range b.ReceiptTimes {
+			put32(t)
+		}
+
+	case *ReceiverReferenceTimeBlock:
+		put32(uint32(b.NTPTime >> 32))
+		put32(uint32(b.NTPTime))
+
+	case *DLRRBlock:
+		for _, report := range b.Reports {
+			put32(report.SSRC)
+			put32(report.LastReceiverTime)
+			put32(report.Delay)
+		}
+
+	case *StatisticsSummaryBlock:
+		flags := b.ToH & 0x03 << 3
+		if b.LossReport {
+			flags |= 0x80
+		}
+		if b.DuplicateReport {
+			flags |= 0x40
+		}
+		if b.JitterReport {
+			flags |= 0x20
+		}
+		buf[start+1] = flags
+		put32(b.SSRC)
+		put16(b.BeginSeq, b.EndSeq)
+		for _, v := range []uint32{b.LostPackets, b.DupPackets, b.MinJitter, b.MaxJitter, b.MeanJitter, b.DevJitter} {
+			put32(v)
+		}
+		buf = append(buf, b.MinTTL, b.MaxTTL, b.MeanTTL, b.DevTTL)
+
+	case *UnknownReportBlock:
+		if len(b.Content)%4 != 0 {
+			return nil, ErrInvalidReportBlock
+		}
+		buf[start+1] = b.TypeSpecific
+		buf = append(buf, b.Content...)
+
+	default:
+		return nil, ErrInvalidReportBlock
+	}
+
+	putUint(buf[start+2:start+4], uint((len(buf)-start)/4-1))
+	return buf, nil
+}
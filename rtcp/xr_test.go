@@ -0,0 +1,75 @@
+package rtcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeChunks(t *testing.T) {
+	// a run of 20 received, a few lost here and there, a run of 30 lost of
+	// which the bit vector takes 10.
+	var bits []bool
+	for i := 0; i < 20; i++ {
+		bits = append(bits, true)
+	}
+	bits = append(bits, false, true, true, false, true)
+	for i := 0; i < 30; i++ {
+		bits = append(bits, false)
+	}
+	chunks := EncodeChunks(bits)
+	want := []Chunk{0x4000 | 20, 0x8000 | 0x3400, 20}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Fatalf("chunks %04x", chunks)
+	}
+	block := RLEReportBlock{Chunks: chunks}
+	if got := block.Bits(); !reflect.DeepEqual(got, bits) {
+		t.Errorf("bits %v", got)
+	}
+}
+
+func TestExtendedReport(t *testing.T) {
+	xr := &ExtendedReport{SSRC: 0x1234, Blocks: []ReportBlock{
+		&RLEReportBlock{Type: BlockLossRLE, Thinning: 2, SSRC: 0xcafe, BeginSeq: 10, EndSeq: 40, Chunks: []Chunk{0x4000 | 20, 0x8000 | 0x1555, 0x0005}},
+		&RLEReportBlock{Type: BlockDuplicateRLE, SSRC: 0xcafe, BeginSeq: 10, EndSeq: 12, Chunks: []Chunk{0x8000 | 0x4000}},
+		&PacketReceiptTimesBlock{SSRC: 0xcafe, BeginSeq: 1, EndSeq: 3, ReceiptTimes: []uint32{100, 260}},
+		&ReceiverReferenceTimeBlock{NTPTime: 0x0102030405060708},
+		&DLRRBlock{Reports: []DLRRReport{{SSRC: 1, LastReceiverTime: 2, Delay: 3}, {SSRC: 4, LastReceiverTime: 5, Delay: 6}}},
+		&StatisticsSummaryBlock{LossReport: true, JitterReport: true, ToH: 1, SSRC: 0xcafe, BeginSeq: 1, EndSeq: 100,
+			LostPackets: 3, MinJitter: 1, MaxJitter: 9, MeanJitter: 4, DevJitter: 2, MinTTL: 60, MaxTTL: 64, MeanTTL: 62, DevTTL: 1},
+		&UnknownReportBlock{Type: 42, TypeSpecific: 7, Content: []byte{1, 2, 3, 4}},
+	}}
+	buf, err := xr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 0x80 || buf[1] != 207 || int(toUint(buf[2:4])) != len(buf)/4-1 {
+		t.Fatalf("header % x", buf[:4])
+	}
+	messages, err := ParseCompound(concat([]byte{0x80, 201, 0, 1, 0, 0, 0, 1}, buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || !reflect.DeepEqual(messages[1], xr) {
+		t.Errorf("parsed %+v", messages[1])
+	}
+
+	// a block beyond the packet.
+	buf = concat([]byte{0x80, 201, 0, 1, 0, 0, 0, 1}, []byte{0x80, 207, 0, 2, 0, 0, 0, 1, 4, 0, 0, 2})
+	if _, err := ParseCompound(buf); err != ErrInvalidReportBlock {
+		t.Errorf("truncated block: %v", err)
+	}
+}
+
+func TestRoundTripTime(t *testing.T) {
+	sent := time.Unix(1000, 0)
+	arrival := sent.Add(250 * time.Millisecond)
+	// the remote party held the report for 100ms.
+	rtt, ok := RoundTripTime(arrival, uint32(ToNTP(sent)>>16), 65536/10)
+	if !ok || rtt < 149*time.Millisecond || rtt > 151*time.Millisecond {
+		t.Errorf("rtt %v %v", rtt, ok)
+	}
+	if _, ok := RoundTripTime(arrival, 0, 0); ok {
+		t.Error("rtt without a report")
+	}
+}
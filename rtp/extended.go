@@ -0,0 +1,117 @@
+package rtp
+
+// Limits of the sequence number validation of RFC 3550 appendix A.1.
+const (
+	maxDropout    = 3000
+	minSequential = 2
+	seqMod        = 1 << 16
+)
+
+// SequenceTracker extends the 16-bit sequence numbers of one source to 64
+// bits, following the algorithm of RFC 3550 appendix A.1. The zero value is
+// ready to use.
+type SequenceTracker struct {
+	MaxSeq    uint16 // highest sequence number seen
+	Cycles    uint64 // shifted count of sequence number cycles
+	BaseSeq   uint64 // extended sequence number of the first packet
+	Received  uint64 // packets received since the tracker was (re)started
+	Probation int    // sequential packets still needed until the source is valid
+
+	started bool
+	badSeq  uint64
+}
+
+func (t *SequenceTracker) init(seq uint16) {
+	t.BaseSeq = uint64(seq)
+	t.MaxSeq = seq
+	t.badSeq = seqMod + 1 // so seq == badSeq is false
+	t.Cycles = 0
+	t.Received = 0
+}
+
+// Update accounts for a received sequence number and returns its extended
+// value. valid is false while the source is on probation, and for packets
+// that jump too far from the previous ones until the jump is confirmed by a
+// following packet, which restarts the tracker.
+func (t *SequenceTracker) Update(seq uint16) (extended uint64, valid bool) {
+	if !t.started {
+		t.started = true
+		t.init(seq)
+		t.MaxSeq = seq - 1
+		t.Probation = minSequential
+	}
+
+	udelta := seq - t.MaxSeq
+	if t.Probation > 0 {
+		// the source is not valid until minSequential packets in sequence
+		// have been received.
+		if seq == t.MaxSeq+1 {
+			t.Probation--
+			t.MaxSeq = seq
+			if t.Probation == 0 {
+				t.init(seq)
+				t.Received++
+				return t.Extended(), true
+			}
+		} else {
+			t.Probation = minSequential - 1
+			t.MaxSeq = seq
+		}
+		return t.Cycles + uint64(seq), false
+	}
+
+	switch {
+	case udelta < maxDropout:
+		// in order, with permissible gap
+		if seq < t.MaxSeq {
+			t.Cycles += seqMod
+		}
+		t.MaxSeq = seq
+	case udelta <= seqMod-maxMisorder:
+		// the sequence number made a very large jump
+		if uint64(seq) == t.badSeq {
+			// two sequential packets, assume the other side restarted
+			// without telling us.
+			t.init(seq)
+		} else {
+			t.badSeq = uint64(seq+1) & (seqMod - 1)
+			return t.Cycles + uint64(seq), false
+		}
+	default:
+		// duplicate or reordered packet, it belongs to the previous cycle
+		// when it is numbered above the highest one seen.
+		t.Received++
+		if seq > t.MaxSeq && t.Cycles >= seqMod {
+			return t.Cycles - seqMod + uint64(seq), true
+		}
+		return t.Cycles + uint64(seq), true
+	}
+	t.Received++
+	return t.Extended(), true
+}
+
+// Extended returns the highest extended sequence number seen.
+func (t *SequenceTracker) Extended() uint64 {
+	return t.Cycles + uint64(t.MaxSeq)
+}
+
+// TimestampUnwrapper extends the 32-bit timestamps of one source to 64 bits.
+// A timestamp is placed at the shortest distance from the previous one, so
+// reordered packets keep their place across a wraparound. The zero value is
+// ready to use.
+type TimestampUnwrapper struct {
+	started bool
+	last    int64
+}
+
+// Unwrap returns the extended value of a timestamp. The first timestamp is
+// returned unchanged, earlier ones may go below it and below zero.
+func (u *TimestampUnwrapper) Unwrap(timestamp uint32) int64 {
+	if !u.started {
+		u.started = true
+		u.last = int64(timestamp)
+		return u.last
+	}
+	u.last += int64(int32(timestamp - uint32(u.last)))
+	return u.last
+}
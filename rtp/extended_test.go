@@ -0,0 +1,56 @@
+package rtp
+
+import (
+	"testing"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	var tracker SequenceTracker
+	tests := []struct {
+		seq      uint16
+		extended uint64
+		valid    bool
+	}{
+		{65533, 65533, false}, // on probation
+		{65534, 65534, true},
+		{65535, 65535, true},
+		{1, 65537, true},
+		{0, 65536, true}, // reordered across the wraparound
+		{65535, 65535, true},
+		{2, 65538, true},
+		{30000, 30000 + 65536, false}, // large jump
+		{30001, 30001, true},          // confirmed, the tracker restarts
+		{30002, 30002, true},
+	}
+	for i, tst := range tests {
+		extended, valid := tracker.Update(tst.seq)
+		if extended != tst.extended || valid != tst.valid {
+			t.Errorf("#%d seq %d: extended %d valid %v, expected %d %v", i, tst.seq, extended, valid, tst.extended, tst.valid)
+		}
+	}
+	if tracker.Extended() != 30002 || tracker.Received != 2 {
+		t.Errorf("extended %d received %d", tracker.Extended(), tracker.Received)
+	}
+}
+
+func TestTimestampUnwrapper(t *testing.T) {
+	var u TimestampUnwrapper
+	tests := []struct {
+		timestamp uint32
+		extended  int64
+	}{
+		{0xfffff000, 0xfffff000},
+		{0xffffff00, 0xffffff00},
+		{0x00000100, 0x100000100},
+		{0xfffffff0, 0xfffffff0}, // reordered across the wraparound
+		{0x00000200, 0x100000200},
+		{0x70000000, 0x170000000},
+		{0xe0000000, 0x1e0000000},
+		{0x00000300, 0x200000300},
+	}
+	for i, tst := range tests {
+		if extended := u.Unwrap(tst.timestamp); extended != tst.extended {
+			t.Errorf("#%d timestamp %#x: extended %#x, expected %#x", i, tst.timestamp, extended, tst.extended)
+		}
+	}
+}
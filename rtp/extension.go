@@ -0,0 +1,349 @@
+package rtp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/solomondong/rtsp/rtcp"
+)
+
+// Header extension profiles.
+const (
+	// ExtensionProfileOneByte is the RFC 8285 one-byte header profile.
+	ExtensionProfileOneByte = 0xBEDE
+	// ExtensionProfileTwoByte is the RFC 8285 two-byte header profile, the
+	// low 4 bits are application bits.
+	ExtensionProfileTwoByte = 0x1000
+	// ExtensionProfileOnvifReplay is the ONVIF replay extension.
+	ExtensionProfileOnvifReplay = 0xABAC
+)
+
+// URIs of the header extensions with helpers in this package.
+const (
+	AbsSendTimeURI    = "http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"
+	AbsCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+)
+
+// Errors returned for header extensions
+var (
+	ErrExtensionProfile   = errors.New("rtp: header extension is not RFC 8285")
+	ErrExtensionTruncated = errors.New("rtp: header extension element truncated")
+	ErrExtensionID        = errors.New("rtp: header extension id out of range")
+	ErrExtensionSize      = errors.New("rtp: header extension element size invalid")
+)
+
+// Extension is an element of a RFC 8285 header extension.
+type Extension struct {
+	ID   byte
+	Data []byte
+}
+
+// ExtensionMap maps extension ids to their URIs, as negotiated by the SDP
+// extmap attributes.
+type ExtensionMap map[byte]string
+
+// ID finds the id an extension URI is mapped to.
+func (m ExtensionMap) ID(uri string) (byte, bool) {
+	for id, u := range m {
+		if u == uri {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func isTwoByteProfile(profile uint) bool {
+	return profile&0xfff0 == ExtensionProfileTwoByte
+}
+
+// Extensions decodes the RFC 8285 header extension elements of the packet,
+// the element data refers to ExtData.
+func (r Packet) Extensions() ([]Extension, error) {
+	if !r.Ext {
+		return nil, nil
+	}
+	oneByte := r.ExtHeader == ExtensionProfileOneByte
+	if !oneByte && !isTwoByteProfile(r.ExtHeader) {
+		return nil, ErrExtensionProfile
+	}
+
+	var extensions []Extension
+	data := r.ExtData
+	for len(data) > 0 {
+		if data[0] == 0 {
+			// padding
+			data = data[1:]
+			continue
+		}
+		var id byte
+		var size int
+		if oneByte {
+			id = data[0] >> 4
+			if id == 15 {
+				// reserved, processing stops here.
+				break
+			}
+			size = int(data[0]&0x0f) + 1
+			data = data[1:]
+		} else {
+			if len(data) < 2 {
+				return nil, ErrExtensionTruncated
+			}
+			id, size = data[0], int(data[1])
+			data = data[2:]
+		}
+		if len(data) < size {
+			return nil, ErrExtensionTruncated
+		}
+		extensions = append(extensions, Extension{ID: id, Data: data[:size]})
+		data = data[size:]
+	}
+	return extensions, nil
+}
+
+// Extension returns the data of the header extension element with an id.
+func (r Packet) Extension(id byte) ([]byte, bool) {
+	extensions, err := r.Extensions()
+	if err != nil {
+		return nil, false
+	}
+	for _, e := range extensions {
+		if e.ID == id {
+			return e.Data, true
+		}
+	}
+	return nil, false
+}
+
+// ExtensionByURI returns the data of the header extension element mapped to
+// a URI.
+func (r Packet) ExtensionByURI(m ExtensionMap, uri string) ([]byte, bool) {
+	id, ok := m.ID(uri)
+	if !ok {
+		return nil, false
+	}
+	return r.Extension(id)
+}
+
+// SetExtension adds or replaces a header extension element. The one-byte
+// format is kept as long as every element fits it, otherwise the two-byte
+// format is used.
+func (r *Packet) SetExtension(id byte, data []byte) error {
+	if id == 0 {
+		return ErrExtensionID
+	}
+	if len(data) > 255 {
+		return ErrExtensionSize
+	}
+	extensions, err := r.Extensions()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range extensions {
+		if extensions[i].ID == id {
+			extensions[i].Data = data
+			replaced = true
+		}
+	}
+	if !replaced {
+		extensions = append(extensions, Extension{ID: id, Data: data})
+	}
+	r.setExtensions(extensions)
+	return nil
+}
+
+// DelExtension removes a header extension element.
+func (r *Packet) DelExtension(id byte) error {
+	extensions, err := r.Extensions()
+	if err != nil {
+		return err
+	}
+	kept := extensions[:0]
+	for _, e := range extensions {
+		if e.ID != id {
+			kept = append(kept, e)
+		}
+	}
+	r.setExtensions(kept)
+	return nil
+}
+
+func (r *Packet) setExtensions(extensions []Extension) {
+	if len(extensions) == 0 {
+		r.Ext, r.ExtHeader, r.ExtData = false, 0, nil
+		return
+	}
+
+	oneByte := true
+	size := 0
+	for _, e := range extensions {
+		if e.ID > 14 || len(e.Data) == 0 || len(e.Data) > 16 {
+			oneByte = false
+		}
+		size += 2 + len(e.Data)
+	}
+
+	data := make([]byte, 0, (size+3)&^3)
+	for _, e := range extensions {
+		if oneByte {
+			data = append(data, e.ID<<4|byte(len(e.Data)-1))
+		} else {
+			data = append(data, e.ID, byte(len(e.Data)))
+		}
+		data = append(data, e.Data...)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	r.Ext, r.ExtData = true, data
+	if oneByte {
+		r.ExtHeader = ExtensionProfileOneByte
+	} else if !isTwoByteProfile(r.ExtHeader) {
+		r.ExtHeader = ExtensionProfileTwoByte
+	}
+}
+
+// ParseAbsSendTime decodes an abs-send-time extension, a 6.18 fixed point
+// NTP time in seconds, which wraps every 64 seconds. The time returned is
+// the one closest to near, usually the arrival time.
+func ParseAbsSendTime(data []byte, near time.Time) (time.Time, error) {
+	if len(data) != 3 {
+		return time.Time{}, ErrExtensionSize
+	}
+	sent := uint64(data[0])<<16 | uint64(data[1])<<8 | uint64(data[2])
+	// the 24 bits are bits 6 to 30 of the NTP timestamp.
+	const period = 1 << 38
+	ntp := rtcp.ToNTP(near)
+	base := ntp &^ (period - 1)
+	sent = base | sent<<14
+	switch {
+	case sent > ntp && sent-ntp > period/2:
+		sent -= period
+	case sent < ntp && ntp-sent > period/2:
+		sent += period
+	}
+	return rtcp.FromNTP(sent), nil
+}
+
+// MarshalAbsSendTime encodes a time into an abs-send-time extension.
+func MarshalAbsSendTime(t time.Time) []byte {
+	sent := rtcp.ToNTP(t) >> 14
+	return []byte{byte(sent >> 16), byte(sent >> 8), byte(sent)}
+}
+
+// AbsCaptureTime is the abs-capture-time extension, the NTP time the first
+// frame of the packet was captured at, with the offset of the capturer's
+// clock to the sender's clock when known.
+type AbsCaptureTime struct {
+	Timestamp   time.Time
+	HasOffset   bool
+	ClockOffset time.Duration
+}
+
+// ParseAbsCaptureTime decodes an abs-capture-time extension.
+func ParseAbsCaptureTime(data []byte) (a AbsCaptureTime, err error) {
+	if len(data) != 8 && len(data) != 16 {
+		err = ErrExtensionSize
+		return
+	}
+	a.Timestamp = rtcp.FromNTP(toUint64(data[0:8]))
+	if len(data) == 16 {
+		// signed Q32.32 seconds.
+		offset := int64(toUint64(data[8:16]))
+		a.HasOffset = true
+		a.ClockOffset = time.Duration(offset>>32)*time.Second +
+			time.Duration((offset&0xffffffff)*int64(time.Second)>>32)
+	}
+	return
+}
+
+// Marshal encodes the abs-capture-time extension.
+func (a AbsCaptureTime) Marshal() []byte {
+	data := make([]byte, 8, 16)
+	putUint64(data, rtcp.ToNTP(a.Timestamp))
+	if a.HasOffset {
+		seconds := int64(a.ClockOffset / time.Second)
+		nanos := int64(a.ClockOffset % time.Second)
+		if nanos < 0 {
+			seconds--
+			nanos += int64(time.Second)
+		}
+		data = data[:16]
+		putUint64(data[8:], uint64(seconds<<32|nanos<<32/int64(time.Second)))
+	}
+	return data
+}
+
+// OnvifReplay is the ONVIF replay header extension, which gives the wall
+// clock time of the recorded packets played back by a NVR.
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |             0xABAC            |           length=3            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          NTP timestamp...                     |
+// |                       ...NTP timestamp                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |C|E|D|T|  mbz  |     CSeq      |           padding             |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type OnvifReplay struct {
+	Time time.Time
+	// CleanPoint marks an access unit a decoder can start from.
+	CleanPoint bool
+	// End marks the last packet of an access unit.
+	End bool
+	// Discontinuity marks a gap in the recording before this packet.
+	Discontinuity bool
+	// Terminal marks the last packet of the recording.
+	Terminal bool
+	// CSeq is the low byte of the CSeq of the PLAY request.
+	CSeq byte
+}
+
+// OnvifReplay decodes the ONVIF replay extension of the packet, if any.
+func (r Packet) OnvifReplay() (o OnvifReplay, ok bool) {
+	if !r.Ext || r.ExtHeader != ExtensionProfileOnvifReplay || len(r.ExtData) < 12 {
+		return
+	}
+	o.Time = rtcp.FromNTP(toUint64(r.ExtData[0:8]))
+	flags := r.ExtData[8]
+	o.CleanPoint = flags&0x80 != 0
+	o.End = flags&0x40 != 0
+	o.Discontinuity = flags&0x20 != 0
+	o.Terminal = flags&0x10 != 0
+	o.CSeq = r.ExtData[9]
+	return o, true
+}
+
+// SetOnvifReplay sets the ONVIF replay extension as the header extension of
+// the packet.
+func (r *Packet) SetOnvifReplay(o OnvifReplay) {
+	data := make([]byte, 12)
+	putUint64(data, rtcp.ToNTP(o.Time))
+	if o.CleanPoint {
+		data[8] |= 0x80
+	}
+	if o.End {
+		data[8] |= 0x40
+	}
+	if o.Discontinuity {
+		data[8] |= 0x20
+	}
+	if o.Terminal {
+		data[8] |= 0x10
+	}
+	data[9] = o.CSeq
+	r.Ext, r.ExtHeader, r.ExtData = true, ExtensionProfileOnvifReplay, data
+}
+
+func toUint64(arr []byte) uint64 {
+	return uint64(toUint(arr[0:4]))<<32 | uint64(toUint(arr[4:8]))
+}
+
+func putUint64(arr []byte, v uint64) {
+	putUint(arr[0:4], uint(v>>32))
+	putUint(arr[4:8], uint(v))
+}
@@ -0,0 +1,150 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestExtensionsOneByte(t *testing.T) {
+	// id 1 with 1 byte, padding, id 2 with 3 bytes, padding.
+	packet := Packet{Ext: true, ExtHeader: ExtensionProfileOneByte, ExtData: []byte{0x10, 0xaa, 0x00, 0x22, 1, 2, 3, 0}}
+	extensions, err := packet.Extensions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extensions) != 2 || extensions[0].ID != 1 || !bytes.Equal(extensions[0].Data, []byte{0xaa}) ||
+		extensions[1].ID != 2 || !bytes.Equal(extensions[1].Data, []byte{1, 2, 3}) {
+		t.Fatalf("extensions %v", extensions)
+	}
+
+	m := ExtensionMap{2: AbsSendTimeURI}
+	if data, ok := packet.ExtensionByURI(m, AbsSendTimeURI); !ok || len(data) != 3 {
+		t.Errorf("extension by uri %v %v", data, ok)
+	}
+	if _, ok := packet.ExtensionByURI(m, AbsCaptureTimeURI); ok {
+		t.Errorf("unmapped extension found")
+	}
+
+	packet.ExtData = []byte{0x13, 1, 2, 0}
+	if _, err := packet.Extensions(); err != ErrExtensionTruncated {
+		t.Errorf("truncated element: %v", err)
+	}
+	packet.ExtHeader = 0x1234
+	if _, err := packet.Extensions(); err != ErrExtensionProfile {
+		t.Errorf("unknown profile: %v", err)
+	}
+}
+
+func TestSetExtension(t *testing.T) {
+	packet := Packet{Version: RTPVERSION, SequenceNumber: 1, Payload: []byte{9}}
+	if err := packet.SetExtension(3, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if packet.ExtHeader != ExtensionProfileOneByte || !bytes.Equal(packet.ExtData, []byte{0x31, 1, 2, 0}) {
+		t.Fatalf("one-byte extension %x % x", packet.ExtHeader, packet.ExtData)
+	}
+
+	// an empty element needs the two-byte format.
+	if err := packet.SetExtension(20, nil); err != nil {
+		t.Fatal(err)
+	}
+	if packet.ExtHeader != ExtensionProfileTwoByte || !bytes.Equal(packet.ExtData, []byte{3, 2, 1, 2, 20, 0, 0, 0}) {
+		t.Fatalf("two-byte extension %x % x", packet.ExtHeader, packet.ExtData)
+	}
+
+	buf, err := packet.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePacket(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := parsed.Extension(3); !ok || !bytes.Equal(data, []byte{1, 2}) {
+		t.Errorf("extension 3 %v %v", data, ok)
+	}
+	if data, ok := parsed.Extension(20); !ok || len(data) != 0 {
+		t.Errorf("extension 20 %v %v", data, ok)
+	}
+
+	if err := packet.DelExtension(20); err != nil {
+		t.Fatal(err)
+	}
+	if err := packet.DelExtension(3); err != nil {
+		t.Fatal(err)
+	}
+	if packet.Ext || packet.ExtData != nil {
+		t.Errorf("extension left after deleting all elements: % x", packet.ExtData)
+	}
+	if err := packet.SetExtension(0, []byte{1}); err != ErrExtensionID {
+		t.Errorf("id 0: %v", err)
+	}
+}
+
+func TestAbsSendTime(t *testing.T) {
+	sent := time.Date(2020, 5, 7, 9, 59, 27, 900000000, time.UTC)
+	data := MarshalAbsSendTime(sent)
+	// received just after the 64 seconds wraparound.
+	got, err := ParseAbsSendTime(data, sent.Add(400*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := got.Sub(sent); d < -4*time.Microsecond || d > 4*time.Microsecond {
+		t.Errorf("abs-send-time %v, sent %v", got, sent)
+	}
+	if _, err := ParseAbsSendTime(data[:2], sent); err != ErrExtensionSize {
+		t.Errorf("short abs-send-time: %v", err)
+	}
+}
+
+func TestAbsCaptureTime(t *testing.T) {
+	a := AbsCaptureTime{
+		Timestamp:   time.Date(2020, 5, 7, 9, 58, 37, 0, time.UTC),
+		HasOffset:   true,
+		ClockOffset: -1500 * time.Millisecond,
+	}
+	data := a.Marshal()
+	if len(data) != 16 {
+		t.Fatalf("marshalled %d bytes", len(data))
+	}
+	parsed, err := ParseAbsCaptureTime(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Timestamp.Equal(a.Timestamp) || !parsed.HasOffset || parsed.ClockOffset != a.ClockOffset {
+		t.Errorf("parsed %+v, expected %+v", parsed, a)
+	}
+	if parsed, _ := ParseAbsCaptureTime(data[:8]); parsed.HasOffset {
+		t.Errorf("offset parsed from a short extension")
+	}
+}
+
+func TestOnvifReplay(t *testing.T) {
+	// as sent by an NVR, 2020-05-07 09:58:37.5 with C and E set, CSeq 4.
+	buf := []byte{
+		0x90, 0x60, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1,
+		0xab, 0xac, 0, 3,
+		0xe2, 0x5e, 0x5a, 0x4d, 0x80, 0, 0, 0,
+		0xc0, 4, 0, 0,
+		9,
+	}
+	packet, err := ParsePacket(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, ok := packet.OnvifReplay()
+	if !ok {
+		t.Fatal("onvif replay extension not found")
+	}
+	exp := OnvifReplay{Time: time.Date(2020, 5, 7, 9, 58, 37, 500000000, time.UTC), CleanPoint: true, End: true, CSeq: 4}
+	if !o.Time.Equal(exp.Time) || o.CleanPoint != exp.CleanPoint || o.End != exp.End || o.Discontinuity || o.Terminal || o.CSeq != 4 {
+		t.Errorf("onvif replay %+v", o)
+	}
+
+	var out Packet
+	out.SetOnvifReplay(o)
+	if !bytes.Equal(out.ExtData, packet.ExtData) {
+		t.Errorf("marshalled % x, expected % x", out.ExtData, packet.ExtData)
+	}
+}
@@ -0,0 +1,438 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Errors returned when reading redundancy packets
+var (
+	ErrREDTruncated       = errors.New("rtp: red packet truncated")
+	ErrFECTruncated       = errors.New("rtp: fec packet truncated")
+	ErrFECUnsupported     = errors.New("rtp: fec packet unsupported")
+	ErrFECNoProtectedSSRC = errors.New("rtp: flexfec packet without protected ssrc")
+)
+
+// REDBlock is a redundant block of a RED packet (RFC 2198), an older
+// payload sent again.
+type REDBlock struct {
+	PayloadType     byte
+	TimestampOffset uint
+	Payload         []byte
+}
+
+// ParseRED splits a RED packet into its primary block, returned as a packet
+// with the payload type of the block, and its redundant blocks.
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |F|   block PT  |  timestamp offset         |   block length    |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |0|   block PT  |
+// +-+-+-+-+-+-+-+-+
+func ParseRED(packet Packet) (primary Packet, redundant []REDBlock, err error) {
+	p := packet.Payload
+	i := 0
+	for {
+		if len(p) < i+1 {
+			return Packet{}, nil, ErrREDTruncated
+		}
+		if p[i]&0x80 == 0 {
+			break
+		}
+		if len(p) < i+4 {
+			return Packet{}, nil, ErrREDTruncated
+		}
+		redundant = append(redundant, REDBlock{
+			PayloadType:     p[i] & 0x7f,
+			TimestampOffset: toUint(p[i+1:i+3]) >> 2,
+			Payload:         make([]byte, toUint(p[i+2:i+4])&0x3ff),
+		})
+		i += 4
+	}
+	primary = packet
+	primary.PayloadType = p[i] & 0x7f
+	i++
+	for j := range redundant {
+		block := &redundant[j]
+		if len(p) < i+len(block.Payload) {
+			return Packet{}, nil, ErrREDTruncated
+		}
+		block.Payload = p[i : i+len(block.Payload)]
+		i += len(block.Payload)
+	}
+	primary.Payload = p[i:]
+	return primary, redundant, nil
+}
+
+// fecKey identifies a media packet.
+type fecKey struct {
+	ssrc uint
+	seq  uint16
+}
+
+// fecPacket is the recovery data of a FEC packet, either ULPFEC or FlexFEC:
+// the XOR of the protected packets, their 12 byte header aside, and of a
+// few header fields.
+type fecPacket struct {
+	bits0     byte // P, X and CC
+	bits1     byte // M and PT
+	timestamp uint32
+	length    uint16 // of the packets without their 12 byte header
+	payload   []byte
+	protected []fecKey
+}
+
+// parseULPFEC reads a ULPFEC packet (RFC 5109), only the level 0 protection
+// is used.
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |E|L|P|X|  CC   |M| PT recovery |            SN base            |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          TS recovery                          |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |        length recovery        |       Protection Length       |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |             mask              |  mask cont. (present if L=1)  |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+func parseULPFEC(p []byte, ssrc uint) (*fecPacket, error) {
+	if len(p) < 14 {
+		return nil, ErrFECTruncated
+	}
+	if p[0]&0x80 != 0 {
+		// the E bit is reserved for extensions.
+		return nil, ErrFECUnsupported
+	}
+	f := &fecPacket{
+		bits0:     p[0],
+		bits1:     p[1],
+		timestamp: binary.BigEndian.Uint32(p[4:8]),
+		length:    binary.BigEndian.Uint16(p[8:10]),
+	}
+	base := binary.BigEndian.Uint16(p[2:4])
+	protection := int(binary.BigEndian.Uint16(p[10:12]))
+	maskBits := 16
+	if p[0]&0x40 != 0 {
+		maskBits = 48
+	}
+	header := 12 + maskBits/8
+	if len(p) < header+protection {
+		return nil, ErrFECTruncated
+	}
+	mask := p[12:header]
+	for i := 0; i < maskBits; i++ {
+		if mask[i/8]&(0x80>>uint(i%8)) != 0 {
+			f.protected = append(f.protected, fecKey{ssrc: ssrc, seq: base + uint16(i)})
+		}
+	}
+	// the packet goes back to the buffer pool while f is pending.
+	f.payload = append([]byte(nil), p[header:header+protection]...)
+	return f, nil
+}
+
+// parseFlexFEC reads a FlexFEC packet (RFC 8627), whose CSRC list holds the
+// protected SSRCs. Retransmissions (R=1) aren't supported.
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |R|F|P|X|  CC   |M| PT recovery |        length recovery        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          TS recovery                          |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |           SN base_i           |k|          Mask [0-14]        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |k|                   Mask [15-45] (optional)                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                     Mask [46-109] (optional)                  |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// With F=1 the mask is replaced by the number of columns L and rows D of a
+// fixed row (D <= 1) or column (D > 1) protection.
+func parseFlexFEC(p []byte, ssrcs []uint) (*fecPacket, error) {
+	if len(p) < 8 {
+		return nil, ErrFECTruncated
+	}
+	if p[0]&0x80 != 0 {
+		return nil, ErrFECUnsupported
+	}
+	if len(ssrcs) == 0 {
+		return nil, ErrFECNoProtectedSSRC
+	}
+	f := &fecPacket{
+		bits0:     p[0],
+		bits1:     p[1],
+		length:    binary.BigEndian.Uint16(p[2:4]),
+		timestamp: binary.BigEndian.Uint32(p[4:8]),
+	}
+	flexible := p[0]&0x40 == 0
+	i := 8
+	for _, ssrc := range ssrcs {
+		if len(p) < i+4 {
+			return nil, ErrFECTruncated
+		}
+		base := binary.BigEndian.Uint16(p[i : i+2])
+		protect := func(offset int) {
+			f.protected = append(f.protected, fecKey{ssrc: ssrc, seq: base + uint16(offset)})
+		}
+		if !flexible {
+			columns, rows := int(p[i+2]), int(p[i+3])
+			i += 4
+			if columns == 0 {
+				return nil, ErrFECUnsupported
+			}
+			if rows <= 1 {
+				for j := 0; j < columns; j++ {
+					protect(j)
+				}
+			} else {
+				for j := 0; j < rows; j++ {
+					protect(j * columns)
+				}
+			}
+			continue
+		}
+
+		// the mask goes on while the k bit of its parts is clear.
+		mask := uint64(binary.BigEndian.Uint16(p[i+2:i+4])&0x7fff) << 49
+		bits := 15
+		last := p[i+2]&0x80 != 0
+		i += 4
+		if !last {
+			if len(p) < i+4 {
+				return nil, ErrFECTruncated
+			}
+			mask |= uint64(binary.BigEndian.Uint32(p[i:i+4])&0x7fffffff) << 18
+			bits = 46
+			last = p[i]&0x80 != 0
+			i += 4
+		}
+		for j := 0; j < bits; j++ {
+			if mask&(1<<uint(63-j)) != 0 {
+				protect(j)
+			}
+		}
+		if !last {
+			if len(p) < i+8 {
+				return nil, ErrFECTruncated
+			}
+			more := binary.BigEndian.Uint64(p[i : i+8])
+			for j := 0; j < 64; j++ {
+				if more&(1<<uint(63-j)) != 0 {
+					protect(46 + j)
+				}
+			}
+			i += 8
+		}
+	}
+	f.payload = append([]byte(nil), p[i:]...)
+	return f, nil
+}
+
+// Defaults of a FEC decoder.
+const (
+	DefaultFECWindow = 256
+	maxPendingFEC    = 64
+)
+
+// FECConfig tells how the redundancy of a stream is sent, a zero payload type
+// is unused.
+type FECConfig struct {
+	REDPayloadType     byte
+	ULPFECPayloadType  byte
+	FlexFECPayloadType byte
+	// FlexFECSSRC, when set, tells FlexFEC packets apart by their ssrc, as
+	// given by the FEC-FR ssrc-group.
+	FlexFECSSRC uint
+	// Window is the number of media packets kept to rebuild others with.
+	Window int
+}
+
+// FECDecoder rebuilds the lost packets of a stream from its ULPFEC or
+// FlexFEC packets, RED packets are unwrapped on the way.
+type FECDecoder struct {
+	config FECConfig
+
+	media   map[fecKey][]byte // marshalled media packets
+	order   []fecKey          // of the media packets, oldest first
+	pending []*fecPacket      // fec packets missing more than a packet
+
+	ssrc      uint // of the media
+	recovered int
+}
+
+// NewFECDecoder creates a FEC decoder.
+func NewFECDecoder(config FECConfig) *FECDecoder {
+	if config.Window <= 0 {
+		config.Window = DefaultFECWindow
+	}
+	return &FECDecoder{config: config, media: make(map[fecKey][]byte)}
+}
+
+// IsRepair tells whether a packet returned by Push is a FEC packet, to be
+// dropped once it has gone through the jitter buffer.
+func (d *FECDecoder) IsRepair(packet Packet) bool {
+	pt := packet.PayloadType
+	switch {
+	case d.config.ULPFECPayloadType != 0 && pt == d.config.ULPFECPayloadType:
+		return true
+	case d.config.FlexFECPayloadType != 0 && pt == d.config.FlexFECPayloadType:
+		return true
+	case d.config.FlexFECSSRC != 0 && packet.SyncSource == d.config.FlexFECSSRC:
+		return true
+	}
+	return false
+}
+
+// Recovered returns the number of media packets rebuilt so far.
+func (d *FECDecoder) Recovered() int {
+	return d.recovered
+}
+
+// Push hands a packet of the stream to the decoder. It returns the packets
+// to go on with: the packet itself, or the primary block of a RED packet,
+// followed by the media packets it allowed to rebuild. FEC packets sharing
+// the sequence numbers of the media are returned as well, so a jitter
+// buffer doesn't take them for lost, and are told apart with IsRepair.
+func (d *FECDecoder) Push(packet Packet) []Packet {
+	if d.config.REDPayloadType != 0 && packet.PayloadType == d.config.REDPayloadType {
+		primary, _, err := ParseRED(packet)
+		if err != nil {
+			return nil
+		}
+		packet = primary
+	}
+
+	if !d.IsRepair(packet) {
+		d.ssrc = packet.SyncSource
+		d.store(packet)
+		return append([]Packet{packet}, d.recover(packet.StreamIdx)...)
+	}
+
+	var f *fecPacket
+	var err error
+	if d.config.ULPFECPayloadType != 0 && packet.PayloadType == d.config.ULPFECPayloadType {
+		f, err = parseULPFEC(packet.Payload, d.ssrc)
+	} else {
+		f, err = parseFlexFEC(packet.Payload, packet.CSRC)
+	}
+	if err == nil {
+		d.pending = append(d.pending, f)
+		if len(d.pending) > maxPendingFEC {
+			d.pending = d.pending[1:]
+		}
+	}
+	var out []Packet
+	if packet.SyncSource == d.ssrc {
+		out = append(out, packet)
+	}
+	return append(out, d.recover(packet.StreamIdx)...)
+}
+
+// store keeps a media packet to rebuild others with, dropping the oldest
+// beyond the window.
+func (d *FECDecoder) store(packet Packet) {
+	key := fecKey{ssrc: packet.SyncSource, seq: uint16(packet.SequenceNumber)}
+	if _, ok := d.media[key]; ok {
+		return
+	}
+	packet.Padding = packet.Padding && packet.PaddingSize > 0
+	buf, err := packet.Marshal()
+	if err != nil {
+		return
+	}
+	d.media[key] = buf
+	d.order = append(d.order, key)
+	if len(d.order) > d.config.Window {
+		delete(d.media, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// recover rebuilds the packets that are the only one missing from a pending
+// FEC packet, until there's none left. FEC packets with nothing left to
+// rebuild are dropped.
+func (d *FECDecoder) recover(streamIdx uint) (out []Packet) {
+	for progress := true; progress; {
+		progress = false
+		kept := d.pending[:0]
+		for _, f := range d.pending {
+			missing, count := fecKey{}, 0
+			for _, key := range f.protected {
+				if _, ok := d.media[key]; !ok {
+					missing = key
+					count++
+				}
+			}
+			switch {
+			case count == 0:
+			case count == 1:
+				if packet, ok := d.rebuild(f, missing, streamIdx); ok {
+					d.store(packet)
+					d.recovered++
+					out = append(out, packet)
+					progress = true
+				}
+			case d.stale(f):
+			default:
+				kept = append(kept, f)
+			}
+		}
+		for i := len(kept); i < len(d.pending); i++ {
+			d.pending[i] = nil
+		}
+		d.pending = kept
+	}
+	return
+}
+
+// stale tells whether a FEC packet protects packets gone from the window, it
+// can't be of use any more.
+func (d *FECDecoder) stale(f *fecPacket) bool {
+	if len(d.order) < d.config.Window {
+		return false
+	}
+	oldest := d.order[0]
+	for _, key := range f.protected {
+		if key.ssrc == oldest.ssrc && seqDiff(key.seq, oldest.seq) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuild XORs the recovery data of a FEC packet with the packets it protects
+// but the missing one.
+func (d *FECDecoder) rebuild(f *fecPacket, missing fecKey, streamIdx uint) (Packet, bool) {
+	bits0, bits1, timestamp, length := f.bits0, f.bits1, f.timestamp, f.length
+	payload := append([]byte(nil), f.payload...)
+	for _, key := range f.protected {
+		if key == missing {
+			continue
+		}
+		buf := d.media[key]
+		bits0 ^= buf[0]
+		bits1 ^= buf[1]
+		timestamp ^= binary.BigEndian.Uint32(buf[4:8])
+		length ^= uint16(len(buf) - 12)
+		for i, b := range buf[12:] {
+			if i >= len(payload) {
+				break
+			}
+			payload[i] ^= b
+		}
+	}
+	if int(length) > len(payload) {
+		// the protection didn't cover the whole packet.
+		return Packet{}, false
+	}
+
+	buf := make([]byte, 12+int(length))
+	buf[0] = RTPVERSION<<6 | bits0&0x3f
+	buf[1] = bits1
+	binary.BigEndian.PutUint16(buf[2:4], missing.seq)
+	binary.BigEndian.PutUint32(buf[4:8], timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(missing.ssrc))
+	copy(buf[12:], payload)
+	packet, err := ParsePacket(buf, streamIdx)
+	return packet, err == nil
+}
@@ -0,0 +1,191 @@
+package rtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseRED(t *testing.T) {
+	// a redundant block of 3 bytes sent 160 ticks earlier, then the primary.
+	red := Packet{PayloadType: 116, SequenceNumber: 7, Payload: []byte{
+		0x80 | 96, 160 >> 6, 160<<2&0xff | 0, 3,
+		97,
+		1, 2, 3,
+		4, 5,
+	}}
+	primary, redundant, err := ParseRED(red)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primary.PayloadType != 97 || primary.SequenceNumber != 7 || !bytes.Equal(primary.Payload, []byte{4, 5}) {
+		t.Errorf("primary %+v", primary)
+	}
+	if len(redundant) != 1 || redundant[0].PayloadType != 96 || redundant[0].TimestampOffset != 160 || !bytes.Equal(redundant[0].Payload, []byte{1, 2, 3}) {
+		t.Errorf("redundant %+v", redundant)
+	}
+
+	red.Payload = red.Payload[:7]
+	if _, _, err := ParseRED(red); err != ErrREDTruncated {
+		t.Errorf("truncated: %v", err)
+	}
+}
+
+func fecMedia(seqs ...uint) []Packet {
+	var packets []Packet
+	for _, seq := range seqs {
+		payload := bytes.Repeat([]byte{byte(seq)}, int(seq%7)+1)
+		packets = append(packets, Packet{Version: RTPVERSION, PayloadType: 96, Marker: seq%2 == 0,
+			SequenceNumber: seq, Timestamp: 90 * seq, SyncSource: 0xcafe, Payload: payload})
+	}
+	return packets
+}
+
+// xorRecovery computes the recovery fields of FEC over packets.
+func xorRecovery(packets []Packet) (bits0, bits1 byte, timestamp uint32, length uint16, payload []byte) {
+	for _, packet := range packets {
+		buf, _ := packet.Marshal()
+		bits0 ^= buf[0]
+		bits1 ^= buf[1]
+		timestamp ^= binary.BigEndian.Uint32(buf[4:8])
+		length ^= uint16(len(buf) - 12)
+		for len(payload) < len(buf)-12 {
+			payload = append(payload, 0)
+		}
+		for i, b := range buf[12:] {
+			payload[i] ^= b
+		}
+	}
+	return
+}
+
+func ulpfecPacket(seq uint, packets []Packet) Packet {
+	bits0, bits1, timestamp, length, payload := xorRecovery(packets)
+	base := uint16(packets[0].SequenceNumber)
+	var mask uint16
+	for _, packet := range packets {
+		mask |= 0x8000 >> (uint16(packet.SequenceNumber) - base)
+	}
+	p := []byte{bits0 & 0x3f, bits1}
+	p = binary.BigEndian.AppendUint16(p, base)
+	p = binary.BigEndian.AppendUint32(p, timestamp)
+	p = binary.BigEndian.AppendUint16(p, length)
+	p = binary.BigEndian.AppendUint16(p, uint16(len(payload)))
+	p = binary.BigEndian.AppendUint16(p, mask)
+	return Packet{Version: RTPVERSION, PayloadType: 117, SequenceNumber: seq, SyncSource: 0xcafe, Payload: append(p, payload...)}
+}
+
+func checkRecovered(t *testing.T, got []Packet, want Packet) {
+	t.Helper()
+	if len(got) != 1 {
+		t.Fatalf("recovered %d packets", len(got))
+	}
+	a, _ := got[0].Marshal()
+	b, _ := want.Marshal()
+	if !bytes.Equal(a, b) {
+		t.Errorf("recovered % x, want % x", a, b)
+	}
+}
+
+func TestFECDecoderULPFEC(t *testing.T) {
+	media := fecMedia(10, 11, 12, 13)
+	fec := ulpfecPacket(14, media)
+	d := NewFECDecoder(FECConfig{REDPayloadType: 116, ULPFECPayloadType: 117})
+
+	// media and fec both come wrapped in RED, 12 is lost.
+	wrap := func(packet Packet) Packet {
+		red := packet
+		red.PayloadType = 116
+		red.Payload = append([]byte{packet.PayloadType}, packet.Payload...)
+		return red
+	}
+	for _, i := range []int{0, 1, 3} {
+		if out := d.Push(wrap(media[i])); len(out) != 1 || out[0].PayloadType != 96 || d.IsRepair(out[0]) {
+			t.Fatalf("media %d: %+v", i, out)
+		}
+	}
+	out := d.Push(wrap(fec))
+	// the fec packet shares the sequence numbers of the media, it goes on
+	// to the jitter buffer.
+	if len(out) != 2 || !d.IsRepair(out[0]) {
+		t.Fatalf("out %+v", out)
+	}
+	checkRecovered(t, out[1:], media[2])
+	if d.Recovered() != 1 {
+		t.Errorf("recovered %d", d.Recovered())
+	}
+}
+
+func TestFECDecoderULPFECLate(t *testing.T) {
+	// two packets missing when the fec comes, one of them arrives late.
+	media := fecMedia(20, 21, 22)
+	d := NewFECDecoder(FECConfig{ULPFECPayloadType: 117})
+	d.Push(media[0])
+	repair := ulpfecPacket(23, media)
+	if out := d.Push(repair); len(out) != 1 {
+		t.Fatalf("out %+v", out)
+	}
+	// the buffer of the released fec packet is reused meanwhile.
+	for i := range repair.Payload {
+		repair.Payload[i] = 0xff
+	}
+	out := d.Push(media[2])
+	if len(out) != 2 {
+		t.Fatalf("out %+v", out)
+	}
+	checkRecovered(t, out[1:], media[1])
+}
+
+func flexfecPacket(seq uint, packets []Packet, fixed bool) Packet {
+	bits0, bits1, timestamp, length, payload := xorRecovery(packets)
+	base := uint16(packets[0].SequenceNumber)
+	p := []byte{bits0 & 0x3f, bits1}
+	p = binary.BigEndian.AppendUint16(p, length)
+	p = binary.BigEndian.AppendUint32(p, timestamp)
+	p = binary.BigEndian.AppendUint16(p, base)
+	if fixed {
+		// columns of 2 packets.
+		p[0] |= 0x40
+		p = append(p, 2, byte(len(packets)))
+	} else {
+		// a mask long enough for its second part.
+		var mask uint64
+		for _, packet := range packets {
+			mask |= 1 << (63 - uint64(uint16(packet.SequenceNumber)-base))
+		}
+		p = binary.BigEndian.AppendUint16(p, uint16(mask>>49))
+		p = binary.BigEndian.AppendUint32(p, 0x80000000|uint32(mask>>18)&0x7fffffff)
+	}
+	return Packet{Version: RTPVERSION, PayloadType: 110, SequenceNumber: seq, SyncSource: 0xfec,
+		CSRC: []uint{0xcafe}, Payload: append(p, payload...)}
+}
+
+func TestFECDecoderFlexFEC(t *testing.T) {
+	for _, fixed := range []bool{false, true} {
+		media := fecMedia(65530, 65532, 65534, 0)
+		if !fixed {
+			// protected across the second part of the mask.
+			media = fecMedia(65530, 65535, 14)
+		}
+		d := NewFECDecoder(FECConfig{FlexFECPayloadType: 110})
+		for _, packet := range media[:len(media)-2] {
+			d.Push(packet)
+		}
+		d.Push(media[len(media)-1])
+		out := d.Push(flexfecPacket(1, media, fixed))
+		// the fec packets have sequence numbers of their own, they stay out.
+		checkRecovered(t, out, media[len(media)-2])
+	}
+}
+
+func TestFECDecoderFlexFECSSRC(t *testing.T) {
+	media := fecMedia(1, 2)
+	fec := flexfecPacket(1, media, false)
+	fec.PayloadType = 96
+	d := NewFECDecoder(FECConfig{FlexFECSSRC: 0xfec})
+	d.Push(media[1])
+	if !d.IsRepair(fec) {
+		t.Fatal("fec packet taken for media")
+	}
+	checkRecovered(t, d.Push(fec), media[0])
+}
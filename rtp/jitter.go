@@ -0,0 +1,143 @@
+package rtp
+
+import (
+	"time"
+)
+
+// Defaults used by NewUDPSession for its jitter buffer.
+const (
+	DefaultJitterSize    = 128
+	DefaultJitterLatency = 200 * time.Millisecond
+)
+
+// maxMisorder is how far behind the expected sequence number a packet may be
+// before it is taken as a restart of the sender rather than a late packet.
+const maxMisorder = 100
+
+// Loss reports a run of packets given up on by a jitter buffer.
+type Loss struct {
+	StreamIdx uint
+	Sequence  uint16 // first missing sequence number
+	Count     int
+}
+
+type jitterEntry struct {
+	packet  Packet
+	arrival time.Time
+}
+
+// JitterBuffer puts the packets of one stream back in sequence number order.
+// A missing packet is waited for at most Latency, counted from the arrival
+// of the packet following it, or until Size packets are held, then the gap
+// is reported to OnLoss and skipped. OnMissing is told about a gap as soon
+// as a packet after it arrives, in time to ask for a retransmission.
+type JitterBuffer struct {
+	Size      int
+	Latency   time.Duration
+	OnLoss    func(Loss)
+	OnMissing func(Loss)
+
+	started bool
+	next    uint16
+	highest uint16        // highest sequence number pushed
+	entries []jitterEntry // sorted by sequence number, all after next.
+}
+
+// NewJitterBuffer creates a jitter buffer holding up to size packets.
+func NewJitterBuffer(size int, latency time.Duration) *JitterBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &JitterBuffer{
+		Size:    size,
+		Latency: latency,
+		entries: make([]jitterEntry, 0, size),
+	}
+}
+
+// seqDiff is the distance from b to a in a 16-bit sequence space.
+func seqDiff(a, b uint16) int {
+	return int(int16(a - b))
+}
+
+// Push adds a packet arrived at now. Duplicates and packets older than the
+// ones already released are dropped, in which case false is returned.
+func (j *JitterBuffer) Push(packet Packet, now time.Time) bool {
+	seq := uint16(packet.SequenceNumber)
+	if !j.started {
+		j.started = true
+		j.next = seq
+		j.highest = seq - 1
+	}
+
+	diff := seqDiff(seq, j.next)
+	if diff < -maxMisorder {
+		// the sender restarted with new sequence numbers.
+		j.entries = j.entries[:0]
+		j.next = seq
+		j.highest = seq - 1
+		diff = 0
+	}
+	if diff < 0 {
+		return false
+	}
+	if ahead := seqDiff(seq, j.highest); ahead > 0 {
+		if ahead > 1 && j.OnMissing != nil {
+			j.OnMissing(Loss{StreamIdx: packet.StreamIdx, Sequence: j.highest + 1, Count: ahead - 1})
+		}
+		j.highest = seq
+	}
+
+	i := len(j.entries)
+	for i > 0 && seqDiff(uint16(j.entries[i-1].packet.SequenceNumber), seq) > 0 {
+		i--
+	}
+	if i > 0 && uint16(j.entries[i-1].packet.SequenceNumber) == seq {
+		return false
+	}
+	j.entries = append(j.entries, jitterEntry{})
+	copy(j.entries[i+1:], j.entries[i:])
+	j.entries[i] = jitterEntry{packet: packet, arrival: now}
+	return true
+}
+
+// Pop returns the next packet in order, if it is there or the packets missing
+// before it are given up on.
+func (j *JitterBuffer) Pop(now time.Time) (packet Packet, ok bool) {
+	if len(j.entries) == 0 {
+		return
+	}
+	head := j.entries[0]
+	seq := uint16(head.packet.SequenceNumber)
+	if seq != j.next {
+		if len(j.entries) < j.Size && now.Sub(head.arrival) < j.Latency {
+			return
+		}
+		if j.OnLoss != nil {
+			j.OnLoss(Loss{StreamIdx: head.packet.StreamIdx, Sequence: j.next, Count: seqDiff(seq, j.next)})
+		}
+	}
+
+	copy(j.entries, j.entries[1:])
+	j.entries = j.entries[:len(j.entries)-1]
+	j.next = seq + 1
+	return head.packet, true
+}
+
+// Deadline tells when Pop will next have a packet to release without more
+// packets being pushed, ok is false if the buffer is empty.
+func (j *JitterBuffer) Deadline() (deadline time.Time, ok bool) {
+	if len(j.entries) == 0 {
+		return
+	}
+	head := j.entries[0]
+	if uint16(head.packet.SequenceNumber) == j.next {
+		return head.arrival, true
+	}
+	return head.arrival.Add(j.Latency), true
+}
+
+// Len returns the number of packets held.
+func (j *JitterBuffer) Len() int {
+	return len(j.entries)
+}
@@ -0,0 +1,128 @@
+package rtp
+
+import (
+	"testing"
+	"time"
+)
+
+func popAll(j *JitterBuffer, now time.Time) (seqs []uint) {
+	for {
+		packet, ok := j.Pop(now)
+		if !ok {
+			return
+		}
+		seqs = append(seqs, packet.SequenceNumber)
+	}
+}
+
+func equalSeqs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJitterBufferReorder(t *testing.T) {
+	var losses []Loss
+	j := NewJitterBuffer(16, 100*time.Millisecond)
+	j.OnLoss = func(loss Loss) { losses = append(losses, loss) }
+	now := time.Unix(0, 0)
+
+	var out []uint
+	// sequence numbers wrap around in the middle.
+	for _, seq := range []uint{65534, 0, 65535, 2, 1, 1, 3} {
+		j.Push(Packet{SequenceNumber: seq}, now)
+		out = append(out, popAll(j, now)...)
+	}
+	if exp := []uint{65534, 65535, 0, 1, 2, 3}; !equalSeqs(out, exp) {
+		t.Errorf("released %v, expected %v", out, exp)
+	}
+
+	// late packets are dropped.
+	j.Push(Packet{SequenceNumber: 2}, now)
+	if j.Len() != 0 || len(losses) != 0 {
+		t.Errorf("late packet kept, %d held, losses %v", j.Len(), losses)
+	}
+}
+
+func TestJitterBufferLoss(t *testing.T) {
+	var losses []Loss
+	j := NewJitterBuffer(16, 100*time.Millisecond)
+	j.OnLoss = func(loss Loss) { losses = append(losses, loss) }
+	now := time.Unix(0, 0)
+
+	j.Push(Packet{SequenceNumber: 10, StreamIdx: 1}, now)
+	j.Push(Packet{SequenceNumber: 13, StreamIdx: 1}, now)
+	if out := popAll(j, now); !equalSeqs(out, []uint{10}) {
+		t.Fatalf("released %v before the gap", out)
+	}
+	if deadline, ok := j.Deadline(); !ok || !deadline.Equal(now.Add(100*time.Millisecond)) {
+		t.Fatalf("deadline %v %v", deadline, ok)
+	}
+	if out := popAll(j, now.Add(99*time.Millisecond)); len(out) != 0 {
+		t.Fatalf("gap skipped early, released %v", out)
+	}
+	if out := popAll(j, now.Add(100*time.Millisecond)); !equalSeqs(out, []uint{13}) {
+		t.Fatalf("released %v after the gap", out)
+	}
+	if len(losses) != 1 || losses[0] != (Loss{StreamIdx: 1, Sequence: 11, Count: 2}) {
+		t.Errorf("losses %v", losses)
+	}
+}
+
+func TestJitterBufferFull(t *testing.T) {
+	var losses []Loss
+	j := NewJitterBuffer(4, time.Hour)
+	j.OnLoss = func(loss Loss) { losses = append(losses, loss) }
+	now := time.Unix(0, 0)
+
+	j.Push(Packet{SequenceNumber: 0}, now)
+	popAll(j, now)
+	var out []uint
+	for seq := uint(2); seq < 6; seq++ {
+		j.Push(Packet{SequenceNumber: seq}, now)
+		out = append(out, popAll(j, now)...)
+	}
+	if exp := []uint{2, 3, 4, 5}; !equalSeqs(out, exp) {
+		t.Errorf("released %v, expected %v", out, exp)
+	}
+	if len(losses) != 1 || losses[0].Sequence != 1 || losses[0].Count != 1 {
+		t.Errorf("losses %v", losses)
+	}
+}
+
+func TestJitterBufferRestart(t *testing.T) {
+	j := NewJitterBuffer(16, time.Second)
+	now := time.Unix(0, 0)
+	j.Push(Packet{SequenceNumber: 5000}, now)
+	popAll(j, now)
+	j.Push(Packet{SequenceNumber: 10}, now)
+	if out := popAll(j, now); !equalSeqs(out, []uint{10}) {
+		t.Errorf("restarted stream released %v", out)
+	}
+}
+
+func TestJitterBufferMissing(t *testing.T) {
+	var missing []Loss
+	j := NewJitterBuffer(16, 100*time.Millisecond)
+	j.OnMissing = func(loss Loss) { missing = append(missing, loss) }
+	now := time.Unix(0, 0)
+
+	// the gaps are reported once, when they show up, even if late packets
+	// fill them in later.
+	for _, seq := range []uint{65534, 1, 0, 4, 65535, 3, 2, 5} {
+		j.Push(Packet{SequenceNumber: seq}, now)
+	}
+	want := []Loss{{Sequence: 65535, Count: 2}, {Sequence: 2, Count: 2}}
+	if len(missing) != len(want) || missing[0] != want[0] || missing[1] != want[1] {
+		t.Errorf("missing %+v", missing)
+	}
+	if out := popAll(j, now); !equalSeqs(out, []uint{65534, 65535, 0, 1, 2, 3, 4, 5}) {
+		t.Errorf("out %v", out)
+	}
+}
@@ -1,6 +1,10 @@
 package rtp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+)
 
 // some consts
 const (
@@ -41,7 +45,13 @@ type Packet struct {
 
 	Payload []byte
 
+	// PaddingSize counts the padding bytes following the payload, the last
+	// one included, when Padding is set.
+	PaddingSize byte
+
 	StreamIdx uint // this is not an additional field added by us to tell which stream this packet is for.
+
+	buf []byte // the pooled buffer the packet was read into, if any.
 }
 
 // Strings prints a rtp packet content
@@ -52,13 +62,23 @@ func (r Packet) String() string {
 		r.SequenceNumber, r.Timestamp, r.SyncSource)
 }
 
-// ParsePacket parses data frame into RTP packet
-func ParsePacket(buf []byte, streamIdx uint) Packet {
+// Errors returned by ParsePacket
+var (
+	ErrPacketTooShort     = errors.New("rtp: packet too short")
+	ErrUnsupportedVersion = errors.New("rtp: unsupported version")
+	ErrInvalidPadding     = errors.New("rtp: invalid padding")
+)
+
+// ParsePacket parses data frame into RTP packet. The payload refers to buf,
+// with the padding, if any, stripped off.
+func ParsePacket(buf []byte, streamIdx uint) (Packet, error) {
+	if len(buf) < 12 {
+		return Packet{}, ErrPacketTooShort
+	}
 	packet := Packet{
 		Version:        buf[0] >> 6,
 		Padding:        buf[0]>>5&1 != 0,
 		Ext:            buf[0]>>4&1 != 0,
-		CSRC:           make([]uint, int(buf[0]&0x0f)),
 		Marker:         buf[1]>>7 != 0,
 		PayloadType:    buf[1] & 0x7f,
 		SequenceNumber: toUint(buf[2:4]),
@@ -67,11 +87,16 @@ func ParsePacket(buf []byte, streamIdx uint) Packet {
 		StreamIdx:      streamIdx,
 	}
 	if packet.Version != RTPVERSION {
-		panic("Unsupported version")
+		return Packet{}, ErrUnsupportedVersion
 	}
 
 	// Next section is the CSRC identifiers, each identifier has 4 bytes
 	i := 12
+	csrcCount := int(buf[0] & 0x0f)
+	if len(buf) < i+csrcCount*4 {
+		return Packet{}, ErrPacketTooShort
+	}
+	packet.CSRC = make([]uint, csrcCount)
 	for j := range packet.CSRC {
 		packet.CSRC[j] = toUint(buf[i : i+4])
 		i += 4
@@ -79,16 +104,119 @@ func ParsePacket(buf []byte, streamIdx uint) Packet {
 
 	// If we have extra header, the following section is it.
 	if packet.Ext {
+		if len(buf) < i+4 {
+			return Packet{}, ErrPacketTooShort
+		}
 		packet.ExtHeader = toUint(buf[i : i+2])
-		length := toUint(buf[i+2 : i+4])
+		length := int(toUint(buf[i+2 : i+4]))
 		i += 4
 		// if length is > 0, we have ext data, each section is 4 bytes.
+		if len(buf) < i+length*4 {
+			return Packet{}, ErrPacketTooShort
+		}
 		if length > 0 {
-			packet.ExtData = buf[i : i+int(length)*4]
-			i += int(length) * 4
+			packet.ExtData = buf[i : i+length*4]
+			i += length * 4
 		}
 	}
+
+	// The last byte of the padding counts the padding bytes, itself included.
+	end := len(buf)
+	if packet.Padding {
+		if end == i {
+			return Packet{}, ErrInvalidPadding
+		}
+		padding := int(buf[end-1])
+		if padding == 0 || padding > end-i {
+			return Packet{}, ErrInvalidPadding
+		}
+		end -= padding
+		packet.PaddingSize = byte(padding)
+	}
+
 	// The rest of the packet is the pay load.
-	packet.Payload = buf[i:]
-	return packet
+	packet.Payload = buf[i:end]
+	return packet, nil
+}
+
+// Errors returned by MarshalTo
+var (
+	ErrTooManyCSRC      = errors.New("rtp: more than 15 csrc")
+	ErrInvalidExtension = errors.New("rtp: extension data not a multiple of 4 bytes")
+)
+
+// MarshalSize returns the size of the packet once marshalled.
+func (r Packet) MarshalSize() int {
+	size := 12 + 4*len(r.CSRC) + len(r.Payload)
+	if r.Ext {
+		size += 4 + len(r.ExtData)
+	}
+	if r.Padding {
+		size += int(r.PaddingSize)
+	}
+	return size
+}
+
+// Marshal serializes the packet into a new buffer.
+func (r Packet) Marshal() ([]byte, error) {
+	buf := make([]byte, r.MarshalSize())
+	n, err := r.MarshalTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// MarshalTo serializes the packet into buf without allocating and returns the
+// number of bytes written, buf must hold at least MarshalSize bytes.
+func (r Packet) MarshalTo(buf []byte) (n int, err error) {
+	if len(r.CSRC) > 15 {
+		return 0, ErrTooManyCSRC
+	}
+	if r.Ext && len(r.ExtData)%4 != 0 {
+		return 0, ErrInvalidExtension
+	}
+	if r.Padding && r.PaddingSize == 0 {
+		return 0, ErrInvalidPadding
+	}
+	size := r.MarshalSize()
+	if len(buf) < size {
+		return 0, io.ErrShortBuffer
+	}
+
+	buf[0] = RTPVERSION<<6 | byte(len(r.CSRC))
+	if r.Padding {
+		buf[0] |= 1 << 5
+	}
+	if r.Ext {
+		buf[0] |= 1 << 4
+	}
+	buf[1] = r.PayloadType & 0x7f
+	if r.Marker {
+		buf[1] |= 0x80
+	}
+	putUint(buf[2:4], r.SequenceNumber)
+	putUint(buf[4:8], r.Timestamp)
+	putUint(buf[8:12], r.SyncSource)
+
+	n = 12
+	for _, csrc := range r.CSRC {
+		putUint(buf[n:n+4], csrc)
+		n += 4
+	}
+	if r.Ext {
+		putUint(buf[n:n+2], r.ExtHeader)
+		putUint(buf[n+2:n+4], uint(len(r.ExtData)/4))
+		n += 4
+		n += copy(buf[n:], r.ExtData)
+	}
+	n += copy(buf[n:], r.Payload)
+	if r.Padding {
+		for i := 0; i < int(r.PaddingSize)-1; i++ {
+			buf[n+i] = 0
+		}
+		n += int(r.PaddingSize)
+		buf[n-1] = r.PaddingSize
+	}
+	return n, nil
 }
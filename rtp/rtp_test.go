@@ -1,6 +1,8 @@
 package rtp
 
 import (
+	"bytes"
+	"io"
 	"testing"
 )
 
@@ -19,3 +21,122 @@ func TestToUint(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePacket(t *testing.T) {
+	header := []byte{0x80, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}
+	tests := []struct {
+		name    string
+		buf     []byte
+		err     error
+		payload []byte
+		csrc    int
+		extData int
+	}{
+		{"plain", append(header[:12:12], 1, 2, 3), nil, []byte{1, 2, 3}, 0, 0},
+		{"padding", append([]byte{0xa0, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 1, 2, 3, 0, 0, 3), nil, []byte{1, 2, 3}, 0, 0},
+		{"padding only", append([]byte{0xa0, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 0, 0, 3), nil, []byte{}, 0, 0},
+		{"padding too long", append([]byte{0xa0, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 1, 5), ErrInvalidPadding, nil, 0, 0},
+		{"zero padding", append([]byte{0xa0, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 1, 0), ErrInvalidPadding, nil, 0, 0},
+		{"csrc", append([]byte{0x82, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 0, 0, 0, 1, 0, 0, 0, 2, 9), nil, []byte{9}, 2, 0},
+		{"csrc truncated", append([]byte{0x82, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 0, 0, 0, 1), ErrPacketTooShort, nil, 0, 0},
+		{"extension", append([]byte{0x90, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 0xbe, 0xde, 0, 1, 1, 2, 3, 4, 9), nil, []byte{9}, 0, 4},
+		{"extension truncated", append([]byte{0x90, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef}, 0xbe, 0xde, 0, 2, 1, 2, 3, 4), ErrPacketTooShort, nil, 0, 0},
+		{"short header", header[:11], ErrPacketTooShort, nil, 0, 0},
+		{"version", append([]byte{0x40}, header[1:]...), ErrUnsupportedVersion, nil, 0, 0},
+	}
+	for _, tst := range tests {
+		packet, err := ParsePacket(tst.buf, 1)
+		if err != tst.err {
+			t.Errorf("%s: error %v, expected %v", tst.name, err, tst.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(packet.Payload, tst.payload) || len(packet.CSRC) != tst.csrc || len(packet.ExtData) != tst.extData {
+			t.Errorf("%s: payload % x csrc %d ext %d", tst.name, packet.Payload, len(packet.CSRC), len(packet.ExtData))
+		}
+		if packet.SequenceNumber != 0x1234 || packet.Timestamp != 1000 || packet.SyncSource != 0xdeadbeef || !packet.Marker || packet.PayloadType != 96 || packet.StreamIdx != 1 {
+			t.Errorf("%s: bad header %v", tst.name, packet)
+		}
+	}
+}
+
+func FuzzParsePacket(f *testing.F) {
+	f.Add([]byte{0x80, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3})
+	f.Add([]byte{0xb2, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 1, 0, 0, 0, 2, 0xbe, 0xde, 0, 1, 1, 2, 3, 4, 9, 0, 2})
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		packet, err := ParsePacket(buf, 0)
+		if err != nil {
+			return
+		}
+		if len(buf) < 12+4*len(packet.CSRC)+len(packet.ExtData)+len(packet.Payload) {
+			t.Fatalf("packet fields exceed the %d bytes parsed", len(buf))
+		}
+		out, err := packet.Marshal()
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		again, err := ParsePacket(out, 0)
+		if err != nil || !bytes.Equal(again.Payload, packet.Payload) || again.SequenceNumber != packet.SequenceNumber {
+			t.Fatalf("round trip of % x gave % x: %v", buf, out, err)
+		}
+	})
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	packets := [][]byte{
+		{0x80, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3},
+		{0xa0, 0x60, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 0, 0, 3},
+		{0x82, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 1, 0, 0, 0, 2, 9},
+		{0x90, 0xe0, 0x12, 0x34, 0, 0, 0x03, 0xe8, 0xde, 0xad, 0xbe, 0xef, 0xbe, 0xde, 0, 1, 0x10, 0xaa, 0, 0, 9},
+		{0xb1, 0x60, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1, 0, 0, 0, 7, 0x10, 0x00, 0, 0, 0, 1},
+	}
+	for _, buf := range packets {
+		packet, err := ParsePacket(buf, 0)
+		if err != nil {
+			t.Fatalf("% x: %v", buf, err)
+		}
+		if packet.MarshalSize() != len(buf) {
+			t.Errorf("% x: marshal size %d", buf, packet.MarshalSize())
+		}
+		out, err := packet.Marshal()
+		if err != nil {
+			t.Fatalf("% x: %v", buf, err)
+		}
+		if !bytes.Equal(out, buf) {
+			t.Errorf("round trip\n% x\n% x", buf, out)
+		}
+	}
+}
+
+func TestMarshalTo(t *testing.T) {
+	packet := Packet{PayloadType: 96, SequenceNumber: 1, Timestamp: 2, SyncSource: 3, Payload: make([]byte, 1200)}
+	buf := make([]byte, 1500)
+	if _, err := packet.MarshalTo(buf[:100]); err != io.ErrShortBuffer {
+		t.Errorf("short buffer: %v", err)
+	}
+	if _, err := (Packet{Padding: true}).MarshalTo(buf); err != ErrInvalidPadding {
+		t.Errorf("padding without size: %v", err)
+	}
+	if _, err := (Packet{Ext: true, ExtData: []byte{1}}).MarshalTo(buf); err != ErrInvalidExtension {
+		t.Errorf("odd extension: %v", err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		packet.SequenceNumber++
+		packet.MarshalTo(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("MarshalTo allocates %v times", allocs)
+	}
+}
+
+func BenchmarkMarshalTo(b *testing.B) {
+	packet := Packet{PayloadType: 96, SyncSource: 3, CSRC: []uint{1, 2}, Payload: make([]byte, 1200)}
+	buf := make([]byte, 1500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet.SequenceNumber = uint(i)
+		packet.MarshalTo(buf)
+	}
+}
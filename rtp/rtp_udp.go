@@ -1,81 +1,508 @@
 package rtp
 
 import (
+	"errors"
+	"math/rand"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/solomondong/rtsp/rtcp"
 )
 
-// UDPSession defines a udp session
-type UDPSession struct {
-	Rtp  net.Conn
-	Rtcp net.Conn
+// pooledBufferSize covers the packets sent within a common ethernet mtu,
+// larger ones get a buffer of their own.
+const pooledBufferSize = 2048
+
+// maxDatagramSize is the largest udp payload.
+const maxDatagramSize = 65536
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, pooledBufferSize)
+		return &b
+	},
+}
+
+// Errors returned by UDP sessions
+var (
+	ErrSessionClosed = errors.New("rtp: udp session closed")
+	ErrNoPortPair    = errors.New("rtp: no udp port pair available")
+)
+
+// UDPConfig configures the reception of a UDP session.
+type UDPConfig struct {
+	// ReadBufferSize sets the socket receive buffer (SO_RCVBUF), 0 keeps
+	// the system default.
+	ReadBufferSize int
+	// JitterSize and JitterLatency configure the jitter buffer of each stream.
+	JitterSize    int
+	JitterLatency time.Duration
+	// ChanSize is the capacity of the packet channels.
+	ChanSize int
+	// KeepAlive, when set, is the interval rtcp is sent to the sources of
+	// the streams at, to keep NAT mappings open.
+	KeepAlive time.Duration
+}
 
+// DefaultUDPConfig is the configuration used by NewUDPSession.
+var DefaultUDPConfig = UDPConfig{
+	ReadBufferSize: 1 << 20,
+	JitterSize:     DefaultJitterSize,
+	JitterLatency:  DefaultJitterLatency,
+	ChanSize:       64,
+}
+
+// UDPSession receives the rtp and rtcp packets of streams over udp. Each
+// socket has a single reader, packets are handed over in order and readers
+// block while the channels are full, so the socket buffer absorbs bursts.
+// Both RtpChan and RtcpChan have to be read, and are closed by Close.
+type UDPSession struct {
 	RtpChan  <-chan Packet
-	RtcpChan <-chan rtcp.Packet
+	RtcpChan <-chan rtcp.Compound
+	// LossChan reports the packets the jitter buffers gave up waiting for,
+	// events are dropped when nobody reads them.
+	LossChan <-chan Loss
 
 	rtpChan  chan<- Packet
-	rtcpChan chan<- rtcp.Packet
+	rtcpChan chan<- rtcp.Compound
+	lossChan chan<- Loss
+
+	config UDPConfig
+
+	// SSRC identifies the receiver in the rtcp it sends.
+	SSRC uint32
+
+	mu      sync.Mutex
+	streams []*UDPStream
+	closed  bool
+	closing chan struct{}
+	wg      sync.WaitGroup
+	err     error
 }
 
-// NewUDPSession creates a new UDP session
+// UDPStream is a stream received by a UDP session.
+type UDPStream struct {
+	Idx  uint
+	Rtp  net.PacketConn
+	Rtcp net.PacketConn
+	// RtpSource and RtcpSource, when set, are the only addresses packets
+	// are accepted from, usually the server ports negotiated by SETUP. A
+	// zero port accepts any port of the address.
+	RtpSource  *net.UDPAddr
+	RtcpSource *net.UDPAddr
+	// SRTP, when set, decrypts the packets of a secure stream (RTP/SAVP),
+	// those failing authentication are dropped.
+	SRTP *SRTPContext
+	// RTX maps the payload types of a retransmission stream (RFC 4588) to
+	// the ones they retransmit, as given by the apt fmtp parameter.
+	RTX map[byte]byte
+	// NACK, when set, asks the source with RTCP Generic NACKs for the
+	// packets missing as soon as a gap shows up.
+	NACK bool
+	// FEC, when set, unwraps RED packets and rebuilds lost packets from
+	// the FEC packets of the stream.
+	FEC *FECDecoder
+
+	jitter *JitterBuffer
+	ssrc   uint // of the original packets, learnt from the stream
+}
+
+// NewUDPSession creates a new UDP session receiving a single stream over a
+// pair of connections, with the default configuration.
 func NewUDPSession(rtpConn, rtcpConn net.Conn) *UDPSession {
-	rtpChan := make(chan Packet, 10)
-	rtcpChan := make(chan rtcp.Packet, 10)
+	s := NewUDPSessionConfig(DefaultUDPConfig)
+	s.AddStream(&UDPStream{Rtp: packetConn(rtpConn), Rtcp: packetConn(rtcpConn)})
+	return s
+}
+
+// NewUDPSessionConfig creates a new UDP session without streams.
+func NewUDPSessionConfig(config UDPConfig) *UDPSession {
+	rtpChan := make(chan Packet, config.ChanSize)
+	rtcpChan := make(chan rtcp.Compound, config.ChanSize)
+	lossChan := make(chan Loss, config.ChanSize)
 	s := &UDPSession{
-		Rtp:      rtpConn,
-		Rtcp:     rtcpConn,
 		RtpChan:  rtpChan,
 		RtcpChan: rtcpChan,
+		LossChan: lossChan,
 		rtpChan:  rtpChan,
 		rtcpChan: rtcpChan,
+		lossChan: lossChan,
+		SSRC:     rand.Uint32(),
+		config:   config,
+		closing:  make(chan struct{}),
+	}
+	if config.KeepAlive > 0 {
+		s.wg.Add(1)
+		go s.keepAlive(config.KeepAlive)
 	}
-	go s.HandleRtpConn(rtpConn)
-	go s.HandleRtcpConn(rtcpConn)
 	return s
 }
 
-func toUint(arr []byte) (ret uint) {
-	for i, b := range arr {
-		ret |= uint(b) << (8 * uint(len(arr)-i-1))
+// connPacketConn reads a connected net.Conn as a net.PacketConn, the remote
+// address is filtered by the connection itself.
+type connPacketConn struct {
+	net.Conn
+}
+
+func (c connPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, nil, err
+}
+
+func (c connPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func packetConn(conn net.Conn) net.PacketConn {
+	if pc, ok := conn.(net.PacketConn); ok {
+		return pc
 	}
-	return ret
+	return connPacketConn{conn}
+}
+
+// AddStream starts receiving a stream, its packets are tagged with its Idx.
+func (s *UDPSession) AddStream(stream *UDPStream) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	stream.jitter = NewJitterBuffer(s.config.JitterSize, s.config.JitterLatency)
+	stream.jitter.OnLoss = func(loss Loss) {
+		select {
+		case s.lossChan <- loss:
+		default:
+		}
+	}
+	if stream.NACK {
+		stream.jitter.OnMissing = func(loss Loss) {
+			s.sendNack(stream, loss)
+		}
+	}
+	for _, conn := range []net.PacketConn{stream.Rtp, stream.Rtcp} {
+		if b, ok := conn.(interface{ SetReadBuffer(int) error }); ok && s.config.ReadBufferSize > 0 {
+			b.SetReadBuffer(s.config.ReadBufferSize)
+		}
+	}
+
+	s.streams = append(s.streams, stream)
+	s.wg.Add(2)
+	go s.readRtp(stream)
+	go s.readRtcp(stream)
+	return nil
+}
+
+// Streams returns the streams received by the session.
+func (s *UDPSession) Streams() []*UDPStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*UDPStream(nil), s.streams...)
+}
+
+// Close stops the reception, closes the connections of the streams and then
+// the channels.
+func (s *UDPSession) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.closing)
+	for _, stream := range s.streams {
+		stream.Rtp.Close()
+		stream.Rtcp.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	close(s.rtpChan)
+	close(s.rtcpChan)
+	close(s.lossChan)
+	return nil
+}
+
+// Err returns the error that stopped a reader, if any.
+func (s *UDPSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
 }
 
-// HandleRtpConn handles rtp connection incomming data.
-func (s *UDPSession) HandleRtpConn(conn net.Conn) {
-	buf := make([]byte, 4096)
+// readError tells whether a reader has to stop on a read error, timeouts
+// are used to flush the jitter buffer.
+func (s *UDPSession) readError(err error) (stop bool) {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed && s.err == nil {
+		s.err = err
+	}
+	return true
+}
+
+func sourceMatches(source *net.UDPAddr, addr net.Addr) bool {
+	if source == nil || addr == nil {
+		return true
+	}
+	from, ok := addr.(*net.UDPAddr)
+	return ok && (source.Port == 0 || from.Port == source.Port) && from.IP.Equal(source.IP)
+}
+
+// ListenUDPPair opens an even rtp port and the following rtcp port.
+func ListenUDPPair() (rtpConn, rtcpConn *net.UDPConn, err error) {
+	for i := 0; i < 100; i++ {
+		if rtpConn, err = net.ListenUDP("udp", &net.UDPAddr{}); err != nil {
+			return
+		}
+		port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+		if port%2 == 0 {
+			if rtcpConn, err = net.ListenUDP("udp", &net.UDPAddr{Port: port + 1}); err == nil {
+				return
+			}
+		}
+		rtpConn.Close()
+	}
+	return nil, nil, ErrNoPortPair
+}
+
+// readBuffer copies a datagram into a buffer from the pool when it fits.
+func readBuffer(b []byte) []byte {
+	if len(b) > pooledBufferSize {
+		return append([]byte(nil), b...)
+	}
+	buf := *bufferPool.Get().(*[]byte)
+	return buf[:copy(buf[:cap(buf)], b)]
+}
+
+// Release hands the buffer of a packet received by a session back for reuse,
+// the packet must not be used after. Releasing is optional, packets kept
+// around, such as by a depacketizer holding on to the payload, are simply
+// garbage collected.
+func Release(packet Packet) {
+	buf := packet.buf
+	if cap(buf) == pooledBufferSize {
+		buf = buf[:pooledBufferSize]
+		bufferPool.Put(&buf)
+	}
+}
+
+func (s *UDPSession) readRtp(stream *UDPStream) {
+	defer s.wg.Done()
+	scratch := make([]byte, maxDatagramSize)
 	for {
-		n, err := conn.Read(buf)
+		deadline, _ := stream.jitter.Deadline()
+		stream.Rtp.SetReadDeadline(deadline)
+		n, addr, err := stream.Rtp.ReadFrom(scratch)
 		if err != nil {
-			panic(err)
+			if s.readError(err) {
+				return
+			}
+		} else if sourceMatches(stream.RtpSource, addr) {
+			buf := readBuffer(scratch[:n])
+			var packet Packet
+			if stream.SRTP != nil {
+				// decrypted in place, the buffer can still go back to the pool.
+				var plain []byte
+				if plain, err = stream.SRTP.DecryptRTP(buf, buf); err == nil {
+					buf = plain
+				}
+			}
+			if err == nil {
+				packet, err = ParsePacket(buf, stream.Idx)
+			}
+			if err == nil {
+				packet, err = stream.unwrap(packet)
+			}
+			if err != nil {
+				Release(Packet{buf: buf})
+			} else {
+				packet.buf = buf
+				packets := []Packet{packet}
+				if stream.FEC != nil {
+					packets = stream.FEC.Push(packet)
+				}
+				for _, packet := range packets {
+					if !stream.jitter.Push(packet, time.Now()) {
+						Release(packet)
+					}
+				}
+			}
+		}
+
+		now := time.Now()
+		for {
+			packet, ok := stream.jitter.Pop(now)
+			if !ok {
+				break
+			}
+			if stream.FEC != nil && stream.FEC.IsRepair(packet) {
+				Release(packet)
+				continue
+			}
+			select {
+			case s.rtpChan <- packet:
+			case <-s.closing:
+				return
+			}
 		}
+	}
+}
 
-		cpy := make([]byte, n)
-		copy(cpy, buf)
-		go s.handleRtp(cpy)
+// unwrap restores the original of a retransmitted packet, the media packets
+// tell the ssrc of the stream.
+func (stream *UDPStream) unwrap(packet Packet) (Packet, error) {
+	if apt, ok := stream.RTX[packet.PayloadType]; ok {
+		return packet.UnwrapRTX(apt, stream.ssrc)
 	}
+	if stream.FEC == nil || !stream.FEC.IsRepair(packet) {
+		stream.ssrc = packet.SyncSource
+	}
+	return packet, nil
 }
 
-// HandleRtcpConn handles rtcp connection incomming data.
-func (s *UDPSession) HandleRtcpConn(conn net.Conn) {
-	buf := make([]byte, 4096)
+func (s *UDPSession) readRtcp(stream *UDPStream) {
+	defer s.wg.Done()
+	buf := make([]byte, maxDatagramSize)
 	for {
-		n, err := conn.Read(buf)
+		n, addr, err := stream.Rtcp.ReadFrom(buf)
+		if err != nil {
+			if s.readError(err) {
+				return
+			}
+			continue
+		}
+		if !sourceMatches(stream.RtcpSource, addr) {
+			continue
+		}
+		data := append([]byte(nil), buf[:n]...)
+		if stream.SRTP != nil {
+			if data, err = stream.SRTP.DecryptRTCP(data, data); err != nil {
+				continue
+			}
+		}
+		messages, err := rtcp.ParseCompound(data)
 		if err != nil {
-			panic(err)
+			continue
+		}
+		select {
+		case s.rtcpChan <- rtcp.Compound{StreamIdx: stream.Idx, Messages: messages}:
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// Punch sends a dummy rtp packet and an empty rtcp receiver report from the
+// ports of a stream to its sources, so NATs and firewalls on the way let the
+// packets of the sources in. Sources without a port are skipped.
+func (s *UDPSession) Punch(stream *UDPStream) error {
+	if stream.RtpSource != nil && stream.RtpSource.Port != 0 {
+		buf, _ := Packet{Version: RTPVERSION, SyncSource: uint(s.SSRC)}.Marshal()
+		if _, err := stream.Rtp.WriteTo(buf, stream.RtpSource); err != nil {
+			return err
+		}
+	}
+	return s.sendEmptyReport(stream)
+}
+
+// emptyReport returns a receiver report without report blocks.
+func (s *UDPSession) emptyReport() []byte {
+	// version 2, no report block, packet type 201, length 1.
+	buf := []byte{0x80, 201, 0, 1, 0, 0, 0, 0}
+	putUint(buf[4:8], uint(s.SSRC))
+	return buf
+}
+
+// sendEmptyReport sends a receiver report without report blocks.
+func (s *UDPSession) sendEmptyReport(stream *UDPStream) error {
+	return s.writeRtcp(stream, s.emptyReport())
+}
+
+// sendNack asks the source of a stream to retransmit missing packets, as
+// many as the jitter buffer can wait for.
+func (s *UDPSession) sendNack(stream *UDPStream, loss Loss) error {
+	count := loss.Count
+	if count > stream.jitter.Size {
+		count = stream.jitter.Size
+	}
+	seqs := make([]uint16, count)
+	for i := range seqs {
+		seqs[i] = loss.Sequence + uint16(loss.Count-count+i)
+	}
+	// a compound packet starts with a report.
+	buf, err := rtcp.MarshalCompound(&rtcp.ReceiverReport{SSRC: s.SSRC}, &rtcp.GenericNack{
+		SenderSSRC: s.SSRC,
+		MediaSSRC:  uint32(stream.ssrc),
+		Nacks:      rtcp.NackPairsFromSequences(seqs),
+	})
+	if err != nil {
+		return err
+	}
+	return s.writeRtcp(stream, buf)
+}
+
+// ErrNoStream is returned when writing to a stream the session doesn't have.
+var ErrNoStream = errors.New("rtp: no such udp stream")
+
+// WriteRTCP sends a compound rtcp packet to the rtcp source of the stream
+// with index idx, such as a receiver report.
+func (s *UDPSession) WriteRTCP(idx uint, buf []byte) error {
+	for _, stream := range s.Streams() {
+		if stream.Idx == idx {
+			return s.writeRtcp(stream, buf)
+		}
+	}
+	return ErrNoStream
+}
+
+// writeRtcp sends a compound rtcp packet to the rtcp source of a stream,
+// protected if the stream is secure. Sources without a port are skipped.
+func (s *UDPSession) writeRtcp(stream *UDPStream, buf []byte) (err error) {
+	if stream.RtcpSource == nil || stream.RtcpSource.Port == 0 {
+		return nil
+	}
+	if stream.SRTP != nil {
+		if buf, err = stream.SRTP.EncryptRTCP(nil, buf); err != nil {
+			return err
+		}
+	}
+	_, err = stream.Rtcp.WriteTo(buf, stream.RtcpSource)
+	return err
+}
+
+// keepAlive sends rtcp to the sources of the streams periodically, until the
+// session is closed.
+func (s *UDPSession) keepAlive(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, stream := range s.Streams() {
+				s.sendEmptyReport(stream)
+			}
+		case <-s.closing:
+			return
 		}
-		cpy := make([]byte, n)
-		copy(cpy, buf)
-		go s.handleRtcp(cpy)
 	}
 }
 
-func (s *UDPSession) handleRtp(buf []byte) {
-	s.rtpChan <- ParsePacket(buf, 0)
+func toUint(arr []byte) (ret uint) {
+	for i, b := range arr {
+		ret |= uint(b) << (8 * uint(len(arr)-i-1))
+	}
+	return ret
 }
 
-func (s *UDPSession) handleRtcp(buf []byte) {
-	// TODO: implement rtcp
-	s.rtcpChan <- rtcp.ParsePacket(buf)
+// putUint is the reverse of toUint, it writes v big endian over the whole of arr.
+func putUint(arr []byte, v uint) {
+	for i := range arr {
+		arr[i] = byte(v >> (8 * uint(len(arr)-i-1)))
+	}
 }
@@ -0,0 +1,247 @@
+package rtp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/solomondong/rtsp/rtcp"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestUDPSession(t *testing.T) {
+	sender := listenLoopback(t)
+	defer sender.Close()
+	stranger := listenLoopback(t)
+	defer stranger.Close()
+
+	s := NewUDPSessionConfig(UDPConfig{JitterSize: 16, JitterLatency: 50 * time.Millisecond, ChanSize: 4})
+	var addrs []net.Addr
+	for idx := uint(0); idx < 2; idx++ {
+		rtpConn, rtcpConn := listenLoopback(t), listenLoopback(t)
+		addrs = append(addrs, rtpConn.LocalAddr())
+		err := s.AddStream(&UDPStream{
+			Idx:       idx,
+			Rtp:       rtpConn,
+			Rtcp:      rtcpConn,
+			RtpSource: sender.LocalAddr().(*net.UDPAddr),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send := func(conn *net.UDPConn, idx int, seq uint) {
+		buf, _ := Packet{SequenceNumber: seq, SyncSource: uint(idx), Payload: []byte{byte(seq)}}.Marshal()
+		if _, err := conn.WriteTo(buf, addrs[idx]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// stream 1 gets its packets out of order, and one from a stranger.
+	send(sender, 0, 1)
+	send(sender, 1, 11)
+	send(stranger, 1, 12)
+	send(sender, 1, 13)
+	send(sender, 1, 12)
+	send(sender, 0, 2)
+
+	got := map[uint][]uint{}
+	timeout := time.After(2 * time.Second)
+	for len(got[0]) < 2 || len(got[1]) < 3 {
+		select {
+		case packet := <-s.RtpChan:
+			got[packet.StreamIdx] = append(got[packet.StreamIdx], packet.SequenceNumber)
+			Release(packet)
+		case <-timeout:
+			t.Fatalf("received %v", got)
+		}
+	}
+	if !equalSeqs(got[0], []uint{1, 2}) || !equalSeqs(got[1], []uint{11, 12, 13}) {
+		t.Errorf("received %v", got)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-s.RtpChan; ok {
+		t.Errorf("rtp channel still open")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("error after close: %v", err)
+	}
+	if err := s.AddStream(&UDPStream{Rtp: listenLoopback(t), Rtcp: listenLoopback(t)}); err != ErrSessionClosed {
+		t.Errorf("stream added to a closed session: %v", err)
+	}
+}
+
+func TestUDPSessionCloseBlocked(t *testing.T) {
+	sender := listenLoopback(t)
+	defer sender.Close()
+	rtpConn := listenLoopback(t)
+	s := NewUDPSessionConfig(UDPConfig{JitterSize: 4, ChanSize: 1})
+	s.AddStream(&UDPStream{Rtp: rtpConn, Rtcp: listenLoopback(t)})
+
+	// nobody reads, the reader ends up blocked on the channel.
+	for seq := uint(0); seq < 8; seq++ {
+		buf, _ := Packet{SequenceNumber: seq}.Marshal()
+		sender.WriteTo(buf, rtpConn.LocalAddr())
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close blocked")
+	}
+}
+
+func TestUDPSessionPunch(t *testing.T) {
+	server := listenLoopback(t)
+	defer server.Close()
+	serverRtcp := listenLoopback(t)
+	defer serverRtcp.Close()
+
+	s := NewUDPSessionConfig(UDPConfig{JitterSize: 4, KeepAlive: 20 * time.Millisecond})
+	defer s.Close()
+	stream := &UDPStream{
+		Rtp:        listenLoopback(t),
+		Rtcp:       listenLoopback(t),
+		RtpSource:  server.LocalAddr().(*net.UDPAddr),
+		RtcpSource: serverRtcp.LocalAddr().(*net.UDPAddr),
+	}
+	s.AddStream(stream)
+	if err := s.Punch(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, addr, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := ParsePacket(buf[:n], 0)
+	if err != nil || addr.String() != stream.Rtp.LocalAddr().String() || uint32(packet.SyncSource) != s.SSRC {
+		t.Errorf("dummy rtp % x from %v: %v", buf[:n], addr, err)
+	}
+
+	// the punch and at least one keep alive.
+	serverRtcp.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2; i++ {
+		n, addr, err = serverRtcp.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 8 || buf[1] != 201 || addr.String() != stream.Rtcp.LocalAddr().String() {
+			t.Errorf("rtcp % x from %v", buf[:n], addr)
+		}
+	}
+}
+
+func TestUDPSessionSRTP(t *testing.T) {
+	sender := listenLoopback(t)
+	defer sender.Close()
+	profile, _ := LookupSRTPProfile("AEAD_AES_128_GCM")
+	encrypter, decrypter := newTestContexts(t, profile)
+
+	s := NewUDPSessionConfig(UDPConfig{JitterSize: 4, ChanSize: 4})
+	defer s.Close()
+	rtpConn := listenLoopback(t)
+	s.AddStream(&UDPStream{Rtp: rtpConn, Rtcp: listenLoopback(t), SRTP: decrypter})
+
+	// a forged packet is dropped, the genuine one goes through.
+	sender.WriteTo(testRTP(1), rtpConn.LocalAddr())
+	protected, _ := encrypter.EncryptRTP(nil, testRTP(2))
+	sender.WriteTo(protected, rtpConn.LocalAddr())
+
+	select {
+	case packet := <-s.RtpChan:
+		if packet.SequenceNumber != 2 || string(packet.Payload) != "secret media payload" {
+			t.Errorf("packet %+v", packet)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no packet")
+	}
+}
+
+func TestUDPSessionRetransmission(t *testing.T) {
+	sender := listenLoopback(t)
+	defer sender.Close()
+	senderRtcp := listenLoopback(t)
+	defer senderRtcp.Close()
+
+	s := NewUDPSessionConfig(UDPConfig{JitterSize: 16, JitterLatency: time.Second, ChanSize: 8})
+	defer s.Close()
+	rtpConn := listenLoopback(t)
+	s.AddStream(&UDPStream{
+		Rtp:        rtpConn,
+		Rtcp:       listenLoopback(t),
+		RtcpSource: senderRtcp.LocalAddr().(*net.UDPAddr),
+		RTX:        map[byte]byte{97: 96},
+		NACK:       true,
+	})
+
+	original := []Packet{
+		{Version: RTPVERSION, PayloadType: 96, SequenceNumber: 10, SyncSource: 0xcafe, Payload: []byte{10}},
+		{Version: RTPVERSION, PayloadType: 96, SequenceNumber: 11, SyncSource: 0xcafe, Payload: []byte{11}},
+		{Version: RTPVERSION, PayloadType: 96, SequenceNumber: 12, SyncSource: 0xcafe, Payload: []byte{12}},
+	}
+	for _, i := range []int{0, 2} {
+		buf, _ := original[i].Marshal()
+		sender.WriteTo(buf, rtpConn.LocalAddr())
+	}
+
+	// the gap is reported after the receiver report of the compound packet.
+	buf := make([]byte, 1500)
+	senderRtcp.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := senderRtcp.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := rtcp.ParsePacket(buf[8:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	nack, err := rtcp.ParseGenericNack(packet)
+	if err != nil || nack.MediaSSRC != 0xcafe || nack.SenderSSRC != s.SSRC || len(nack.Nacks) != 1 || nack.Nacks[0].PacketID != 11 {
+		t.Fatalf("nack %+v: %v", nack, err)
+	}
+
+	rtx, _ := original[1].WrapRTX(97, 0xbeef, 500).Marshal()
+	sender.WriteTo(rtx, rtpConn.LocalAddr())
+	for i := 0; i < 3; i++ {
+		select {
+		case packet := <-s.RtpChan:
+			if packet.SequenceNumber != uint(10+i) || packet.PayloadType != 96 || packet.SyncSource != 0xcafe || packet.Payload[0] != byte(10+i) {
+				t.Errorf("packet %d: %+v", i, packet)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no packet")
+		}
+	}
+}
+
+func TestListenUDPPair(t *testing.T) {
+	rtpConn, rtcpConn, err := ListenUDPPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtpConn.Close()
+	defer rtcpConn.Close()
+	port := rtpConn.LocalAddr().(*net.UDPAddr).Port
+	if port%2 != 0 || rtcpConn.LocalAddr().(*net.UDPAddr).Port != port+1 {
+		t.Errorf("ports %v %v", rtpConn.LocalAddr(), rtcpConn.LocalAddr())
+	}
+}
@@ -0,0 +1,39 @@
+package rtp
+
+import (
+	"errors"
+)
+
+// ErrRTXNoPayload is returned for rtx packets without an original packet,
+// such as the padding only packets used to probe bandwidth.
+var ErrRTXNoPayload = errors.New("rtp: rtx packet without payload")
+
+// UnwrapRTX restores the original packet retransmitted in a rtx packet (RFC
+// 4588): the sequence number comes from the two first bytes of the payload,
+// payloadType and ssrc are those of the original stream, whose rtx
+// association is given by the apt fmtp parameter.
+func (r Packet) UnwrapRTX(payloadType byte, ssrc uint) (Packet, error) {
+	if len(r.Payload) < 2 {
+		return Packet{}, ErrRTXNoPayload
+	}
+	packet := r
+	packet.PayloadType = payloadType
+	packet.SyncSource = ssrc
+	packet.SequenceNumber = toUint(r.Payload[0:2])
+	packet.Payload = r.Payload[2:]
+	return packet, nil
+}
+
+// WrapRTX builds the rtx packet retransmitting a packet, with the sequence
+// number, payload type and ssrc of the rtx stream.
+func (r Packet) WrapRTX(payloadType byte, ssrc uint, seq uint) Packet {
+	packet := r
+	packet.PayloadType = payloadType
+	packet.SyncSource = ssrc
+	packet.SequenceNumber = seq
+	packet.Payload = make([]byte, 2+len(r.Payload))
+	putUint(packet.Payload[0:2], r.SequenceNumber)
+	copy(packet.Payload[2:], r.Payload)
+	packet.buf = nil
+	return packet
+}
@@ -0,0 +1,496 @@
+package rtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"sync"
+)
+
+// SRTPProfile defines a SRTP crypto suite, as named by SDES (RFC 4568,
+// RFC 6188 and RFC 7714).
+type SRTPProfile struct {
+	Name    string
+	KeyLen  int
+	SaltLen int
+	// AuthTagLen is the length of the srtp authentication tag,
+	// RTCPAuthTagLen the one of srtcp.
+	AuthTagLen     int
+	RTCPAuthTagLen int
+	// AEAD profiles use AES-GCM instead of AES-CM and HMAC-SHA1.
+	AEAD bool
+}
+
+var srtpProfiles = []SRTPProfile{
+	{Name: "AES_CM_128_HMAC_SHA1_80", KeyLen: 16, SaltLen: 14, AuthTagLen: 10, RTCPAuthTagLen: 10},
+	{Name: "AES_CM_128_HMAC_SHA1_32", KeyLen: 16, SaltLen: 14, AuthTagLen: 4, RTCPAuthTagLen: 10},
+	{Name: "AES_256_CM_HMAC_SHA1_80", KeyLen: 32, SaltLen: 14, AuthTagLen: 10, RTCPAuthTagLen: 10},
+	{Name: "AES_256_CM_HMAC_SHA1_32", KeyLen: 32, SaltLen: 14, AuthTagLen: 4, RTCPAuthTagLen: 10},
+	{Name: "AEAD_AES_128_GCM", KeyLen: 16, SaltLen: 12, AuthTagLen: 16, RTCPAuthTagLen: 16, AEAD: true},
+	{Name: "AEAD_AES_256_GCM", KeyLen: 32, SaltLen: 12, AuthTagLen: 16, RTCPAuthTagLen: 16, AEAD: true},
+}
+
+// LookupSRTPProfile finds a SRTP profile by its SDES name.
+func LookupSRTPProfile(name string) (SRTPProfile, bool) {
+	for _, p := range srtpProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return SRTPProfile{}, false
+}
+
+// Errors returned by SRTP
+var (
+	ErrSRTPKeyLength   = errors.New("rtp: srtp master key or salt length invalid")
+	ErrSRTPTooShort    = errors.New("rtp: srtp packet too short")
+	ErrSRTPAuthFailed  = errors.New("rtp: srtp authentication failed")
+	ErrSRTPReplayed    = errors.New("rtp: srtp packet replayed")
+	ErrSRTPMKIMismatch = errors.New("rtp: srtp mki unknown")
+)
+
+// Key derivation labels of RFC 3711 section 4.3.
+const (
+	labelRTPEncryption  = 0x00
+	labelRTPAuth        = 0x01
+	labelRTPSalt        = 0x02
+	labelRTCPEncryption = 0x03
+	labelRTCPAuth       = 0x04
+	labelRTCPSalt       = 0x05
+)
+
+// replayWindowSize is the number of packets behind the highest one accepted
+// by the replay protection.
+const replayWindowSize = 64
+
+// srtpKeys are the session keys of one direction and protocol.
+type srtpKeys struct {
+	block cipher.Block
+	aead  cipher.AEAD
+	salt  []byte
+	mac   hash.Hash
+}
+
+// replayWindow remembers which of the latest indexes have been seen.
+type replayWindow struct {
+	started bool
+	highest uint64
+	mask    uint64
+}
+
+func (w *replayWindow) check(index uint64) error {
+	if !w.started || index > w.highest {
+		return nil
+	}
+	diff := w.highest - index
+	if diff >= replayWindowSize || w.mask&(1<<diff) != 0 {
+		return ErrSRTPReplayed
+	}
+	return nil
+}
+
+func (w *replayWindow) accept(index uint64) {
+	if !w.started {
+		w.started = true
+		w.highest = index
+		w.mask = 1
+		return
+	}
+	if index > w.highest {
+		shift := index - w.highest
+		if shift >= replayWindowSize {
+			w.mask = 0
+		} else {
+			w.mask <<= shift
+		}
+		w.mask |= 1
+		w.highest = index
+		return
+	}
+	w.mask |= 1 << (w.highest - index)
+}
+
+// srtpSource is the state kept for a SSRC.
+type srtpSource struct {
+	started bool
+	roc     uint32
+	lastSeq uint16
+
+	replay     replayWindow
+	rtcpReplay replayWindow
+	rtcpIndex  uint32
+}
+
+// SRTPContext protects and unprotects the rtp and rtcp packets of a session
+// with the keys derived from a master key and salt (RFC 3711, RFC 7714).
+// It is safe for concurrent use.
+type SRTPContext struct {
+	Profile SRTPProfile
+	// MKI, when set, is the master key identifier carried by the packets.
+	MKI []byte
+
+	mu      sync.Mutex
+	rtp     srtpKeys
+	rtcp    srtpKeys
+	sources map[uint32]*srtpSource
+}
+
+// NewSRTPContext derives the session keys of a profile from a master key
+// and salt.
+func NewSRTPContext(profile SRTPProfile, masterKey, masterSalt []byte) (*SRTPContext, error) {
+	if len(masterKey) != profile.KeyLen || len(masterSalt) != profile.SaltLen {
+		return nil, ErrSRTPKeyLength
+	}
+	c := &SRTPContext{Profile: profile, sources: make(map[uint32]*srtpSource)}
+	var err error
+	if c.rtp, err = deriveSRTPKeys(profile, masterKey, masterSalt, labelRTPEncryption, labelRTPAuth, labelRTPSalt); err != nil {
+		return nil, err
+	}
+	if c.rtcp, err = deriveSRTPKeys(profile, masterKey, masterSalt, labelRTCPEncryption, labelRTCPAuth, labelRTCPSalt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// deriveKey is the AES-CM key derivation function with a key derivation
+// rate of 0.
+func deriveKey(master cipher.Block, masterSalt []byte, label byte, n int) []byte {
+	var iv [16]byte
+	copy(iv[:], masterSalt)
+	iv[7] ^= label
+	out := make([]byte, n)
+	cipher.NewCTR(master, iv[:]).XORKeyStream(out, out)
+	return out
+}
+
+func deriveSRTPKeys(profile SRTPProfile, masterKey, masterSalt []byte, encLabel, authLabel, saltLabel byte) (keys srtpKeys, err error) {
+	master, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return
+	}
+	if keys.block, err = aes.NewCipher(deriveKey(master, masterSalt, encLabel, profile.KeyLen)); err != nil {
+		return
+	}
+	keys.salt = deriveKey(master, masterSalt, saltLabel, profile.SaltLen)
+	if profile.AEAD {
+		keys.aead, err = cipher.NewGCM(keys.block)
+		return
+	}
+	keys.mac = hmac.New(sha1.New, deriveKey(master, masterSalt, authLabel, 20))
+	return
+}
+
+func (c *SRTPContext) source(ssrc uint32) *srtpSource {
+	s, ok := c.sources[ssrc]
+	if !ok {
+		s = &srtpSource{}
+		c.sources[ssrc] = s
+	}
+	return s
+}
+
+// SetROC sets the rollover counter of a SSRC, as given by key management
+// for a stream joined after its sequence numbers wrapped.
+func (c *SRTPContext) SetROC(ssrc uint32, roc uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.source(ssrc).roc = roc
+}
+
+// ROC returns the rollover counter of a SSRC.
+func (c *SRTPContext) ROC(ssrc uint32) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.source(ssrc).roc
+}
+
+// rtpHeaderSize returns the size of the header of a rtp packet, extension
+// included.
+func rtpHeaderSize(buf []byte) (int, error) {
+	if len(buf) < 12 {
+		return 0, ErrPacketTooShort
+	}
+	n := 12 + 4*int(buf[0]&0x0f)
+	if buf[0]&0x10 != 0 {
+		if len(buf) < n+4 {
+			return 0, ErrPacketTooShort
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(buf[n+2:n+4]))
+	}
+	if len(buf) < n {
+		return 0, ErrPacketTooShort
+	}
+	return n, nil
+}
+
+// counterIV builds the AES-CM iv of RFC 3711 section 4.1.1.
+func counterIV(salt []byte, ssrc uint32, index uint64) []byte {
+	iv := make([]byte, 16)
+	copy(iv, salt)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= byte(ssrc >> (24 - 8*uint(i)))
+	}
+	for i := 0; i < 6; i++ {
+		iv[8+i] ^= byte(index >> (40 - 8*uint(i)))
+	}
+	return iv
+}
+
+// gcmIV builds the AES-GCM iv of RFC 7714 section 8.1 and 9.1.
+func gcmIV(salt []byte, ssrc uint32, high uint32, low uint16) []byte {
+	iv := make([]byte, 12)
+	binary.BigEndian.PutUint32(iv[2:6], ssrc)
+	binary.BigEndian.PutUint32(iv[6:10], high)
+	binary.BigEndian.PutUint16(iv[10:12], low)
+	for i := range iv {
+		iv[i] ^= salt[i]
+	}
+	return iv
+}
+
+// authTag computes the HMAC-SHA1 tag of data followed by trailer.
+func (k *srtpKeys) authTag(data []byte, trailer []byte, n int) []byte {
+	k.mac.Reset()
+	k.mac.Write(data)
+	k.mac.Write(trailer)
+	return k.mac.Sum(nil)[:n]
+}
+
+// estimateROC guesses the rollover counter of a received sequence number,
+// RFC 3711 appendix A.
+func (s *srtpSource) estimateROC(seq uint16) uint32 {
+	if !s.started {
+		return s.roc
+	}
+	if s.lastSeq < 1<<15 {
+		if int(seq)-int(s.lastSeq) > 1<<15 && s.roc > 0 {
+			return s.roc - 1
+		}
+	} else if int(s.lastSeq)-(1<<15) > int(seq) {
+		return s.roc + 1
+	}
+	return s.roc
+}
+
+// DecryptRTP verifies and decrypts a srtp packet into a rtp packet, appended
+// to dst[:0]. dst may be buf.
+func (c *SRTPContext) DecryptRTP(dst, buf []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := rtpHeaderSize(buf)
+	if err != nil {
+		return nil, err
+	}
+	tagLen, mkiLen := c.Profile.AuthTagLen, len(c.MKI)
+	if len(buf) < h+tagLen+mkiLen {
+		return nil, ErrSRTPTooShort
+	}
+	ssrc := binary.BigEndian.Uint32(buf[8:12])
+	seq := binary.BigEndian.Uint16(buf[2:4])
+	s := c.source(ssrc)
+	roc := s.estimateROC(seq)
+	index := uint64(roc)<<16 | uint64(seq)
+	if err := s.replay.check(index); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if c.Profile.AEAD {
+		// the mki sits between the tag and the end of the packet.
+		end := len(buf) - mkiLen
+		if mkiLen > 0 && !hmac.Equal(buf[end:], c.MKI) {
+			return nil, ErrSRTPMKIMismatch
+		}
+		iv := gcmIV(c.rtp.salt, ssrc, roc, seq)
+		header := append([]byte(nil), buf[:h]...)
+		plain, err := c.rtp.aead.Open(nil, iv, buf[h:end], header)
+		if err != nil {
+			return nil, ErrSRTPAuthFailed
+		}
+		out = append(append(dst[:0], header...), plain...)
+	} else {
+		end := len(buf) - tagLen - mkiLen
+		if mkiLen > 0 && !hmac.Equal(buf[end:end+mkiLen], c.MKI) {
+			return nil, ErrSRTPMKIMismatch
+		}
+		var rocBytes [4]byte
+		binary.BigEndian.PutUint32(rocBytes[:], roc)
+		if !hmac.Equal(c.rtp.authTag(buf[:end], rocBytes[:], tagLen), buf[len(buf)-tagLen:]) {
+			return nil, ErrSRTPAuthFailed
+		}
+		out = append(dst[:0], buf[:end]...)
+		cipher.NewCTR(c.rtp.block, counterIV(c.rtp.salt, ssrc, index)).XORKeyStream(out[h:], out[h:])
+	}
+
+	// the packet is authentic, the state can move on.
+	s.replay.accept(index)
+	switch {
+	case !s.started:
+		s.started = true
+		s.lastSeq = seq
+	case roc == s.roc+1:
+		s.roc = roc
+		s.lastSeq = seq
+	case roc == s.roc && seq > s.lastSeq:
+		s.lastSeq = seq
+	}
+	return out, nil
+}
+
+// EncryptRTP encrypts and authenticates a rtp packet into a srtp packet,
+// appended to dst[:0]. dst may be buf.
+func (c *SRTPContext) EncryptRTP(dst, buf []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, err := rtpHeaderSize(buf)
+	if err != nil {
+		return nil, err
+	}
+	ssrc := binary.BigEndian.Uint32(buf[8:12])
+	seq := binary.BigEndian.Uint16(buf[2:4])
+	s := c.source(ssrc)
+	switch {
+	case !s.started:
+		s.started = true
+		s.lastSeq = seq
+	case seq < s.lastSeq && s.lastSeq-seq > 1<<15:
+		// the sequence number wrapped.
+		s.roc++
+		s.lastSeq = seq
+	case seq > s.lastSeq && seq-s.lastSeq < 1<<15:
+		s.lastSeq = seq
+	}
+	roc := s.roc
+	if seq > s.lastSeq && seq-s.lastSeq > 1<<15 && roc > 0 {
+		// a late packet of the previous cycle.
+		roc--
+	}
+
+	if c.Profile.AEAD {
+		iv := gcmIV(c.rtp.salt, ssrc, roc, seq)
+		header := append([]byte(nil), buf[:h]...)
+		payload := append([]byte(nil), buf[h:]...)
+		out := append(dst[:0], header...)
+		out = c.rtp.aead.Seal(out, iv, payload, header)
+		return append(out, c.MKI...), nil
+	}
+	out := append(dst[:0], buf...)
+	index := uint64(roc)<<16 | uint64(seq)
+	cipher.NewCTR(c.rtp.block, counterIV(c.rtp.salt, ssrc, index)).XORKeyStream(out[h:], out[h:])
+	var rocBytes [4]byte
+	binary.BigEndian.PutUint32(rocBytes[:], roc)
+	tag := c.rtp.authTag(out, rocBytes[:], c.Profile.AuthTagLen)
+	out = append(out, c.MKI...)
+	return append(out, tag...), nil
+}
+
+// DecryptRTCP verifies and decrypts a srtcp packet into a compound rtcp
+// packet, appended to dst[:0]. dst may be buf.
+func (c *SRTPContext) DecryptRTCP(dst, buf []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tagLen, mkiLen := c.Profile.RTCPAuthTagLen, len(c.MKI)
+	if len(buf) < 8+4+tagLen+mkiLen {
+		return nil, ErrSRTPTooShort
+	}
+	ssrc := binary.BigEndian.Uint32(buf[4:8])
+	s := c.source(ssrc)
+
+	var trailer, mki []byte
+	if c.Profile.AEAD {
+		end := len(buf) - mkiLen
+		trailer, mki = buf[end-4:end], buf[end:]
+	} else {
+		end := len(buf) - tagLen - mkiLen
+		trailer, mki = buf[end-4:end], buf[end:end+mkiLen]
+	}
+	if mkiLen > 0 && !hmac.Equal(mki, c.MKI) {
+		return nil, ErrSRTPMKIMismatch
+	}
+	encrypted := trailer[0]&0x80 != 0
+	index := binary.BigEndian.Uint32(trailer) & 0x7fffffff
+	if err := s.rtcpReplay.check(uint64(index)); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if c.Profile.AEAD {
+		body := buf[:len(buf)-mkiLen-4]
+		iv := gcmIV(c.rtcp.salt, ssrc, 0, 0)
+		binary.BigEndian.PutUint32(iv[8:12], binary.BigEndian.Uint32(iv[8:12])^index)
+		var aad []byte
+		var sealed []byte
+		if encrypted {
+			aad = append(append([]byte(nil), body[:8]...), trailer...)
+			sealed = body[8:]
+		} else {
+			// only authenticated, the tag follows the plain packet.
+			if len(body) < 8+tagLen {
+				return nil, ErrSRTPTooShort
+			}
+			aad = append(append([]byte(nil), body[:len(body)-tagLen]...), trailer...)
+			sealed = body[len(body)-tagLen:]
+		}
+		plain, err := c.rtcp.aead.Open(nil, iv, sealed, aad)
+		if err != nil {
+			return nil, ErrSRTPAuthFailed
+		}
+		if encrypted {
+			out = append(append(dst[:0], body[:8]...), plain...)
+		} else {
+			out = append(dst[:0], body[:len(body)-tagLen]...)
+		}
+	} else {
+		end := len(buf) - tagLen - mkiLen
+		if !hmac.Equal(c.rtcp.authTag(buf[:end], nil, tagLen), buf[len(buf)-tagLen:]) {
+			return nil, ErrSRTPAuthFailed
+		}
+		out = append(dst[:0], buf[:end-4]...)
+		if encrypted {
+			cipher.NewCTR(c.rtcp.block, counterIV(c.rtcp.salt, ssrc, uint64(index))).XORKeyStream(out[8:], out[8:])
+		}
+	}
+	s.rtcpReplay.accept(uint64(index))
+	return out, nil
+}
+
+// EncryptRTCP encrypts and authenticates a compound rtcp packet into a srtcp
+// packet, appended to dst[:0]. dst may be buf.
+func (c *SRTPContext) EncryptRTCP(dst, buf []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(buf) < 8 {
+		return nil, ErrSRTPTooShort
+	}
+	ssrc := binary.BigEndian.Uint32(buf[4:8])
+	s := c.source(ssrc)
+	index := s.rtcpIndex
+	s.rtcpIndex = (s.rtcpIndex + 1) & 0x7fffffff
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], 1<<31|index)
+
+	if c.Profile.AEAD {
+		iv := gcmIV(c.rtcp.salt, ssrc, 0, 0)
+		binary.BigEndian.PutUint32(iv[8:12], binary.BigEndian.Uint32(iv[8:12])^index)
+		header := append([]byte(nil), buf[:8]...)
+		payload := append([]byte(nil), buf[8:]...)
+		out := append(dst[:0], header...)
+		out = c.rtcp.aead.Seal(out, iv, payload, append(header, trailer[:]...))
+		out = append(out, trailer[:]...)
+		return append(out, c.MKI...), nil
+	}
+	out := append(dst[:0], buf...)
+	cipher.NewCTR(c.rtcp.block, counterIV(c.rtcp.salt, ssrc, uint64(index))).XORKeyStream(out[8:], out[8:])
+	out = append(out, trailer[:]...)
+	tag := c.rtcp.authTag(out, nil, c.Profile.RTCPAuthTagLen)
+	out = append(out, c.MKI...)
+	return append(out, tag...), nil
+}
@@ -0,0 +1,376 @@
+package rtp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the key management
+var (
+	ErrSDESKeyParams      = errors.New("rtp: sdes key parameters invalid")
+	ErrSRTPProfile        = errors.New("rtp: srtp crypto suite unsupported")
+	ErrMIKEYTruncated     = errors.New("rtp: mikey message truncated")
+	ErrMIKEYUnsupported   = errors.New("rtp: mikey message unsupported")
+	ErrMIKEYEncryptedKeys = errors.New("rtp: mikey encrypted key data unsupported")
+	ErrMIKEYNoKey         = errors.New("rtp: mikey message without key")
+)
+
+// NewSRTPContextSDES creates a SRTP context from the crypto suite and key
+// parameters of a SDES crypto attribute (RFC 4568), such as
+// "AES_CM_128_HMAC_SHA1_80" and "inline:<key||salt>|2^20|1:4". Only the
+// first key is used.
+func NewSRTPContextSDES(suite, keyParams string) (*SRTPContext, error) {
+	profile, ok := LookupSRTPProfile(suite)
+	if !ok {
+		return nil, ErrSRTPProfile
+	}
+	keyParams = strings.SplitN(keyParams, ";", 2)[0]
+	if !strings.HasPrefix(keyParams, "inline:") {
+		return nil, ErrSDESKeyParams
+	}
+	fields := strings.Split(strings.TrimPrefix(keyParams, "inline:"), "|")
+	key, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil {
+		// some senders leave the padding out.
+		if key, err = base64.RawStdEncoding.DecodeString(fields[0]); err != nil {
+			return nil, ErrSDESKeyParams
+		}
+	}
+	if len(key) != profile.KeyLen+profile.SaltLen {
+		return nil, ErrSRTPKeyLength
+	}
+	c, err := NewSRTPContext(profile, key[:profile.KeyLen], key[profile.KeyLen:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the optional lifetime comes before the optional "value:length" mki.
+	for _, field := range fields[1:] {
+		mki := strings.SplitN(field, ":", 2)
+		if len(mki) != 2 {
+			continue
+		}
+		value, err1 := strconv.ParseUint(mki[0], 10, 64)
+		length, err2 := strconv.Atoi(mki[1])
+		if err1 != nil || err2 != nil || length < 1 || length > 128 {
+			return nil, ErrSDESKeyParams
+		}
+		c.MKI = make([]byte, length)
+		for i := length - 1; i >= 0 && value > 0; i-- {
+			c.MKI[i] = byte(value)
+			value >>= 8
+		}
+	}
+	return c, nil
+}
+
+// MIKEY payload types of RFC 3830 section 6.
+const (
+	mikeyPayloadLast    = 0
+	mikeyPayloadKEMAC   = 1
+	mikeyPayloadT       = 5
+	mikeyPayloadID      = 6
+	mikeyPayloadV       = 9
+	mikeyPayloadSP      = 10
+	mikeyPayloadRAND    = 11
+	mikeyPayloadGenExt  = 21
+	mikeyKeyTGK         = 0
+	mikeyKeyTGKSalt     = 1
+	mikeyKeyTEK         = 2
+	mikeyKeyTEKSalt     = 3
+	mikeyMapSRTP        = 0
+	mikeyEncryptionNull = 0
+)
+
+// MIKEYCryptoSession is an entry of the SRTP crypto session map of a MIKEY
+// message.
+type MIKEYCryptoSession struct {
+	PolicyNo byte
+	SSRC     uint32
+	ROC      uint32
+}
+
+// MIKEY holds what is needed from a MIKEY message (RFC 3830) to set up SRTP.
+// Only key data sent in the clear, as done over RTSPS, is supported.
+type MIKEY struct {
+	CSBID          uint32
+	CryptoSessions []MIKEYCryptoSession
+	Rand           []byte
+	// Policies holds the SRTP policy parameters by policy number and type.
+	Policies map[byte]map[byte][]byte
+	// KeyType tells whether Key is a TGK, from which TEKs are derived, or
+	// the TEK itself.
+	KeyType byte
+	Key     []byte
+	Salt    []byte
+}
+
+type mikeyReader struct {
+	buf []byte
+	err error
+}
+
+func (r *mikeyReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = ErrMIKEYTruncated
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *mikeyReader) u8() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *mikeyReader) u16() int {
+	if b := r.next(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *mikeyReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// ParseMIKEY parses a MIKEY message, as found base64 encoded in the SDP
+// key-mgmt attribute.
+func ParseMIKEY(data []byte) (*MIKEY, error) {
+	m := &MIKEY{Policies: make(map[byte]map[byte][]byte)}
+	r := &mikeyReader{buf: data}
+
+	if version := r.u8(); r.err == nil && version != 1 {
+		return nil, ErrMIKEYUnsupported
+	}
+	r.u8() // data type
+	next := r.u8()
+	r.u8() // V and PRF
+	m.CSBID = r.u32()
+	count := int(r.u8())
+	if mapType := r.u8(); r.err == nil && mapType != mikeyMapSRTP {
+		return nil, ErrMIKEYUnsupported
+	}
+	for i := 0; i < count && r.err == nil; i++ {
+		m.CryptoSessions = append(m.CryptoSessions, MIKEYCryptoSession{PolicyNo: r.u8(), SSRC: r.u32(), ROC: r.u32()})
+	}
+
+	for next != mikeyPayloadLast && r.err == nil {
+		payload := next
+		next = r.u8()
+		switch payload {
+		case mikeyPayloadT:
+			if tsType := r.u8(); tsType == 2 {
+				r.next(4)
+			} else {
+				r.next(8)
+			}
+		case mikeyPayloadRAND:
+			m.Rand = r.next(int(r.u8()))
+		case mikeyPayloadID:
+			r.u8()
+			r.next(r.u16())
+		case mikeyPayloadV:
+			if macAlg := r.u8(); macAlg != 0 {
+				r.next(20)
+			}
+		case mikeyPayloadGenExt:
+			r.u8()
+			r.next(r.u16())
+		case mikeyPayloadSP:
+			policyNo := r.u8()
+			r.u8() // protocol type, SRTP
+			params := &mikeyReader{buf: r.next(r.u16())}
+			policy := make(map[byte][]byte)
+			for len(params.buf) > 0 && params.err == nil {
+				typ := params.u8()
+				policy[typ] = params.next(int(params.u8()))
+			}
+			if params.err != nil {
+				return nil, params.err
+			}
+			m.Policies[policyNo] = policy
+		case mikeyPayloadKEMAC:
+			if encAlg := r.u8(); r.err == nil && encAlg != mikeyEncryptionNull {
+				return nil, ErrMIKEYEncryptedKeys
+			}
+			keys := r.next(r.u16())
+			if macAlg := r.u8(); macAlg != 0 {
+				r.next(20)
+			}
+			if r.err == nil {
+				if err := m.parseKeyData(keys); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, ErrMIKEYUnsupported
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if m.Key == nil {
+		return nil, ErrMIKEYNoKey
+	}
+	return m, nil
+}
+
+// parseKeyData reads the key data sub-payloads of a KEMAC payload, the first
+// key is kept.
+func (m *MIKEY) parseKeyData(data []byte) error {
+	r := &mikeyReader{buf: data}
+	for next := byte(mikeyPayloadKEMAC); next != mikeyPayloadLast && r.err == nil; {
+		next = r.u8()
+		typeKV := r.u8()
+		keyType, kv := typeKV>>4, typeKV&0x0f
+		key := r.next(r.u16())
+		var salt []byte
+		if keyType == mikeyKeyTGKSalt || keyType == mikeyKeyTEKSalt {
+			salt = r.next(r.u16())
+		}
+		switch kv {
+		case 1: // SPI/MKI
+			r.next(int(r.u8()))
+		case 2: // interval
+			r.next(int(r.u8()))
+			r.next(int(r.u8()))
+		}
+		if r.err == nil && m.Key == nil {
+			m.KeyType, m.Key, m.Salt = keyType, key, salt
+		}
+	}
+	return r.err
+}
+
+// mikeyPRF is the PRF of RFC 3830 section 4.1.2.
+func mikeyPRF(inkey, label []byte, n int) []byte {
+	out := make([]byte, n)
+	for len(inkey) > 0 {
+		s := inkey
+		if len(s) > 32 {
+			s = s[:32]
+		}
+		inkey = inkey[len(s):]
+
+		// P(s, label, m)
+		mac := hmac.New(sha1.New, s)
+		a := label
+		var p []byte
+		for len(p) < n {
+			mac.Reset()
+			mac.Write(a)
+			a = mac.Sum(nil)
+			mac.Reset()
+			mac.Write(a)
+			mac.Write(label)
+			p = mac.Sum(p)
+		}
+		for i := range out {
+			out[i] ^= p[i]
+		}
+	}
+	return out
+}
+
+// tek derives the key and salt of a crypto session from a TGK, RFC 3830
+// section 4.1.3.
+func (m *MIKEY) tek(csID byte, keyLen, saltLen int) (key, salt []byte) {
+	label := func(constant uint32) []byte {
+		b := make([]byte, 9, 9+len(m.Rand))
+		binary.BigEndian.PutUint32(b[0:4], constant)
+		b[4] = csID
+		binary.BigEndian.PutUint32(b[5:9], m.CSBID)
+		return append(b, m.Rand...)
+	}
+	key = mikeyPRF(m.Key, label(0x2AD01C64), keyLen)
+	salt = mikeyPRF(m.Key, label(0x39A2C14B), saltLen)
+	return
+}
+
+// SRTP policy parameter types of RFC 3830 section 6.10.1.
+const (
+	mikeySPEncryptionAlg = 0
+	mikeySPEncryptionLen = 1
+	mikeySPSaltLen       = 4
+	mikeySPAuthTagLen    = 11
+)
+
+func policyInt(policy map[byte][]byte, typ byte, def int) int {
+	b, ok := policy[typ]
+	if !ok || len(b) == 0 {
+		return def
+	}
+	v := 0
+	for _, x := range b {
+		v = v<<8 | int(x)
+	}
+	return v
+}
+
+// SRTPContext creates the SRTP context of the crypto session of a SSRC, or
+// of the first crypto session if the SSRC is not in the map. The rollover
+// counters of the map are set in the context.
+func (m *MIKEY) SRTPContext(ssrc uint32) (*SRTPContext, error) {
+	idx := 0
+	for i, cs := range m.CryptoSessions {
+		if cs.SSRC == ssrc {
+			idx = i
+		}
+	}
+	var policy map[byte][]byte
+	if idx < len(m.CryptoSessions) {
+		policy = m.Policies[m.CryptoSessions[idx].PolicyNo]
+	}
+	if policyInt(policy, mikeySPEncryptionAlg, 1) != 1 {
+		// only AES-CM
+		return nil, ErrSRTPProfile
+	}
+	keyLen := policyInt(policy, mikeySPEncryptionLen, 16)
+	tagLen := policyInt(policy, mikeySPAuthTagLen, 10)
+	var profile SRTPProfile
+	found := false
+	for _, p := range srtpProfiles {
+		if !p.AEAD && p.KeyLen == keyLen && p.AuthTagLen == tagLen {
+			profile, found = p, true
+		}
+	}
+	if !found || policyInt(policy, mikeySPSaltLen, 14) != profile.SaltLen {
+		return nil, ErrSRTPProfile
+	}
+
+	key, salt := m.Key, m.Salt
+	switch m.KeyType {
+	case mikeyKeyTGK, mikeyKeyTGKSalt:
+		// crypto session ids count from 1, a salt sent along is used as is.
+		key, salt = m.tek(byte(idx+1), profile.KeyLen, profile.SaltLen)
+		if m.Salt != nil {
+			salt = m.Salt
+		}
+	}
+	c, err := NewSRTPContext(profile, key, salt)
+	if err != nil {
+		return nil, err
+	}
+	for _, cs := range m.CryptoSessions {
+		if cs.SSRC != 0 {
+			c.SetROC(cs.SSRC, cs.ROC)
+		}
+	}
+	return c, nil
+}
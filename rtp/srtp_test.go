@@ -0,0 +1,334 @@
+package rtp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// unhex decodes a test vector, spaces and line breaks are left out.
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// TestSRTPKeyDerivation checks the test vectors of RFC 3711 appendix B.3.
+func TestSRTPKeyDerivation(t *testing.T) {
+	master, _ := aes.NewCipher(unhex("E1F97A0D3E018BE0D64FA32C06DE4139"))
+	salt := unhex("0EC675AD498AFEEBB6960B3AABE6")
+	tests := []struct {
+		label byte
+		n     int
+		want  string
+	}{
+		{labelRTPEncryption, 16, "C61E7A93744F39EE10734AFE3FF7A087"},
+		{labelRTPSalt, 14, "30CBBC08863D8C85D49DB34A9AE1"},
+		{labelRTPAuth, 20, "CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"},
+	}
+	for _, test := range tests {
+		if got := deriveKey(master, salt, test.label, test.n); !bytes.Equal(got, unhex(test.want)) {
+			t.Errorf("label %d: got %X, want %s", test.label, got, test.want)
+		}
+	}
+}
+
+// newSessionKeyContext creates a context with the session keys given as is,
+// as the test vectors of RFC 3711 B.2 and RFC 7714 are.
+func newSessionKeyContext(t *testing.T, profile SRTPProfile, key, salt []byte) *SRTPContext {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := srtpKeys{block: block, salt: salt}
+	if profile.AEAD {
+		if keys.aead, err = cipher.NewGCM(block); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		keys.mac = hmac.New(sha1.New, make([]byte, 20))
+	}
+	return &SRTPContext{Profile: profile, rtp: keys, rtcp: keys, sources: make(map[uint32]*srtpSource)}
+}
+
+// TestSRTPKeystream checks the AES-CM keystream against RFC 3711 appendix
+// B.2 and RFC 6188 section 7.1, a payload of zeros encrypts to the keystream.
+func TestSRTPKeystream(t *testing.T) {
+	tests := []struct {
+		profile   string
+		key       string
+		keystream string
+	}{
+		{"AES_CM_128_HMAC_SHA1_80", "2B7E151628AED2A6ABF7158809CF4F3C",
+			"E03EAD0935C95E80E166B16DD92B4EB4 D23513162B02D0F72A43A2FE4A5F97AB 41E95B3BB0A2E8DD477901E4FCA894C0"},
+		{"AES_256_CM_HMAC_SHA1_80", "57f82fe3613fd170a85ec93c40b1f0922ec4cb0dc025b58272147cc438944a98",
+			"92bdd28a93c3f52511c677d08b5515a4 9da71b2378a854f67050756ded165bac 63c4868b7096d88421b563b8c94c9a31"},
+	}
+	for _, test := range tests {
+		profile, _ := LookupSRTPProfile(test.profile)
+		c := newSessionKeyContext(t, profile, unhex(test.key), unhex("F0F1F2F3F4F5F6F7F8F9FAFBFCFD"))
+		keystream := unhex(test.keystream)
+		// ssrc and index 0 leave the counter at the salt.
+		header := []byte{0x80, 0x0f, 0x00, 0x00, 0xde, 0xca, 0xfb, 0xad, 0x00, 0x00, 0x00, 0x00}
+		packet := append(append([]byte(nil), header...), make([]byte, len(keystream))...)
+		out, err := c.EncryptRTP(nil, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out[:len(header)], header) || !bytes.Equal(out[len(header):len(header)+len(keystream)], keystream) {
+			t.Errorf("%s: keystream %X", test.profile, out[len(header):len(header)+len(keystream)])
+		}
+	}
+}
+
+// TestSRTPGCMVectors checks the AEAD profiles against the srtp and srtcp
+// examples of RFC 7714 sections 16 and 17.
+func TestSRTPGCMVectors(t *testing.T) {
+	const (
+		rtpPacket = "8040f17b 8041f8d3 5501a0b2 47616c6c 69612065 7374206f 6d6e6973 20646976" +
+			" 69736120 696e2070 61727465 73207472 6573"
+		rtcpPacket = "81c8000d 4d617273 4e545031 4e545032 52545020 0000042a 0000e930 4c756e61" +
+			" deadbeef deadbeef deadbeef deadbeef deadbeef"
+	)
+	tests := []struct {
+		profile string
+		key     string
+		srtp    string
+		srtcp   string
+		// authenticated is the srtcp packet with the E flag clear.
+		authenticated string
+	}{
+		{"AEAD_AES_128_GCM", "000102030405060708090a0b0c0d0e0f",
+			"8040f17b 8041f8d3 5501a0b2 f24de3a3 fb34de6c acba861c 9d7e4bca be633bd5" +
+				" 0d294e6f 42a5f47a 51c7d19b 36de3adf 8833899d 7f27beb1 6a9152cf 765ee439 0cce",
+			"81c8000d 4d617273 63e94885 dcdab67c a727d766 2f6b7e99 7ff5c0f7 6c06f32d" +
+				" c676a5f1 730d6fda 4ce09b46 86303ded 0bb9275b c84aa458 96cf4d2f c5abf872 45d9eade 800005d4",
+			"81c8000d 4d617273 4e545031 4e545032 52545020 0000042a 0000e930 4c756e61" +
+				" deadbeef deadbeef deadbeef deadbeef deadbeef 841dd968 3dd78ec9 2ae58790 125f62b3 000005d4"},
+		{"AEAD_AES_256_GCM", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			"8040f17b 8041f8d3 5501a0b2 32b1de78 a822fe12 ef9f78fa 332e33aa b1801238" +
+				" 9a58e2f3 b50b2a02 76ffae0f 1ba63799 b87b7aa3 db36dfff d6b0f9bb 7878d7a7 6c13",
+			"81c8000d 4d617273 d50ae4d1 f5ce5d30 4ba297e4 7d470c28 2c3ece5d bffe0a50" +
+				" a2eaa5c1 110555be 8415f658 c61de047 6f1b6fad 1d1eb30c 4446839f 57ff6f6c b26ac3be 800005d4",
+			"81c8000d 4d617273 4e545031 4e545032 52545020 0000042a 0000e930 4c756e61" +
+				" deadbeef deadbeef deadbeef deadbeef deadbeef 91db4afb feee5a97 8fab4393 ed2615fe 000005d4"},
+	}
+	salt := unhex("517569642070726f2071756f")
+	for _, test := range tests {
+		profile, _ := LookupSRTPProfile(test.profile)
+		sender := newSessionKeyContext(t, profile, unhex(test.key), salt)
+		receiver := newSessionKeyContext(t, profile, unhex(test.key), salt)
+
+		out, err := sender.EncryptRTP(nil, unhex(rtpPacket))
+		if err != nil || !bytes.Equal(out, unhex(test.srtp)) {
+			t.Errorf("%s: srtp %x, %v", test.profile, out, err)
+		}
+		if out, err = receiver.DecryptRTP(nil, unhex(test.srtp)); err != nil || !bytes.Equal(out, unhex(rtpPacket)) {
+			t.Errorf("%s: decrypted rtp %x, %v", test.profile, out, err)
+		}
+
+		// the examples use srtcp index 0x5d4.
+		sender.source(0x4d617273).rtcpIndex = 0x5d4
+		if out, err = sender.EncryptRTCP(nil, unhex(rtcpPacket)); err != nil || !bytes.Equal(out, unhex(test.srtcp)) {
+			t.Errorf("%s: srtcp %x, %v", test.profile, out, err)
+		}
+		if out, err = receiver.DecryptRTCP(nil, unhex(test.srtcp)); err != nil || !bytes.Equal(out, unhex(rtcpPacket)) {
+			t.Errorf("%s: decrypted rtcp %x, %v", test.profile, out, err)
+		}
+		receiver = newSessionKeyContext(t, profile, unhex(test.key), salt)
+		if out, err = receiver.DecryptRTCP(nil, unhex(test.authenticated)); err != nil || !bytes.Equal(out, unhex(rtcpPacket)) {
+			t.Errorf("%s: authenticated rtcp %x, %v", test.profile, out, err)
+		}
+	}
+}
+
+func newTestContexts(t *testing.T, profile SRTPProfile) (sender, receiver *SRTPContext) {
+	key := bytes.Repeat([]byte{0x11}, profile.KeyLen)
+	salt := bytes.Repeat([]byte{0x22}, profile.SaltLen)
+	sender, err := NewSRTPContext(profile, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, _ = NewSRTPContext(profile, key, salt)
+	return
+}
+
+func testRTP(seq uint16) []byte {
+	buf, _ := Packet{Version: RTPVERSION, SequenceNumber: uint(seq), Timestamp: 3000, SyncSource: 0xcafe, Payload: []byte("secret media payload")}.Marshal()
+	return buf
+}
+
+func TestSRTPRoundTrip(t *testing.T) {
+	rtcpPacket := []byte{0x80, 201, 0, 1, 0, 0, 0xca, 0xfe}
+	for _, profile := range srtpProfiles {
+		sender, receiver := newTestContexts(t, profile)
+		for _, mki := range [][]byte{nil, {0, 0, 0, 1}} {
+			sender.MKI, receiver.MKI = mki, mki
+			plain := testRTP(uint16(len(mki)))
+			protected, err := sender.EncryptRTP(nil, plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(protected) != len(plain)+profile.AuthTagLen+len(mki) || bytes.Contains(protected, []byte("secret")) {
+				t.Errorf("%s: protected % x", profile.Name, protected)
+			}
+			got, err := receiver.DecryptRTP(protected, protected)
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("%s: decrypted % x: %v", profile.Name, got, err)
+			}
+
+			protected, err = sender.EncryptRTCP(nil, rtcpPacket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = receiver.DecryptRTCP(nil, protected)
+			if err != nil || !bytes.Equal(got, rtcpPacket) {
+				t.Errorf("%s: decrypted rtcp % x: %v", profile.Name, got, err)
+			}
+		}
+	}
+}
+
+func TestSRTPRejects(t *testing.T) {
+	for _, profile := range srtpProfiles {
+		sender, receiver := newTestContexts(t, profile)
+		protected, _ := sender.EncryptRTP(nil, testRTP(7))
+		if _, err := receiver.DecryptRTP(nil, protected); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := receiver.DecryptRTP(nil, protected); err != ErrSRTPReplayed {
+			t.Errorf("%s: replay: %v", profile.Name, err)
+		}
+
+		protected, _ = sender.EncryptRTP(nil, testRTP(8))
+		protected[len(protected)/2] ^= 1
+		if _, err := receiver.DecryptRTP(nil, protected); err != ErrSRTPAuthFailed {
+			t.Errorf("%s: tampered: %v", profile.Name, err)
+		}
+
+		other, _ := NewSRTPContext(profile, bytes.Repeat([]byte{0x33}, profile.KeyLen), bytes.Repeat([]byte{0x22}, profile.SaltLen))
+		protected, _ = other.EncryptRTCP(nil, []byte{0x80, 201, 0, 1, 0, 0, 0xca, 0xfe})
+		if _, err := receiver.DecryptRTCP(nil, protected); err != ErrSRTPAuthFailed {
+			t.Errorf("%s: rtcp with the wrong key: %v", profile.Name, err)
+		}
+	}
+}
+
+func TestSRTPRolloverCounter(t *testing.T) {
+	profile, _ := LookupSRTPProfile("AES_CM_128_HMAC_SHA1_80")
+	sender, receiver := newTestContexts(t, profile)
+	// across the wrap, with a packet of the previous cycle arriving late.
+	seqs := []uint16{65533, 65535, 0, 65534, 1, 2}
+	for _, seq := range seqs {
+		protected, _ := sender.EncryptRTP(nil, testRTP(seq))
+		got, err := receiver.DecryptRTP(nil, protected)
+		if err != nil || !bytes.Equal(got, testRTP(seq)) {
+			t.Fatalf("seq %d: %v", seq, err)
+		}
+	}
+	if roc := receiver.ROC(0xcafe); roc != 1 {
+		t.Errorf("roc %d", roc)
+	}
+
+	// a receiver joining late learns the rollover counter from key management.
+	late, _ := NewSRTPContext(profile, bytes.Repeat([]byte{0x11}, 16), bytes.Repeat([]byte{0x22}, 14))
+	protected, _ := sender.EncryptRTP(nil, testRTP(3))
+	if _, err := late.DecryptRTP(nil, protected); err != ErrSRTPAuthFailed {
+		t.Errorf("without roc: %v", err)
+	}
+	late.SetROC(0xcafe, 1)
+	if _, err := late.DecryptRTP(nil, protected); err != nil {
+		t.Errorf("with roc: %v", err)
+	}
+}
+
+func TestSRTPContextSDES(t *testing.T) {
+	key := make([]byte, 30)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	inline := "inline:" + base64.StdEncoding.EncodeToString(key) + "|2^20|1:4"
+	c, err := NewSRTPContextSDES("AES_CM_128_HMAC_SHA1_32", inline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Profile.AuthTagLen != 4 || !bytes.Equal(c.MKI, []byte{0, 0, 0, 1}) {
+		t.Errorf("context %+v", c)
+	}
+	if _, err := NewSRTPContextSDES("AES_CM_128_HMAC_SHA1_80", "inline:AAAA"); err != ErrSRTPKeyLength {
+		t.Errorf("short key: %v", err)
+	}
+	if _, err := NewSRTPContextSDES("F8_128_HMAC_SHA1_80", inline); err != ErrSRTPProfile {
+		t.Errorf("unknown suite: %v", err)
+	}
+}
+
+// buildMIKEY builds a MIKEY message with a clear TGK, like RTSPS servers send.
+func buildMIKEY(tgk []byte, ssrc, roc uint32) []byte {
+	var b []byte
+	// common header and its srtp crypto session map.
+	b = append(b, 1, 0, mikeyPayloadT, 0)
+	b = binary.BigEndian.AppendUint32(b, 0x12345678)
+	b = append(b, 1, mikeyMapSRTP, 0)
+	b = binary.BigEndian.AppendUint32(b, ssrc)
+	b = binary.BigEndian.AppendUint32(b, roc)
+	// T, NTP-UTC.
+	b = append(b, mikeyPayloadRAND, 0)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 1)
+	// RAND.
+	b = append(b, mikeyPayloadSP, 16)
+	b = append(b, bytes.Repeat([]byte{0xab}, 16)...)
+	// SP, policy 0 for SRTP: AES-CM, 16 byte keys and 4 byte tags.
+	params := []byte{mikeySPEncryptionAlg, 1, 1, mikeySPEncryptionLen, 1, 16, mikeySPAuthTagLen, 1, 4}
+	b = append(b, mikeyPayloadKEMAC, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(params)))
+	b = append(b, params...)
+	// KEMAC, NULL encryption, one key data sub-payload and no MAC.
+	keyData := []byte{mikeyPayloadLast, mikeyKeyTGK << 4}
+	keyData = binary.BigEndian.AppendUint16(keyData, uint16(len(tgk)))
+	keyData = append(keyData, tgk...)
+	b = append(b, mikeyPayloadLast, mikeyEncryptionNull)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(keyData)))
+	b = append(b, keyData...)
+	return append(b, 0)
+}
+
+func TestParseMIKEY(t *testing.T) {
+	tgk := bytes.Repeat([]byte{0x5a}, 16)
+	m, err := ParseMIKEY(buildMIKEY(tgk, 0xcafe, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CSBID != 0x12345678 || len(m.CryptoSessions) != 1 || m.CryptoSessions[0].ROC != 2 ||
+		m.KeyType != mikeyKeyTGK || !bytes.Equal(m.Key, tgk) || len(m.Rand) != 16 {
+		t.Fatalf("mikey %+v", m)
+	}
+	c, err := m.SRTPContext(0xcafe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Profile.Name != "AES_CM_128_HMAC_SHA1_32" || c.ROC(0xcafe) != 2 {
+		t.Errorf("context %+v roc %d", c.Profile, c.ROC(0xcafe))
+	}
+
+	// the sender derives the same keys.
+	sender, _ := m.SRTPContext(0xcafe)
+	protected, _ := sender.EncryptRTP(nil, testRTP(9))
+	if _, err := c.DecryptRTP(nil, protected); err != nil {
+		t.Errorf("decrypt: %v", err)
+	}
+
+	msg := buildMIKEY(tgk, 0xcafe, 2)
+	if _, err := ParseMIKEY(msg[:len(msg)-5]); err != ErrMIKEYTruncated {
+		t.Errorf("truncated: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package rtp
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/solomondong/rtsp/rtcp"
+)
+
+// seenWindow is how many of the latest sequence numbers are remembered to
+// tell duplicates from reordered packets.
+const seenWindow = 1024
+
+// SourceStats holds the reception statistics of one source.
+type SourceStats struct {
+	SSRC      uint32
+	StreamIdx uint
+
+	PacketsReceived uint64
+	BytesReceived   uint64
+	// PacketsExpected and PacketsLost follow RFC 3550 appendix A.3, lost
+	// may be negative when duplicates are received.
+	PacketsExpected uint64
+	PacketsLost     int64
+	// HighestSequence is the highest extended sequence number received.
+	HighestSequence uint64
+	// Jitter is the interarrival jitter of RFC 3550 appendix A.8, in
+	// timestamp units.
+	Jitter     float64
+	Duplicates uint64
+	Reordered  uint64
+	// Bitrate is measured over about the last second, in bits per second.
+	Bitrate float64
+	// RoundTripTime is the latest round trip time to the source, measured
+	// with the rtcp the receiver sends, zero until measured.
+	RoundTripTime time.Duration
+
+	LastPacket time.Time
+}
+
+// sourceStats tracks a source while packets are received.
+type sourceStats struct {
+	SourceStats
+	seq       SequenceTracker
+	ts        TimestampUnwrapper
+	clockRate int
+
+	start    time.Time
+	transit  int64
+	hasTrans bool
+	seen     [seenWindow / 64]uint64
+
+	windowStart time.Time
+	windowBytes uint64
+
+	// the counts at the previous reception report.
+	expectedPrior uint64
+	receivedPrior uint64
+}
+
+// senderReport is the last sender report of a source.
+type senderReport struct {
+	ntpTime uint64
+	arrival time.Time
+}
+
+// ReceiverStats gathers the reception statistics of the rtp packets of a
+// session by SSRC. It is safe for concurrent use.
+type ReceiverStats struct {
+	mu      sync.Mutex
+	sources map[uint32]*sourceStats
+	reports map[uint32]senderReport
+}
+
+// NewReceiverStats creates empty receiver statistics.
+func NewReceiverStats() *ReceiverStats {
+	return &ReceiverStats{
+		sources: make(map[uint32]*sourceStats),
+		reports: make(map[uint32]senderReport),
+	}
+}
+
+// Update accounts for a packet received at arrival, clockRate is the rate
+// of its timestamps.
+func (r *ReceiverStats) Update(packet Packet, arrival time.Time, clockRate int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ssrc := uint32(packet.SyncSource)
+	s, ok := r.sources[ssrc]
+	if !ok {
+		s = &sourceStats{clockRate: clockRate, start: arrival, windowStart: arrival}
+		s.SSRC = ssrc
+		r.sources[ssrc] = s
+	}
+	s.update(packet, arrival)
+}
+
+func (s *sourceStats) update(packet Packet, arrival time.Time) {
+	s.StreamIdx = packet.StreamIdx
+	s.LastPacket = arrival
+	size := uint64(packet.MarshalSize())
+
+	highest := s.seq.Extended()
+	extended, valid := s.seq.Update(uint16(packet.SequenceNumber))
+	if !valid {
+		return
+	}
+	if s.seq.Received == 1 {
+		// the tracker (re)started, so do the counters depending on it.
+		s.seen = [seenWindow / 64]uint64{}
+		s.hasTrans = false
+		s.expectedPrior, s.receivedPrior = 0, 0
+		highest = extended
+	}
+
+	if extended+seenWindow > highest && extended <= highest && s.seq.Received > 1 {
+		if s.isSeen(extended) {
+			s.Duplicates++
+		} else if extended < highest {
+			s.Reordered++
+		}
+	}
+	s.markSeen(extended)
+
+	s.PacketsReceived++
+	s.BytesReceived += size
+	s.HighestSequence = s.seq.Extended()
+	s.PacketsExpected = s.HighestSequence - s.seq.BaseSeq + 1
+	s.PacketsLost = int64(s.PacketsExpected) - int64(s.seq.Received)
+
+	// interarrival jitter, the arrival time is converted to timestamp units.
+	if s.clockRate > 0 {
+		elapsed := arrival.Sub(s.start)
+		rate := int64(s.clockRate)
+		arrivalUnits := int64(elapsed/time.Second)*rate + int64(elapsed%time.Second)*rate/int64(time.Second)
+		transit := arrivalUnits - s.ts.Unwrap(uint32(packet.Timestamp))
+		if s.hasTrans {
+			d := transit - s.transit
+			if d < 0 {
+				d = -d
+			}
+			s.Jitter += (float64(d) - s.Jitter) / 16
+		}
+		s.transit = transit
+		s.hasTrans = true
+	}
+
+	s.windowBytes += size
+	if elapsed := arrival.Sub(s.windowStart); elapsed >= time.Second {
+		s.Bitrate = float64(s.windowBytes*8) / elapsed.Seconds()
+		s.windowStart = arrival
+		s.windowBytes = 0
+	}
+}
+
+func (s *sourceStats) isSeen(extended uint64) bool {
+	i := extended % seenWindow
+	return s.seen[i/64]&(1<<(i%64)) != 0
+}
+
+// markSeen remembers a sequence number, forgetting the one a window before.
+func (s *sourceStats) markSeen(extended uint64) {
+	if extended > s.HighestSequence {
+		// clear the slots skipped over by a gap.
+		for seq := s.HighestSequence + 1; seq < extended && seq < s.HighestSequence+seenWindow; seq++ {
+			j := seq % seenWindow
+			s.seen[j/64] &^= 1 << (j % 64)
+		}
+	}
+	i := extended % seenWindow
+	s.seen[i/64] |= 1 << (i % 64)
+}
+
+// Stats returns the statistics of every source seen, ordered by SSRC.
+func (r *ReceiverStats) Stats() []SourceStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make([]SourceStats, 0, len(r.sources))
+	for _, s := range r.sources {
+		stats = append(stats, s.SourceStats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].SSRC < stats[j].SSRC })
+	return stats
+}
+
+// SenderReport records the NTP time of a sender report of a source arrived
+// at arrival, for the LSR and DLSR of the reception reports.
+func (r *ReceiverStats) SenderReport(ssrc uint32, ntpTime uint64, arrival time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports[ssrc] = senderReport{ntpTime: ntpTime, arrival: arrival}
+}
+
+// RoundTrip records the round trip time to a source.
+func (r *ReceiverStats) RoundTrip(ssrc uint32, rtt time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.sources[ssrc]; ok {
+		s.RoundTripTime = rtt
+	}
+}
+
+// ReceptionReports returns the report blocks, at now, of the sources of a
+// stream that sent packets since the previous call, as RFC 3550 appendix
+// A.3 computes them.
+func (r *ReceiverStats) ReceptionReports(streamIdx uint, now time.Time) []rtcp.ReceptionReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var reports []rtcp.ReceptionReport
+	for ssrc, s := range r.sources {
+		if s.StreamIdx != streamIdx {
+			continue
+		}
+		received := s.seq.Received
+		expectedInterval := int64(s.PacketsExpected - s.expectedPrior)
+		receivedInterval := int64(received - s.receivedPrior)
+		if receivedInterval <= 0 {
+			continue
+		}
+		s.expectedPrior = s.PacketsExpected
+		s.receivedPrior = received
+
+		report := rtcp.ReceptionReport{
+			SSRC:         ssrc,
+			LastSequence: uint32(s.HighestSequence),
+			Jitter:       uint32(s.Jitter),
+		}
+		if lostInterval := expectedInterval - receivedInterval; lostInterval > 0 && expectedInterval > 0 {
+			report.FractionLost = uint8((lostInterval << 8) / expectedInterval)
+		}
+		switch {
+		case s.PacketsLost > 0x7fffff:
+			report.TotalLost = 0x7fffff
+		case s.PacketsLost < -0x800000:
+			report.TotalLost = -0x800000
+		default:
+			report.TotalLost = int32(s.PacketsLost)
+		}
+		if sr, ok := r.reports[ssrc]; ok {
+			report.LastSenderReport = uint32(sr.ntpTime >> 16)
+			report.Delay = uint32(now.Sub(sr.arrival) * 65536 / time.Second)
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].SSRC < reports[j].SSRC })
+	return reports
+}
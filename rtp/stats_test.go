@@ -0,0 +1,100 @@
+package rtp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReceiverStats(t *testing.T) {
+	r := NewReceiverStats()
+	start := time.Unix(0, 0)
+	payload := make([]byte, 88) // 100 bytes per packet
+
+	// 20ms apart at 8kHz, 4 is lost, 6 duplicated and 8 reordered.
+	for i, seq := range []uint{1, 2, 3, 5, 6, 6, 7, 9, 8, 10} {
+		packet := Packet{SequenceNumber: seq, Timestamp: 1000 + seq*160, SyncSource: 0x1234, Payload: payload, StreamIdx: 1}
+		r.Update(packet, start.Add(time.Duration(i)*20*time.Millisecond), 8000)
+	}
+	// a packet arriving 10ms late makes for some jitter.
+	r.Update(Packet{SequenceNumber: 11, Timestamp: 1000 + 11*160, SyncSource: 0x1234, Payload: payload, StreamIdx: 1},
+		start.Add(210*time.Millisecond), 8000)
+	r.Update(Packet{SequenceNumber: 1, SyncSource: 0x99, Payload: payload}, start, 8000)
+
+	stats := r.Stats()
+	if len(stats) != 2 || stats[0].SSRC != 0x99 || stats[1].SSRC != 0x1234 {
+		t.Fatalf("sources %+v", stats)
+	}
+	s := stats[1]
+	// the first packet is taken while the source is on probation.
+	if s.PacketsReceived != 10 || s.BytesReceived != 1000 || s.StreamIdx != 1 {
+		t.Errorf("received %d packets %d bytes stream %d", s.PacketsReceived, s.BytesReceived, s.StreamIdx)
+	}
+	if s.HighestSequence != 11 || s.PacketsExpected != 10 || s.PacketsLost != 0 {
+		t.Errorf("highest %d expected %d lost %d", s.HighestSequence, s.PacketsExpected, s.PacketsLost)
+	}
+	if s.Duplicates != 1 || s.Reordered != 1 {
+		t.Errorf("duplicates %d reordered %d", s.Duplicates, s.Reordered)
+	}
+	if s.Jitter <= 0 || s.Jitter > 80 {
+		t.Errorf("jitter %v", s.Jitter)
+	}
+	if !s.LastPacket.Equal(start.Add(210 * time.Millisecond)) {
+		t.Errorf("last packet at %v", s.LastPacket)
+	}
+}
+
+func TestReceiverStatsBitrate(t *testing.T) {
+	r := NewReceiverStats()
+	start := time.Unix(0, 0)
+	payload := make([]byte, 1238) // 1250 bytes per packet
+	for i := 0; i <= 100; i++ {
+		packet := Packet{SequenceNumber: uint(i), Timestamp: uint(i * 900), SyncSource: 1, Payload: payload}
+		r.Update(packet, start.Add(time.Duration(i)*10*time.Millisecond), 90000)
+	}
+	// 100 packets of 10000 bits in a second.
+	if bitrate := r.Stats()[0].Bitrate; bitrate < 0.99e6 || bitrate > 1.01e6 {
+		t.Errorf("bitrate %v", bitrate)
+	}
+}
+
+func TestReceptionReports(t *testing.T) {
+	r := NewReceiverStats()
+	start := time.Unix(0, 0)
+	// 4 of 12 packets lost.
+	for i, seq := range []uint{1, 2, 3, 4, 6, 8, 10, 12} {
+		packet := Packet{SequenceNumber: seq, Timestamp: seq * 160, SyncSource: 0x1234, StreamIdx: 1}
+		r.Update(packet, start.Add(time.Duration(i)*20*time.Millisecond), 8000)
+	}
+	r.SenderReport(0x1234, 0x0102030405060708, start)
+
+	reports := r.ReceptionReports(1, start.Add(1500*time.Millisecond))
+	if len(reports) != 1 {
+		t.Fatalf("reports %+v", reports)
+	}
+	report := reports[0]
+	// the first packet is taken while the source is on probation.
+	if report.SSRC != 0x1234 || report.LastSequence != 12 || report.TotalLost != 4 || report.FractionLost != 4*256/11 {
+		t.Errorf("report %+v", report)
+	}
+	if report.LastSenderReport != 0x03040506 || report.Delay != 3*65536/2 {
+		t.Errorf("lsr %x dlsr %d", report.LastSenderReport, report.Delay)
+	}
+
+	r.RoundTrip(0x1234, 150*time.Millisecond)
+	r.RoundTrip(0x99, time.Second)
+	if stats := r.Stats(); len(stats) != 1 || stats[0].RoundTripTime != 150*time.Millisecond {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// nothing received since the last report, and nothing on stream 0.
+	if reports := r.ReceptionReports(1, start); len(reports) != 0 {
+		t.Errorf("reports %+v", reports)
+	}
+	if reports := r.ReceptionReports(0, start); len(reports) != 0 {
+		t.Errorf("reports %+v", reports)
+	}
+	r.Update(Packet{SequenceNumber: 13, SyncSource: 0x1234, StreamIdx: 1}, start.Add(time.Second), 8000)
+	if reports := r.ReceptionReports(1, start); len(reports) != 1 || reports[0].FractionLost != 0 {
+		t.Errorf("reports %+v", reports)
+	}
+}
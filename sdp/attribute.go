@@ -0,0 +1,191 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Attribute is an a= line, split at its first colon. Property attributes
+// such as a=recvonly have no value.
+type Attribute struct {
+	Key   string
+	Value string
+	// EmptyValue tells a colon came without a value, as in a=fmtp:, so the
+	// line is written back as it was.
+	EmptyValue bool
+}
+
+// parseAttribute parses the content of an a= line.
+func parseAttribute(line string) Attribute {
+	kv := strings.SplitN(line, ":", 2)
+	if len(kv) == 1 {
+		return Attribute{Key: kv[0]}
+	}
+	return Attribute{Key: kv[0], Value: kv[1], EmptyValue: kv[1] == ""}
+}
+
+// Attributes are a= lines in the order of the description, an attribute may
+// be repeated.
+type Attributes []Attribute
+
+// Get returns the value of the first attribute with key.
+func (a Attributes) Get(key string) (string, bool) {
+	for _, attribute := range a {
+		if attribute.Key == key {
+			return attribute.Value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns the values of the attributes with key, in order.
+func (a Attributes) Values(key string) []string {
+	var values []string
+	for _, attribute := range a {
+		if attribute.Key == key {
+			values = append(values, attribute.Value)
+		}
+	}
+	return values
+}
+
+// Has tells whether there's an attribute with key.
+func (a Attributes) Has(key string) bool {
+	_, ok := a.Get(key)
+	return ok
+}
+
+// parseTypedAttribute parses the session level attributes that have fields
+// of their own.
+func (s *SessionSection) parseTypedAttribute(attribute Attribute) error {
+	switch attribute.Key {
+	case "extmap":
+		extmap, err := parseExtmap(attribute.Value)
+		if err != nil {
+			return err
+		}
+		s.Extmaps = append(s.Extmaps, extmap)
+	case "key-mgmt":
+		keyMgmt, err := parseKeyMgmt(attribute.Value)
+		if err != nil {
+			return err
+		}
+		s.KeyMgmts = append(s.KeyMgmts, keyMgmt)
+	}
+	return nil
+}
+
+// parseTypedAttribute parses the attributes of the media that have fields of
+// their own, those of the formats included.
+func (m *SessionSectionMedia) parseTypedAttribute(attribute Attribute) error {
+	if err := m.parseFormatAttribute(attribute); err != nil {
+		return err
+	}
+	switch attribute.Key {
+	case "extmap":
+		extmap, err := parseExtmap(attribute.Value)
+		if err != nil {
+			return err
+		}
+		m.Extmaps = append(m.Extmaps, extmap)
+	case "crypto":
+		crypto, err := parseCrypto(attribute.Value)
+		if err != nil {
+			return err
+		}
+		m.Cryptos = append(m.Cryptos, crypto)
+	case "key-mgmt":
+		keyMgmt, err := parseKeyMgmt(attribute.Value)
+		if err != nil {
+			return err
+		}
+		m.KeyMgmts = append(m.KeyMgmts, keyMgmt)
+	case "framerate":
+		framerate, err := strconv.ParseFloat(attribute.Value, 64)
+		if err != nil {
+			return fmt.Errorf("framerate %q invalid", attribute.Value)
+		}
+		m.Framerate = framerate
+	case "ssrc-group":
+		fields := strings.Split(attribute.Value, " ")
+		group := SsrcGroup{Semantics: fields[0]}
+		for _, field := range fields[1:] {
+			ssrc, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return fmt.Errorf("ssrc %q invalid", field)
+			}
+			group.SSRCs = append(group.SSRCs, uint32(ssrc))
+		}
+		m.SsrcGroups = append(m.SsrcGroups, group)
+	case "rtcp-fb":
+		// parseFormatAttribute checked the rtcp-fb.
+		fields := strings.SplitN(attribute.Value, " ", 3)
+		fb := RtcpFb{PayloadType: fields[0], Type: fields[1]}
+		if len(fields) == 3 {
+			fb.Parameter = fields[2]
+		}
+		m.RtcpFbs = append(m.RtcpFbs, fb)
+	}
+	return nil
+}
+
+// Directions of a media.
+const (
+	DirectionSendRecv = "sendrecv"
+	DirectionSendOnly = "sendonly"
+	DirectionRecvOnly = "recvonly"
+	DirectionInactive = "inactive"
+)
+
+// Direction returns the direction attribute of the media, else the one of the
+// session, sendrecv when neither has one.
+func (s SessionSection) Direction(media SessionSectionMedia) string {
+	for _, attributes := range []Attributes{media.Attributes, s.Attributes} {
+		for _, attribute := range attributes {
+			switch attribute.Key {
+			case DirectionSendRecv, DirectionSendOnly, DirectionRecvOnly, DirectionInactive:
+				return attribute.Key
+			}
+		}
+	}
+	return DirectionSendRecv
+}
+
+// Range returns the range attribute of the media, else the one of the
+// session, such as "npt=0-10:00".
+func (s SessionSection) Range(media SessionSectionMedia) (string, bool) {
+	if value, ok := media.Attributes.Get("range"); ok {
+		return value, true
+	}
+	return s.Attributes.Get("range")
+}
+
+// Ssrc defines a ssrc attribute, a property of a source of the media
+// (RFC 5576).
+// a=ssrc:<ssrc-id> <attribute>[:<value>]
+type Ssrc struct {
+	SSRC      uint32
+	Attribute string
+	Value     string
+}
+
+// Ssrcs returns the ssrc attributes of the media, in order, a source usually
+// has several.
+func (m SessionSectionMedia) Ssrcs() []Ssrc {
+	var ssrcs []Ssrc
+	for _, value := range m.Attributes.Values("ssrc") {
+		fields := strings.SplitN(value, " ", 2)
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		ssrc := Ssrc{SSRC: uint32(id)}
+		if len(fields) == 2 {
+			attribute := parseAttribute(fields[1])
+			ssrc.Attribute, ssrc.Value = attribute.Key, attribute.Value
+		}
+		ssrcs = append(ssrcs, ssrc)
+	}
+	return ssrcs
+}
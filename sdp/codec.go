@@ -0,0 +1,85 @@
+package sdp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+// Errors returned by NewMedia
+var (
+	ErrInvalidSPS       = errors.New("sdp: h264 sps invalid")
+	ErrCodecUnsupported = errors.New("sdp: codec unsupported")
+)
+
+// NewMedia describes how a stream of codec is carried over rtp: its rtpmap
+// and fmtp attributes and the fields parsed from them. Codecs with a static
+// payload type use it, the others take payloadType. The control attribute is
+// left to the caller.
+func NewMedia(codec av.CodecData, payloadType int) (media SessionSectionMedia, err error) {
+	media = SessionSectionMedia{Type: "video", Procotol: "RTP/AVP"}
+	if codec.Type().IsAudio() {
+		media.Type = "audio"
+	}
+
+	channels := 1
+	var fmtp string
+	switch codec.Type() {
+	case av.H264:
+		h264, ok := codec.(h264parser.CodecData)
+		if !ok {
+			return media, ErrCodecUnsupported
+		}
+		sps, pps := h264.SPS(), h264.PPS()
+		if len(sps) < 4 {
+			return media, ErrInvalidSPS
+		}
+		media.PayloadType, media.CodecType, media.TimeScale = payloadType, "H264", 90000
+		media.SpropParameterSets = [][]byte{sps, pps}
+		fmtp = fmt.Sprintf("packetization-mode=1;profile-level-id=%s;sprop-parameter-sets=%s,%s",
+			hex.EncodeToString(sps[1:4]), base64.StdEncoding.EncodeToString(sps), base64.StdEncoding.EncodeToString(pps))
+
+	case av.AAC:
+		aac, ok := codec.(aacparser.CodecData)
+		if !ok {
+			return media, ErrCodecUnsupported
+		}
+		media.PayloadType, media.CodecType, media.TimeScale = payloadType, "MPEG4-GENERIC", aac.SampleRate()
+		channels = aac.ChannelLayout().Count()
+		media.Config = aac.MPEG4AudioConfigBytes()
+		media.SizeLength, media.IndexLength = 13, 3
+		fmtp = fmt.Sprintf("streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=%s",
+			hex.EncodeToString(media.Config))
+
+	case av.PCM_MULAW:
+		media.PayloadType, media.CodecType, media.TimeScale = 0, "PCMU", 8000
+
+	case av.PCM_ALAW:
+		media.PayloadType, media.CodecType, media.TimeScale = 8, "PCMA", 8000
+
+	default:
+		return media, ErrCodecUnsupported
+	}
+
+	media.Rtpmap = media.PayloadType
+	media.PayloadTypes = []int{media.PayloadType}
+	media.addFormat(media.PayloadType)
+	rtpmap := fmt.Sprintf("%d %s/%d", media.PayloadType, media.CodecType, media.TimeScale)
+	if channels > 1 {
+		rtpmap += "/" + strconv.Itoa(channels)
+	}
+	media.Attributes = append(media.Attributes, Attribute{Key: "rtpmap", Value: rtpmap})
+	if fmtp != "" {
+		media.Attributes = append(media.Attributes, Attribute{Key: "fmtp", Value: strconv.Itoa(media.PayloadType) + " " + fmtp})
+	}
+	for _, attribute := range media.Attributes {
+		media.parseFormatAttribute(attribute)
+	}
+	return media, nil
+}
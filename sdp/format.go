@@ -0,0 +1,233 @@
+package sdp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Format is one of the payload types of a media, as its rtpmap, fmtp and
+// rtcp-fb attributes describe it.
+// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<channels>]
+// a=fmtp:<payload type> <format parameters>
+type Format struct {
+	PayloadType  int
+	EncodingName string
+	ClockRate    int
+	// Channels is 1 for audio when the rtpmap doesn't give it, 0 for video.
+	Channels int
+	// Fmtp is the format parameters as written, Parameters has them split
+	// at ";" with the names in lower case.
+	Fmtp       string
+	Parameters map[string]string
+	// RtcpFbs are the feedback messages of the format, including those
+	// given for all formats with "*".
+	RtcpFbs []RtcpFb
+
+	// These parameters are explicit
+	Config             []byte
+	SpropParameterSets [][]byte
+	SizeLength         int
+	IndexLength        int
+}
+
+// staticFormats are the static payload types of RFC 3551, media may use them
+// without a rtpmap.
+var staticFormats = map[int]Format{
+	0:  {EncodingName: "PCMU", ClockRate: 8000, Channels: 1},
+	3:  {EncodingName: "GSM", ClockRate: 8000, Channels: 1},
+	4:  {EncodingName: "G723", ClockRate: 8000, Channels: 1},
+	8:  {EncodingName: "PCMA", ClockRate: 8000, Channels: 1},
+	9:  {EncodingName: "G722", ClockRate: 8000, Channels: 1},
+	10: {EncodingName: "L16", ClockRate: 44100, Channels: 2},
+	11: {EncodingName: "L16", ClockRate: 44100, Channels: 1},
+	14: {EncodingName: "MPA", ClockRate: 90000},
+	18: {EncodingName: "G729", ClockRate: 8000, Channels: 1},
+	26: {EncodingName: "JPEG", ClockRate: 90000},
+	32: {EncodingName: "MPV", ClockRate: 90000},
+	33: {EncodingName: "MP2T", ClockRate: 90000},
+}
+
+// Format finds the format of a payload type of the media.
+func (m SessionSectionMedia) Format(payloadType int) (Format, bool) {
+	for _, format := range m.Formats {
+		if format.PayloadType == payloadType {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// format finds the format of a payload type, adding it when the m= line
+// doesn't list it.
+func (m *SessionSectionMedia) format(payloadType int) *Format {
+	for i := range m.Formats {
+		if m.Formats[i].PayloadType == payloadType {
+			return &m.Formats[i]
+		}
+	}
+	m.addFormat(payloadType)
+	return &m.Formats[len(m.Formats)-1]
+}
+
+// addFormat adds a payload type of the m= line.
+func (m *SessionSectionMedia) addFormat(payloadType int) {
+	format := staticFormats[payloadType]
+	format.PayloadType = payloadType
+	format.Parameters = make(map[string]string)
+	m.Formats = append(m.Formats, format)
+}
+
+// parseFormatAttribute adds an attribute of the media to the formats it
+// describes, the others are left.
+func (m *SessionSectionMedia) parseFormatAttribute(attribute Attribute) error {
+	switch attribute.Key {
+	case "rtpmap":
+		fields := strings.SplitN(attribute.Value, " ", 2)
+		if len(fields) != 2 {
+			return errors.New("want <payload type> <encoding name>/<clock rate>")
+		}
+		payloadType, err := parsePayloadType(fields[0])
+		if err != nil {
+			return err
+		}
+		encoding := strings.Split(strings.TrimSpace(fields[1]), "/")
+		if len(encoding) < 2 || len(encoding) > 3 {
+			return errors.New("want <payload type> <encoding name>/<clock rate>")
+		}
+		clockRate, err := strconv.Atoi(encoding[1])
+		if err != nil {
+			return fmt.Errorf("clock rate %q invalid", encoding[1])
+		}
+		channels := 0
+		if len(encoding) == 3 {
+			if channels, err = strconv.Atoi(encoding[2]); err != nil {
+				return fmt.Errorf("channels %q invalid", encoding[2])
+			}
+		} else if m.Type == "audio" {
+			channels = 1
+		}
+		format := m.format(payloadType)
+		format.EncodingName, format.ClockRate, format.Channels = encoding[0], clockRate, channels
+
+	case "fmtp":
+		fields := strings.SplitN(attribute.Value, " ", 2)
+		if len(fields) != 2 {
+			return errors.New("want <payload type> <format parameters>")
+		}
+		payloadType, err := parsePayloadType(fields[0])
+		if err != nil {
+			return err
+		}
+		format := m.format(payloadType)
+		format.Fmtp = fields[1]
+		// the parameters that can be parsed are kept.
+		for _, param := range strings.Split(fields[1], ";") {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if kv[0] == "" {
+				continue
+			}
+			name, value := strings.ToLower(kv[0]), ""
+			if len(kv) == 2 {
+				value = strings.TrimSpace(kv[1])
+			}
+			format.Parameters[name] = value
+			var e error
+			switch name {
+			case "config":
+				format.Config, e = hex.DecodeString(value)
+			case "sizelength":
+				format.SizeLength, e = strconv.Atoi(value)
+			case "indexlength":
+				format.IndexLength, e = strconv.Atoi(value)
+			case "sprop-parameter-sets":
+				format.SpropParameterSets = nil
+				for _, set := range strings.Split(value, ",") {
+					nalu, err := base64.StdEncoding.DecodeString(set)
+					if err != nil {
+						e = err
+						continue
+					}
+					format.SpropParameterSets = append(format.SpropParameterSets, nalu)
+				}
+			}
+			if e != nil && err == nil {
+				err = fmt.Errorf("%s %q invalid", name, value)
+			}
+		}
+		return err
+
+	case "rtcp-fb":
+		fields := strings.SplitN(attribute.Value, " ", 3)
+		if len(fields) < 2 {
+			return errors.New("want <payload type> <type> [<parameter>]")
+		}
+		fb := RtcpFb{PayloadType: fields[0], Type: fields[1]}
+		if len(fields) == 3 {
+			fb.Parameter = fields[2]
+		}
+		if fb.PayloadType == "*" {
+			for i := range m.Formats {
+				m.Formats[i].RtcpFbs = append(m.Formats[i].RtcpFbs, fb)
+			}
+			return nil
+		}
+		payloadType, err := parsePayloadType(fb.PayloadType)
+		if err != nil {
+			return err
+		}
+		format := m.format(payloadType)
+		format.RtcpFbs = append(format.RtcpFbs, fb)
+	}
+	return nil
+}
+
+// describeFormats fills the fields of the media that follow from its
+// formats: the payload types of the retransmissions and the redundancy, and
+// the fields of the first format carrying the media itself.
+func (m *SessionSectionMedia) describeFormats() {
+	primary := -1
+	for i, format := range m.Formats {
+		switch strings.ToLower(format.EncodingName) {
+		case "rtx":
+			if m.Rtx == nil {
+				m.Rtx = make(map[int]int)
+			}
+			// -1 when the fmtp doesn't tell the payload type retransmitted.
+			apt, err := strconv.Atoi(format.Parameters["apt"])
+			if err != nil {
+				apt = -1
+			}
+			m.Rtx[format.PayloadType] = apt
+		case "red":
+			m.RedPayloadType = format.PayloadType
+		case "ulpfec":
+			m.UlpfecPayloadType = format.PayloadType
+		case "flexfec":
+			m.FlexfecPayloadType = format.PayloadType
+		default:
+			if primary < 0 {
+				primary = i
+			}
+		}
+	}
+	if primary < 0 {
+		return
+	}
+	format := m.Formats[primary]
+	m.Rtpmap, m.CodecType, m.TimeScale = format.PayloadType, format.EncodingName, format.ClockRate
+	m.Config, m.SpropParameterSets = format.Config, format.SpropParameterSets
+	m.SizeLength, m.IndexLength = format.SizeLength, format.IndexLength
+}
+
+// parsePayloadType parses the payload type a format attribute is about.
+func parsePayloadType(field string) (int, error) {
+	payloadType, err := strconv.Atoi(field)
+	if err != nil || payloadType < 0 || payloadType > 127 {
+		return 0, fmt.Errorf("payload type %q invalid", field)
+	}
+	return payloadType, nil
+}
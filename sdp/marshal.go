@@ -0,0 +1,90 @@
+package sdp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Marshal writes the session description in the field order of RFC 8866
+// section 5, with CRLF line endings. The a= lines are written from
+// Attributes, in order; the fields and Formats parsed from them are not
+// written on their own. The m= line lists PayloadTypes, or PayloadType when
+// there are none. A session without a t= line gets "t=0 0", as the field is
+// required.
+func (s SessionSection) Marshal() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "v=%d\r\n", s.Version)
+	o := s.Originator
+	fmt.Fprintf(&b, "o=%s %s %s %s %s %s\r\n", o.UserName, o.SessionID, o.Version, o.NetworkType, o.AddressType, o.Address)
+	fmt.Fprintf(&b, "s=%s\r\n", s.SessionName)
+	writeField(&b, "i", s.SessionInformation)
+	writeField(&b, "u", s.URI)
+	for _, email := range s.Emails {
+		writeField(&b, "e", email)
+	}
+	for _, phone := range s.Phones {
+		writeField(&b, "p", phone)
+	}
+	writeConnection(&b, s.ConnectionInformation)
+	for _, bandwidth := range s.BandwidthInformation {
+		writeField(&b, "b", bandwidth)
+	}
+	if len(s.Time) == 0 {
+		b.WriteString("t=0 0\r\n")
+	}
+	for _, t := range s.Time {
+		fmt.Fprintf(&b, "t=%s %s\r\n", t.StartTime, t.EndTime)
+	}
+	for _, repeat := range s.Repeat {
+		writeField(&b, "r", repeat)
+	}
+	writeField(&b, "z", s.TimeZone)
+	writeField(&b, "k", s.EncryptionKey)
+	writeAttributes(&b, s.Attributes)
+
+	for _, media := range s.Medias {
+		formats := strconv.Itoa(media.PayloadType)
+		if len(media.PayloadTypes) > 0 {
+			fields := make([]string, len(media.PayloadTypes))
+			for i, payloadType := range media.PayloadTypes {
+				fields[i] = strconv.Itoa(payloadType)
+			}
+			formats = strings.Join(fields, " ")
+		}
+		fmt.Fprintf(&b, "m=%s %d %s %s\r\n", media.Type, media.Port, media.Procotol, formats)
+		writeField(&b, "i", media.Title)
+		writeConnection(&b, media.ConnectionInformation)
+		for _, bandwidth := range media.BandwidthInformation {
+			writeField(&b, "b", bandwidth)
+		}
+		writeField(&b, "k", media.EncryptionKey)
+		writeAttributes(&b, media.Attributes)
+	}
+	return []byte(b.String())
+}
+
+// writeField writes a line, unless its value is empty.
+func writeField(b *strings.Builder, field, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s=%s\r\n", field, value)
+	}
+}
+
+// writeConnection writes a c= line, unless the connection is unset.
+func writeConnection(b *strings.Builder, c ConnectionInformation) {
+	if c != (ConnectionInformation{}) {
+		fmt.Fprintf(b, "c=%s %s %s\r\n", c.NetworkType, c.AddressType, c.Address)
+	}
+}
+
+// writeAttributes writes a= lines.
+func writeAttributes(b *strings.Builder, attributes []Attribute) {
+	for _, attribute := range attributes {
+		if attribute.Value == "" && !attribute.EmptyValue {
+			fmt.Fprintf(b, "a=%s\r\n", attribute.Key)
+		} else {
+			fmt.Fprintf(b, "a=%s:%s\r\n", attribute.Key, attribute.Value)
+		}
+	}
+}
@@ -2,9 +2,8 @@ package sdp
 
 import (
 	"bufio"
-	"encoding/base64"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -39,20 +38,128 @@ type SessionSectionRepeat struct {
 	EndTime   string
 }
 
+// Extmap defines an extmap attribute, mapping a rtp header extension id to
+// the URI of the extension.
+// a=extmap:<value>["/"<direction>] <URI> <extensionattributes>
+type Extmap struct {
+	ID         int
+	Direction  string
+	URI        string
+	Attributes string
+}
+
+// parseExtmap parses the value of an extmap attribute.
+func parseExtmap(value string) (extmap Extmap, err error) {
+	fields := strings.SplitN(value, " ", 3)
+	if len(fields) < 2 {
+		return extmap, errors.New("extmap field is wrong")
+	}
+	idParts := strings.SplitN(fields[0], "/", 2)
+	if extmap.ID, err = strconv.Atoi(idParts[0]); err != nil {
+		return
+	}
+	if len(idParts) == 2 {
+		extmap.Direction = idParts[1]
+	}
+	extmap.URI = fields[1]
+	if len(fields) == 3 {
+		extmap.Attributes = fields[2]
+	}
+	return
+}
+
+// Crypto defines a crypto attribute, the SDES keys of a SRTP stream (RFC 4568).
+// a=crypto:<tag> <crypto-suite> <key-params> [<session-params>]
+type Crypto struct {
+	Tag           int
+	Suite         string
+	KeyParams     string
+	SessionParams string
+}
+
+// parseCrypto parses the value of a crypto attribute.
+func parseCrypto(value string) (crypto Crypto, err error) {
+	fields := strings.SplitN(value, " ", 4)
+	if len(fields) < 3 {
+		return crypto, errors.New("crypto field is wrong")
+	}
+	if crypto.Tag, err = strconv.Atoi(fields[0]); err != nil {
+		return
+	}
+	crypto.Suite = fields[1]
+	crypto.KeyParams = fields[2]
+	if len(fields) == 4 {
+		crypto.SessionParams = fields[3]
+	}
+	return
+}
+
+// KeyMgmt defines a key-mgmt attribute, the message of a key management
+// protocol such as MIKEY, base64 encoded (RFC 4567).
+// a=key-mgmt:<prtcl-id> <keymgmt-data>
+type KeyMgmt struct {
+	Protocol string
+	Data     string
+}
+
+// parseKeyMgmt parses the value of a key-mgmt attribute.
+func parseKeyMgmt(value string) (KeyMgmt, error) {
+	fields := strings.SplitN(value, " ", 2)
+	if len(fields) != 2 {
+		return KeyMgmt{}, errors.New("key-mgmt field is wrong")
+	}
+	return KeyMgmt{Protocol: fields[0], Data: fields[1]}, nil
+}
+
+// RtcpFb defines a rtcp-fb attribute, a feedback message the receiver may
+// send about a payload type, "*" for all of them (RFC 4585).
+// a=rtcp-fb:<payload type> <type> [<parameter>]
+type RtcpFb struct {
+	PayloadType string
+	Type        string
+	Parameter   string
+}
+
+// SsrcGroup defines a ssrc-group attribute, relating the sources of a media
+// such as a FlexFEC source to the one it protects (RFC 5576).
+// a=ssrc-group:<semantics> <ssrc-id> ...
+type SsrcGroup struct {
+	Semantics string
+	SSRCs     []uint32
+}
+
 // SessionSectionMedia defines SessionSectionMedia body
 type SessionSectionMedia struct {
-	Type                  string
-	Port                  int
-	Procotol              string
+	Type     string
+	Port     int
+	Procotol string
+	// PayloadType is the first payload type of the m= line, PayloadTypes
+	// all of them, which Formats describe in the same order.
 	PayloadType           int
+	PayloadTypes          []int
+	Formats               []Format
 	Title                 string
 	ConnectionInformation ConnectionInformation
 	BandwidthInformation  []string
 	EncryptionKey         string
-	BooleanAttributes     map[string]bool
-	KVAttributes          map[string]string
+	// Attributes are the a= lines of the media in order, the fields below
+	// are parsed from them.
+	Attributes Attributes
+	Extmaps    []Extmap
+	Cryptos    []Crypto
+	KeyMgmts   []KeyMgmt
+	RtcpFbs    []RtcpFb
+	// Rtx maps the payload types of the retransmission streams (RFC 4588)
+	// to the payload types they retransmit.
+	Rtx map[int]int
+	// The payload types of the redundancy of the media, 0 when there's none.
+	RedPayloadType     int
+	UlpfecPayloadType  int
+	FlexfecPayloadType int
+	SsrcGroups         []SsrcGroup
 
-	// These attributes are explicit
+	// These attributes are explicit, the fields from Rtpmap on are those of
+	// the first format carrying the media itself.
 	Control            string
 	Framerate          float64
 	Rtpmap             int
@@ -79,9 +186,12 @@ type SessionSection struct {
 	EncryptionKey         string
 	Time                  []SessionSectionTime
 	Repeat                []string
-	BooleanAttributes     map[string]bool
-	KVAttributes          map[string]string
-	Medias                []SessionSectionMedia
+	// Attributes are the session level a= lines in order, the fields below
+	// are parsed from them.
+	Attributes Attributes
+	Extmaps    []Extmap
+	KeyMgmts   []KeyMgmt
+	Medias     []SessionSectionMedia
 }
 
 // v=0
@@ -100,163 +210,236 @@ type SessionSection struct {
 // a=fmtp:96 packetization-mode=1;profile-level-id=64002A;sprop-parameter-sets=Z2QAKqwsaoHgCJ+WbgICAgQA,aO48sAA=
 // a=recvonly
 
-// ParseSdp parses the sdp session content.
+// ParseError is a line of a session description that can't be parsed.
+type ParseError struct {
+	// Line counts from 1, it is 0 for a field missing from the description.
+	Line int
+	// Field is the type of the line, such as "m", or "a=" and the key of an
+	// attribute.
+	Field  string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("sdp: %s: %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("sdp: line %d: %s: %s", e.Line, e.Field, e.Reason)
+}
+
+// parser keeps the problems of the description being parsed.
+type parser struct {
+	strict   bool
+	line     int
+	err      *ParseError
+	warnings []*ParseError
+}
+
+// fail reports a field of the current line that can't be parsed, the first
+// one is the error in strict mode, the others are warnings.
+func (p *parser) fail(field, format string, args ...interface{}) {
+	e := &ParseError{Line: p.line, Field: field, Reason: fmt.Sprintf(format, args...)}
+	if !p.strict {
+		p.warnings = append(p.warnings, e)
+	} else if p.err == nil {
+		p.err = e
+	}
+}
+
+// ParseSdp parses the sdp session content leniently, see Parse.
 func ParseSdp(r io.Reader) (SessionSection, error) {
-	var packet SessionSection
-	packet.BooleanAttributes = make(map[string]bool)
-	packet.KVAttributes = make(map[string]string)
+	packet, _, err := Parse(r, false)
+	return packet, err
+}
+
+// Parse parses the sdp session content. In strict mode the first line that
+// can't be parsed is returned as a *ParseError. Otherwise the line, or the
+// field of it, is left and the parsing goes on, for the cameras writing
+// invalid descriptions: the problems are returned as warnings and only
+// reading r fails.
+func Parse(r io.Reader, strict bool) (packet SessionSection, warnings []*ParseError, err error) {
+	p := &parser{strict: strict}
 	s := bufio.NewScanner(r)
 	mediaSectionStarted := false
+	seen := make(map[string]bool)
 	for s.Scan() {
+		if p.err != nil {
+			break
+		}
+		p.line++
+		if s.Text() == "" {
+			continue
+		}
 		parts := strings.SplitN(s.Text(), "=", 2)
-		if len(parts) == 2 {
-			if len(parts[0]) != 1 {
-				return packet, errors.New("SDP only allows 1-character variables")
-			}
+		if len(parts) != 2 || len(parts[0]) != 1 {
+			p.fail(parts[0], "SDP only allows <type>=<value> lines with 1-character types")
+			continue
+		}
+		if len(seen) == 0 && parts[0] != "v" {
+			p.fail("v", "the description doesn't start with v=")
+		}
+		seen[parts[0]] = true
 
-			switch parts[0] {
-			// version
-			case "v":
-				ver, err := strconv.Atoi(parts[1])
-				if err != nil {
-					return packet, err
-				}
-				packet.Version = ver
-			// owner/creator and session identifier
-			case "o":
-				// o=<username> <session id> <version> <network type> <address type> <address>
-				ogParts := strings.Split(parts[1], " ")
-				if len(ogParts) != 6 {
-					return packet, errors.New("originator field is wrong")
-				}
-				packet.Originator = SessionSectionOriginator{ogParts[0], ogParts[1], ogParts[2], ogParts[3], ogParts[4], ogParts[5]}
-			// session name
-			case "s":
-				packet.SessionName = parts[1]
-			// session information
-			case "i":
-				if !mediaSectionStarted {
-					packet.SessionInformation = parts[1]
-				} else {
-					packet.Medias[len(packet.Medias)-1].Title = parts[1]
-				}
-			// URI of description
-			case "u":
-				packet.URI = parts[1]
-			// email address
-			case "e":
-				packet.Emails = append(packet.Emails, parts[1])
-			// phone numberRtpmap
-			case "p":
-				packet.Phones = append(packet.Phones, parts[1])
-			// connection information - not required if included in all media
-			case "c":
-				cnParts := strings.Split(parts[1], " ")
-				if len(cnParts) != 3 {
-					return packet, errors.New("connection info field is wrong")
-				}
-				if !mediaSectionStarted {
-					packet.ConnectionInformation = ConnectionInformation{cnParts[0], cnParts[1], cnParts[2]}
-				} else {
-					packet.Medias[len(packet.Medias)-1].ConnectionInformation = ConnectionInformation{cnParts[0], cnParts[1], cnParts[2]}
-				}
-			// bandwidth information
-			case "b":
-				// TODO: parse this
-				if !mediaSectionStarted {
-					packet.BandwidthInformation = append(packet.BandwidthInformation, parts[1])
-				} else {
-					packet.Medias[len(packet.Medias)-1].BandwidthInformation = append(packet.Medias[len(packet.Medias)-1].BandwidthInformation, parts[1])
-				}
-			case "t":
-				// TODO: t might occur multiple times...need to see an example in order to learn how to deal with it.
-				tmParts := strings.Split(parts[1], " ")
-				if len(tmParts) != 2 {
-					return packet, errors.New("time field is wrong")
-				}
-				packet.Time = append(packet.Time, SessionSectionTime{tmParts[0], tmParts[1]})
-			case "r":
-				// TODO: need to parse repeats, it may also appear multiple times.
-				packet.Repeat = append(packet.Repeat, parts[1])
-			// time zone.
-			case "z":
-				// TODO: need to parse time zone.
-				packet.TimeZone = parts[1]
-			// encryption keyRtpmap
-			case "k":
-				if !mediaSectionStarted {
-					packet.EncryptionKey = parts[1]
-				} else {
-					packet.Medias[len(packet.Medias)-1].EncryptionKey = parts[1]
+		switch parts[0] {
+		// version
+		case "v":
+			ver, err := strconv.Atoi(parts[1])
+			if err != nil {
+				p.fail("v", "version %q invalid", parts[1])
+				break
+			}
+			packet.Version = ver
+		// owner/creator and session identifier
+		case "o":
+			// o=<username> <session id> <version> <network type> <address type> <address>
+			ogParts := strings.Split(parts[1], " ")
+			if len(ogParts) != 6 {
+				p.fail("o", "want 6 fields, got %d", len(ogParts))
+				break
+			}
+			packet.Originator = SessionSectionOriginator{ogParts[0], ogParts[1], ogParts[2], ogParts[3], ogParts[4], ogParts[5]}
+		// session name
+		case "s":
+			packet.SessionName = parts[1]
+		// session information
+		case "i":
+			if !mediaSectionStarted {
+				packet.SessionInformation = parts[1]
+			} else {
+				packet.Medias[len(packet.Medias)-1].Title = parts[1]
+			}
+		// URI of description
+		case "u":
+			packet.URI = parts[1]
+		// email address
+		case "e":
+			packet.Emails = append(packet.Emails, parts[1])
+		// phone numberRtpmap
+		case "p":
+			packet.Phones = append(packet.Phones, parts[1])
+		// connection information - not required if included in all media
+		case "c":
+			cnParts := strings.Split(parts[1], " ")
+			if len(cnParts) != 3 {
+				p.fail("c", "want 3 fields, got %d", len(cnParts))
+				break
+			}
+			if !mediaSectionStarted {
+				packet.ConnectionInformation = ConnectionInformation{cnParts[0], cnParts[1], cnParts[2]}
+			} else {
+				packet.Medias[len(packet.Medias)-1].ConnectionInformation = ConnectionInformation{cnParts[0], cnParts[1], cnParts[2]}
+			}
+		// bandwidth information
+		case "b":
+			// b=<bwtype>:<bandwidth>
+			bwParts := strings.SplitN(parts[1], ":", 2)
+			if len(bwParts) != 2 {
+				p.fail("b", "want <bwtype>:<bandwidth>")
+				break
+			}
+			if _, err := strconv.Atoi(bwParts[1]); err != nil {
+				p.fail("b", "bandwidth %q invalid", bwParts[1])
+				break
+			}
+			if !mediaSectionStarted {
+				packet.BandwidthInformation = append(packet.BandwidthInformation, parts[1])
+			} else {
+				packet.Medias[len(packet.Medias)-1].BandwidthInformation = append(packet.Medias[len(packet.Medias)-1].BandwidthInformation, parts[1])
+			}
+		case "t":
+			// TODO: t might occur multiple times...need to see an example in order to learn how to deal with it.
+			tmParts := strings.Split(parts[1], " ")
+			if len(tmParts) != 2 {
+				p.fail("t", "want 2 fields, got %d", len(tmParts))
+				break
+			}
+			packet.Time = append(packet.Time, SessionSectionTime{tmParts[0], tmParts[1]})
+		case "r":
+			// TODO: need to parse repeats, it may also appear multiple times.
+			packet.Repeat = append(packet.Repeat, parts[1])
+		// time zone.
+		case "z":
+			// TODO: need to parse time zone.
+			packet.TimeZone = parts[1]
+		// encryption keyRtpmap
+		case "k":
+			if !mediaSectionStarted {
+				packet.EncryptionKey = parts[1]
+			} else {
+				packet.Medias[len(packet.Medias)-1].EncryptionKey = parts[1]
+			}
+		case "a":
+			// the attributes, the typed fields are parsed from them.
+			attribute := parseAttribute(parts[1])
+			var err error
+			if !mediaSectionStarted {
+				packet.Attributes = append(packet.Attributes, attribute)
+				err = packet.parseTypedAttribute(attribute)
+			} else {
+				media := &packet.Medias[len(packet.Medias)-1]
+				media.Attributes = append(media.Attributes, attribute)
+				err = media.parseTypedAttribute(attribute)
+			}
+			if err != nil {
+				p.fail("a="+attribute.Key, "%v", err)
+			}
+		case "m":
+			// the media.
+			// m=<media> <port>[/<number of ports>] <proto> <fmt> ...
+			mediaSectionStarted = true
+			maParts := strings.Split(parts[1], " ")
+			short := len(maParts) < 4
+			if short {
+				p.fail("m", "want at least 4 fields, got %d", len(maParts))
+				// the media is kept for its attributes.
+				for len(maParts) < 4 {
+					maParts = append(maParts, "")
 				}
-			case "a":
-				// the attributes.
-				kv := strings.Split(parts[1], ":")
-				if len(kv) == 1 {
-					if !mediaSectionStarted {
-						packet.BooleanAttributes[kv[0]] = true
-					} else {
-						packet.Medias[len(packet.Medias)-1].BooleanAttributes[kv[0]] = true
-					}
-				} else {
-					if !mediaSectionStarted {
-						packet.KVAttributes[kv[0]] = kv[1]
-					} else {
-						// here we will cater for the special keys.
-						switch kv[0] {
-						case "control":
-							packet.Medias[len(packet.Medias)-1].Control = kv[1]
-						case "framerate":
-							packet.Medias[len(packet.Medias)-1].Framerate, _ = strconv.ParseFloat(kv[1], 64)
-						case "rtpmap":
-							rtpmapVals := strings.Split(kv[1], " ")
-							packet.Medias[len(packet.Medias)-1].Rtpmap, _ = strconv.Atoi(rtpmapVals[0])
-							codecs := strings.Split(rtpmapVals[1], "/")
-							packet.Medias[len(packet.Medias)-1].CodecType = codecs[0]
-							packet.Medias[len(packet.Medias)-1].TimeScale, _ = strconv.Atoi(codecs[1])
-							// 96 packetization-mode=1;profile-level-id=64002A;sprop-parameter-sets=Z2QAKqwsaoHgCJ+WbgICAgQA,aO48sAA=
-						case "fmtp":
-							fmtpVals := strings.Split(kv[1], ";")
-							for _, fmtpVal := range fmtpVals {
-								kvals := strings.SplitN(fmtpVal, "=", 2)
-								if len(kvals) == 2 {
-									switch kvals[0] {
-									case "config":
-										packet.Medias[len(packet.Medias)-1].Config, _ = hex.DecodeString(kvals[1])
-									case "sizelength":
-										packet.Medias[len(packet.Medias)-1].SizeLength, _ = strconv.Atoi(kvals[1])
-									case "indexlength":
-										packet.Medias[len(packet.Medias)-1].IndexLength, _ = strconv.Atoi(kvals[1])
-									case "sprop-parameter-sets":
-										fields := strings.Split(kvals[1], ",")
-										for _, field := range fields {
-											val, _ := base64.StdEncoding.DecodeString(field)
-											packet.Medias[len(packet.Medias)-1].SpropParameterSets = append(packet.Medias[len(packet.Medias)-1].SpropParameterSets, val)
-										}
-									}
-								}
-							}
-						default:
-							packet.Medias[len(packet.Medias)-1].KVAttributes[kv[0]] = kv[1]
-						}
-
+			}
+			media := SessionSectionMedia{Type: maParts[0], Procotol: maParts[2]}
+			port, err := strconv.Atoi(strings.SplitN(maParts[1], "/", 2)[0])
+			if err != nil && !short {
+				p.fail("m", "port %q invalid", maParts[1])
+			}
+			media.Port = port
+			for _, field := range maParts[3:] {
+				pt, err := strconv.Atoi(field)
+				if err != nil || pt < 0 || pt > 127 {
+					// the formats of other protocols aren't payload types.
+					if strings.Contains(media.Procotol, "RTP") {
+						p.fail("m", "payload type %q invalid", field)
 					}
+					continue
 				}
-			case "m":
-				// the media.
-				mediaSectionStarted = true
-				maParts := strings.Split(parts[1], " ")
-				media := SessionSectionMedia{
-					Type:              maParts[0],
-					Procotol:          maParts[2],
-					BooleanAttributes: make(map[string]bool),
-					KVAttributes:      make(map[string]string),
+				if len(media.PayloadTypes) == 0 {
+					media.PayloadType = pt
 				}
-				media.Port, _ = strconv.Atoi(maParts[1])
-				media.PayloadType, _ = strconv.Atoi(maParts[3])
-				packet.Medias = append(packet.Medias, media)
+				media.PayloadTypes = append(media.PayloadTypes, pt)
+				media.addFormat(pt)
+			}
+			packet.Medias = append(packet.Medias, media)
+		default:
+			p.fail(parts[0], "unknown type")
+		}
+	}
+	if err = s.Err(); err != nil {
+		return packet, p.warnings, err
+	}
+	for i := range packet.Medias {
+		packet.Medias[i].Control, _ = packet.Medias[i].Attributes.Get("control")
+		packet.Medias[i].describeFormats()
+	}
+	if p.err == nil {
+		// the missing fields are on no line.
+		p.line = 0
+		for _, field := range []string{"v", "o", "s", "t"} {
+			if !seen[field] {
+				p.fail(field, "missing")
 			}
 		}
 	}
-	return packet, nil
+	if p.err != nil {
+		return packet, nil, p.err
+	}
+	return packet, p.warnings, nil
 }
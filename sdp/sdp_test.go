@@ -0,0 +1,466 @@
+package sdp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nareix/joy4/codec"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+// captures returns the descriptions of testdata, captured from cameras and
+// servers.
+func captures(t testing.TB) map[string][]byte {
+	files, err := filepath.Glob("testdata/*.sdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no testdata")
+	}
+	captures := make(map[string][]byte)
+	for _, file := range files {
+		if captures[file], err = ioutil.ReadFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return captures
+}
+
+// inOrder tells whether the lines of a description end with CRLF and its
+// fields come in the order of RFC 4566 section 5, the order Marshal writes
+// them in, the fields other than e, p, b, t, r and a at most once.
+func inOrder(data []byte) bool {
+	const sessionOrder, mediaOrder = "vosiuepcbtzka", "micbka"
+	order, last := sessionOrder, -1
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\r\n") {
+			return false
+		}
+		field := line[:1]
+		if field == "r" {
+			// repeat times go with their t= line.
+			field = "t"
+		}
+		if field == "m" {
+			order, last = mediaOrder, 0
+			continue
+		}
+		rank := strings.Index(order, field)
+		if rank < last || rank == last && !strings.Contains("epbta", field) {
+			return false
+		}
+		last = rank
+	}
+	return true
+}
+
+// TestMarshalRoundTrip expects Marshal to give the captures of testdata back
+// as is when they are valid and in the order it writes, and the same
+// description otherwise.
+func TestMarshalRoundTrip(t *testing.T) {
+	for file, data := range captures(t) {
+		session, warnings, err := Parse(bytes.NewReader(data), false)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		out := session.Marshal()
+		if len(warnings) == 0 && inOrder(data) && !bytes.Equal(out, data) {
+			t.Errorf("%s: marshaled\n%s\nwant\n%s", file, out, data)
+		}
+		again, warnings, err := Parse(bytes.NewReader(out), false)
+		if err != nil || len(warnings) != 0 {
+			t.Errorf("%s: marshaled description %v %v", file, err, warnings)
+		} else if !bytes.Equal(again.Marshal(), out) {
+			t.Errorf("%s: marshaled\n%s\nthen\n%s", file, out, again.Marshal())
+		}
+	}
+}
+
+func TestMarshalDefaults(t *testing.T) {
+	session := SessionSection{
+		Originator:  SessionSectionOriginator{"-", "1", "1", "IN", "IP4", "0.0.0.0"},
+		SessionName: "-",
+		Attributes:  []Attribute{{Key: "recvonly"}, {Key: "x-unknown", Value: "a:b"}},
+	}
+	want := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\na=recvonly\r\na=x-unknown:a:b\r\n"
+	if out := string(session.Marshal()); out != want {
+		t.Errorf("marshaled %q", out)
+	}
+}
+
+func TestNewMedia(t *testing.T) {
+	sps, _ := base64.StdEncoding.DecodeString("Z2QAKqwsaoHgCJ+WbgICAgQA")
+	pps, _ := base64.StdEncoding.DecodeString("aO48sAA=")
+	h264, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x11, 0x90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media, err := NewMedia(h264, 96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := SessionSection{Medias: []SessionSectionMedia{media}}
+	parsed, err := ParseSdp(bytes.NewReader(session.Marshal()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parsed.Medias[0]
+	if got.Type != "video" || got.PayloadType != 96 || got.CodecType != "H264" || got.TimeScale != 90000 ||
+		len(got.SpropParameterSets) != 2 || !bytes.Equal(got.SpropParameterSets[0], sps) {
+		t.Errorf("h264 media %+v", got)
+	}
+
+	media, err = NewMedia(aac, 97)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.Type != "audio" || media.TimeScale != 48000 || !bytes.Equal(media.Config, []byte{0x11, 0x90}) ||
+		media.Attributes[0] != (Attribute{Key: "rtpmap", Value: "97 MPEG4-GENERIC/48000/2"}) {
+		t.Errorf("aac media %+v", media)
+	}
+
+	media, err = NewMedia(codec.NewPCMMulawCodecData(), 98)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.PayloadType != 0 || len(media.Attributes) != 1 || media.Attributes[0].Value != "0 PCMU/8000" {
+		t.Errorf("pcmu media %+v", media)
+	}
+
+	// pointers report the codec type but carry the codec data elsewhere.
+	if _, err = NewMedia(&h264, 96); err != ErrCodecUnsupported {
+		t.Errorf("h264 pointer: %v", err)
+	}
+	if _, err = NewMedia(&aac, 97); err != ErrCodecUnsupported {
+		t.Errorf("aac pointer: %v", err)
+	}
+}
+
+// browserSdp is an offer of a web browser, with the formats, feedback and
+// retransmissions of WebRTC.
+const browserSdp = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0 1\r\n" +
+	"a=extmap-allow-mixed\r\n" +
+	"a=msid-semantic: WMS stream\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 9 0 8\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=rtcp:9 IN IP4 0.0.0.0\r\n" +
+	"a=ice-ufrag:Hm7c\r\n" +
+	"a=ice-pwd:lX0eCyyZD4E3WCGLB4aBn3Vw\r\n" +
+	"a=fingerprint:sha-256 2E:5C:1A:3F:D6:0B:A4:96:55:E3:57:20:71:AB:0D:E1:93:CF:1E:5B:62:4A:D5:1B:0C:9B:6E:5A:79:F4:11:80\r\n" +
+	"a=setup:actpass\r\n" +
+	"a=mid:0\r\n" +
+	"a=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level\r\n" +
+	"a=sendrecv\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=rtcp-fb:111 transport-cc\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"a=rtpmap:9 G722/8000\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=ssrc:3735928559 cname:4TOk42mSjXCkVIa6\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=rtcp:9 IN IP4 0.0.0.0\r\n" +
+	"a=mid:1\r\n" +
+	"a=extmap:2 http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time\r\n" +
+	"a=sendrecv\r\n" +
+	"a=rtcp-mux\r\n" +
+	"a=rtcp-rsize\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtcp-fb:* nack\r\n" +
+	"a=rtcp-fb:96 goog-remb\r\n" +
+	"a=rtcp-fb:96 nack pli\r\n" +
+	"a=rtcp-fb:96 ccm fir\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:98 H264/90000\r\n" +
+	"a=rtcp-fb:98 nack pli\r\n" +
+	"a=fmtp:98 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f\r\n" +
+	"a=rtpmap:99 rtx/90000\r\n" +
+	"a=fmtp:99 apt=98\r\n" +
+	"a=ssrc-group:FID 2864950151 1234263702\r\n" +
+	"a=ssrc:2864950151 cname:4TOk42mSjXCkVIa6\r\n" +
+	"a=ssrc:1234263702 cname:4TOk42mSjXCkVIa6\r\n"
+
+func TestFormats(t *testing.T) {
+	session, err := ParseSdp(strings.NewReader(browserSdp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio := session.Medias[0]
+	if len(audio.Formats) != 4 || audio.PayloadType != 111 {
+		t.Fatalf("audio formats %+v", audio.Formats)
+	}
+	opus := audio.Formats[0]
+	if opus.EncodingName != "opus" || opus.ClockRate != 48000 || opus.Channels != 2 ||
+		opus.Parameters["useinbandfec"] != "1" || len(opus.RtcpFbs) != 1 || opus.RtcpFbs[0].Type != "transport-cc" {
+		t.Errorf("opus format %+v", opus)
+	}
+	if pcmu, ok := audio.Format(0); !ok || pcmu.EncodingName != "PCMU" || pcmu.ClockRate != 8000 || pcmu.Channels != 1 {
+		t.Errorf("pcmu format %+v", pcmu)
+	}
+
+	video := session.Medias[1]
+	if len(video.PayloadTypes) != 4 || len(video.Formats) != 4 {
+		t.Fatalf("video formats %+v", video.Formats)
+	}
+	h264, ok := video.Format(98)
+	if !ok || h264.EncodingName != "H264" || h264.ClockRate != 90000 || h264.Channels != 0 ||
+		h264.Parameters["profile-level-id"] != "42e01f" || len(h264.RtcpFbs) != 2 {
+		t.Errorf("h264 format %+v", h264)
+	}
+	if vp8, _ := video.Format(96); len(vp8.RtcpFbs) != 4 {
+		t.Errorf("vp8 feedback %+v", vp8.RtcpFbs)
+	}
+	if video.Rtx[97] != 96 || video.Rtx[99] != 98 {
+		t.Errorf("rtx %v", video.Rtx)
+	}
+
+	// static payload types are known without a rtpmap.
+	session, err = ParseSdp(bytes.NewReader([]byte("v=0\r\nm=audio 0 RTP/AVP 8\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, ok := session.Medias[0].Format(8); !ok || format.EncodingName != "PCMA" || format.ClockRate != 8000 {
+		t.Errorf("static format %+v", format)
+	}
+}
+
+func TestPrimaryFormat(t *testing.T) {
+	in := "v=0\r\n" +
+		"m=video 0 RTP/AVP 100 96 97\r\n" +
+		"a=rtpmap:100 red/90000\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z2QAKqwsaoHgCJ+WbgICAgQA,aO48sAA=\r\n" +
+		"a=rtpmap:97 rtx/90000\r\n" +
+		"m=audio 0 RTP/AVP 97 0\r\n" +
+		"a=rtpmap:97 mpeg4-generic/48000/2\r\n" +
+		"a=fmtp:97 streamtype=5;config=1190;sizelength=13;indexlength=3\r\n" +
+		"m=audio 0 RTP/AVP 8\r\n"
+	session, err := ParseSdp(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, audio := session.Medias[0], session.Medias[1]
+	if video.Rtpmap != 96 || video.CodecType != "H264" || video.TimeScale != 90000 ||
+		len(video.SpropParameterSets) != 2 || video.RedPayloadType != 100 || video.Rtx[97] != -1 {
+		t.Errorf("video %+v", video)
+	}
+	if audio.Rtpmap != 97 || audio.CodecType != "mpeg4-generic" || audio.TimeScale != 48000 ||
+		!bytes.Equal(audio.Config, []byte{0x11, 0x90}) || audio.SizeLength != 13 || audio.IndexLength != 3 {
+		t.Errorf("audio %+v", audio)
+	}
+	// a static payload type without rtpmap.
+	if pcma := session.Medias[2]; pcma.Rtpmap != 8 || pcma.CodecType != "PCMA" || pcma.TimeScale != 8000 {
+		t.Errorf("static %+v", pcma)
+	}
+}
+
+func TestMarshalEmptyValue(t *testing.T) {
+	// a colon without a value is kept apart from a property attribute.
+	in := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\na=recvonly\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=fmtp:\r\n"
+	session, err := ParseSdp(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(session.Marshal()); out != in {
+		t.Errorf("marshaled %q", out)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	in := "v=0\r\na=range:npt=0-10:00\r\na=recvonly\r\nm=video 0 RTP/AVP 96\r\n" +
+		"a=control:rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=1\r\n" +
+		"a=rtpmap:96 H264/90000\r\na=ssrc:1 cname:user@host\r\na=ssrc:1 msid:stream track\r\na=ssrc:2 cname:user@host\r\n" +
+		"m=audio 0 RTP/AVP 0\r\na=sendonly\r\na=range:npt=0-5\r\n"
+	session, err := ParseSdp(bytes.NewReader([]byte(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, audio := session.Medias[0], session.Medias[1]
+	if video.Control != "rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=1" {
+		t.Errorf("control %q", video.Control)
+	}
+	if value, ok := session.Range(video); !ok || value != "npt=0-10:00" {
+		t.Errorf("session range %q", value)
+	}
+	if value, _ := session.Range(audio); value != "npt=0-5" {
+		t.Errorf("media range %q", value)
+	}
+	if session.Direction(video) != DirectionRecvOnly || session.Direction(audio) != DirectionSendOnly {
+		t.Errorf("directions %s %s", session.Direction(video), session.Direction(audio))
+	}
+	if !session.Attributes.Has("recvonly") || video.Attributes.Has("recvonly") {
+		t.Errorf("has recvonly")
+	}
+
+	ssrcs := video.Ssrcs()
+	want := []Ssrc{{1, "cname", "user@host"}, {1, "msid", "stream track"}, {2, "cname", "user@host"}}
+	if len(ssrcs) != len(want) {
+		t.Fatalf("ssrcs %+v", ssrcs)
+	}
+	for i := range want {
+		if ssrcs[i] != want[i] {
+			t.Errorf("ssrc %d %+v", i, ssrcs[i])
+		}
+	}
+	if values := video.Attributes.Values("ssrc"); len(values) != 3 || values[2] != "2 cname:user@host" {
+		t.Errorf("ssrc values %q", values)
+	}
+
+	// the typed fields follow the attributes they are parsed from.
+	in = "v=0\r\na=extmap:1 urn:ietf:params:rtp-hdrext:toffset\r\na=key-mgmt:mikey AQE=\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=control:trackID=1\r\na=control:trackID=2\r\na=framerate:25\r\n" +
+		"a=ssrc-group:FID 1 2\r\na=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:key\r\n"
+	if session, err = ParseSdp(strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Extmaps) != 1 || session.Extmaps[0].URI != "urn:ietf:params:rtp-hdrext:toffset" ||
+		len(session.KeyMgmts) != 1 || session.KeyMgmts[0].Protocol != "mikey" {
+		t.Errorf("session %+v", session)
+	}
+	video = session.Medias[0]
+	if control, _ := video.Attributes.Get("control"); video.Control != control || control != "trackID=1" {
+		t.Errorf("control %q", video.Control)
+	}
+	if video.Framerate != 25 || len(video.SsrcGroups) != 1 || len(video.SsrcGroups[0].SSRCs) != 2 ||
+		len(video.Cryptos) != 1 || video.Cryptos[0].Suite != "AES_CM_128_HMAC_SHA1_80" {
+		t.Errorf("media %+v", video)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	// strict parsing fails on the first problem lenient parsing warns of.
+	for file, data := range captures(t) {
+		_, warnings, err := Parse(bytes.NewReader(data), true)
+		_, lenient, _ := Parse(bytes.NewReader(data), false)
+		if len(warnings) != 0 || len(lenient) == 0 && err != nil ||
+			len(lenient) != 0 && (err == nil || err.Error() != lenient[0].Error()) {
+			t.Errorf("%s: %v, lenient %v", file, err, lenient)
+		}
+	}
+
+	const head = "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n"
+	tests := []struct {
+		sdp   string
+		line  int
+		field string
+	}{
+		{"o=- 1 1 IN IP4 0.0.0.0\r\n", 1, "v"},
+		{"v=zero\r\n", 1, "v"},
+		{"v=0\r\no=- 1 IN IP4 0.0.0.0\r\n", 2, "o"},
+		{head + "c=IN IP4\r\n", 5, "c"},
+		{head + "b=AS\r\n", 5, "b"},
+		{head + "x=unknown\r\n", 5, "x"},
+		{head + "no equal sign\r\n", 5, "no equal sign"},
+		{head + "m=video 0\r\n", 5, "m"},
+		{head + "m=video zero RTP/AVP 96\r\n", 5, "m"},
+		{head + "m=video 0 RTP/AVP 96 h264\r\n", 5, "m"},
+		{head + "m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264\r\n", 6, "a=rtpmap"},
+		{head + "m=video 0 RTP/AVP 96\r\na=rtpmap:x H264/90000\r\n", 6, "a=rtpmap"},
+		{head + "m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/fast\r\n", 6, "a=rtpmap"},
+		{head + "m=video 0 RTP/AVP 96\r\na=fmtp:96 sprop-parameter-sets=!!\r\n", 6, "a=fmtp"},
+		{head + "m=audio 0 RTP/AVP 96\r\na=fmtp:96 sizelength=thirteen\r\n", 6, "a=fmtp"},
+		{head + "m=video 0 RTP/AVP 96\r\na=rtcp-fb:96\r\n", 6, "a=rtcp-fb"},
+		{head + "m=video 0 RTP/AVP 96\r\na=framerate:fast\r\n", 6, "a=framerate"},
+		{head + "m=video 0 RTP/AVP 96\r\na=ssrc-group:FID 1 x\r\n", 6, "a=ssrc-group"},
+		{head + "a=extmap:x urn:x\r\n", 5, "a=extmap"},
+		{"v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\n", 0, "t"},
+		{"v=0\r\ns=-\r\nt=0 0\r\n", 0, "o"},
+	}
+	for _, test := range tests {
+		_, _, err := Parse(bytes.NewReader([]byte(test.sdp)), true)
+		e, ok := err.(*ParseError)
+		if !ok || e.Line != test.line || e.Field != test.field {
+			t.Errorf("%q: %v", test.sdp, err)
+		}
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	in := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\n" +
+		"m=video\r\n" +
+		"a=rtpmap:96 H264\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=control:trackID=1\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=framerate:fast\r\n"
+	session, warnings, err := Parse(bytes.NewReader([]byte(in)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Medias) != 2 || session.Medias[1].Control != "trackID=1" || session.Medias[1].CodecType != "H264" {
+		t.Errorf("medias %+v", session.Medias)
+	}
+	want := []ParseError{{4, "m", ""}, {5, "a=rtpmap", ""}, {9, "a=framerate", ""}, {0, "t", ""}}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings %v", warnings)
+	}
+	for i, warning := range warnings {
+		if warning.Line != want[i].Line || warning.Field != want[i].Field {
+			t.Errorf("warning %d: %v", i, warning)
+		}
+	}
+	if _, err := ParseSdp(bytes.NewReader([]byte(in))); err != nil {
+		t.Errorf("ParseSdp: %v", err)
+	}
+}
+
+// FuzzParse checks that neither mode panics, that strict parsing fails on
+// the first problem lenient parsing warns of, and that what is parsed
+// marshals into a description that parses again into the same one.
+//
+//	go test -fuzz FuzzParse ./sdp
+func FuzzParse(f *testing.F) {
+	for _, data := range captures(f) {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		session, warnings, err := Parse(bytes.NewReader(data), true)
+		if err == nil && len(warnings) != 0 {
+			t.Fatalf("strict parsing warned: %v", warnings)
+		}
+		lenient, lenientWarnings, lenientErr := Parse(bytes.NewReader(data), false)
+		if lenientErr != nil {
+			// only reading fails, such as on a line too long.
+			return
+		}
+		if _, ok := err.(*ParseError); ok && (len(lenientWarnings) == 0 || err.Error() != lenientWarnings[0].Error()) {
+			t.Fatalf("strict error %v, lenient warnings %v", err, lenientWarnings)
+		}
+		if err == nil && (len(lenientWarnings) != 0 || len(session.Medias) != len(lenient.Medias)) {
+			t.Fatalf("lenient parsing of a valid description differs: %v", lenientWarnings)
+		}
+
+		out := lenient.Marshal()
+		again, _, err := Parse(bytes.NewReader(out), false)
+		if err != nil {
+			t.Fatalf("marshaled description unreadable: %v", err)
+		}
+		if !bytes.Equal(again.Marshal(), out) {
+			t.Fatalf("marshaled\n%q\nthen\n%q", out, again.Marshal())
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solomondong/rtsp/client"
+)
+
+// defaultNonceExpiry is how long a digest nonce stays valid when the
+// authenticator doesn't say otherwise.
+const defaultNonceExpiry = time.Minute
+
+// Action defines what a client wants to do with a path.
+type Action int
+
+// Actions
+const (
+	ActionRead Action = iota + 1
+	ActionPublish
+)
+
+// String prints an action.
+func (a Action) String() string {
+	switch a {
+	case ActionRead:
+		return "read"
+	case ActionPublish:
+		return "publish"
+	}
+	return "unknown"
+}
+
+// AuthRequest describes a request to be authorized.
+type AuthRequest struct {
+	Path   string
+	Method string
+	Action Action
+	// User is the authenticated user, empty for an anonymous client.
+	User string
+	IP   net.IP
+}
+
+// Authenticator defines how the server authenticates and authorizes clients.
+type Authenticator struct {
+	// Realm is sent in challenges.
+	Realm string
+	// Basic offers Basic authentication besides Digest. Basic sends the
+	// password in clear text, so only turn it on for clients which need it.
+	Basic bool
+	// Password returns the password of a user, ok is false for unknown users.
+	// Clients are not authenticated when it's nil.
+	Password func(user string) (password string, ok bool)
+	// Authorize decides whether a request may go ahead. When nil, every
+	// authenticated user may read and publish every path.
+	Authorize func(req AuthRequest) bool
+	// NonceExpiry is how long a digest nonce is valid, one minute if 0.
+	NonceExpiry time.Duration
+
+	// Allow, when not empty, only lets clients from these networks in.
+	Allow []*net.IPNet
+	// Deny keeps clients from these networks out, it wins over Allow.
+	Deny []*net.IPNet
+
+	mu     sync.Mutex
+	nonces map[string]*nonce
+}
+
+// nonce defines a digest nonce handed out in a challenge.
+type nonce struct {
+	expires time.Time
+	// nc is the highest nonce count a client sent with qop=auth.
+	nc uint64
+}
+
+// ipAllowed checks an ip against the allow and deny lists.
+func (a *Authenticator) ipAllowed(ip net.IP) bool {
+	for _, network := range a.Deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, network := range a.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newNonce issues a digest nonce. It's forgotten one more expiry after it
+// expires, until then clients using it are told it's stale.
+func (a *Authenticator) newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	value := hex.EncodeToString(b)
+
+	expiry := a.NonceExpiry
+	if expiry == 0 {
+		expiry = defaultNonceExpiry
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]*nonce)
+	}
+	a.nonces[value] = &nonce{expires: time.Now().Add(expiry)}
+	time.AfterFunc(2*expiry, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.nonces, value)
+	})
+	return value
+}
+
+// useNonce checks a nonce was issued by us and takes it up, a nonce is stale
+// when it has expired or when a qop=auth request replays its nonce count. nc
+// is the nonce count of a qop=auth response, empty without qop.
+func (a *Authenticator) useNonce(value, nc string) (known, stale bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := a.nonces[value]
+	if n == nil {
+		return false, false
+	}
+	if time.Now().After(n.expires) {
+		return true, true
+	}
+	if nc == "" {
+		// clients without qop, such as live555 and VLC, keep the nonce
+		// until it expires.
+		return true, false
+	}
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil || count <= n.nc {
+		return true, true
+	}
+	n.nc = count
+	return true, false
+}
+
+// uriMatches tells whether the digest uri is the request's own url, either
+// absolute or as a path.
+func uriMatches(uri string, u *url.URL) bool {
+	return uri != "" && (uri == u.String() || uri == u.RequestURI())
+}
+
+// authenticate checks the Authorization header of a request. ok is false when
+// credentials were sent but are wrong, stale tells that a digest nonce expired.
+func (a *Authenticator) authenticate(req *client.Request) (user string, ok, stale bool) {
+	header := req.Header.Get("Authorization")
+	if a.Password == nil || header == "" {
+		return "", true, false
+	}
+
+	switch {
+	case a.Basic && strings.HasPrefix(header, "Basic "):
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+		if err != nil {
+			return "", false, false
+		}
+		credentials := strings.SplitN(string(b), ":", 2)
+		if len(credentials) != 2 {
+			return "", false, false
+		}
+		password, found := a.Password(credentials[0])
+		if !found || subtle.ConstantTimeCompare([]byte(password), []byte(credentials[1])) != 1 {
+			return "", false, false
+		}
+		return credentials[0], true, false
+
+	case strings.HasPrefix(header, "Digest "):
+		params := parseAuthParams(strings.TrimPrefix(header, "Digest "))
+		if params["realm"] != a.Realm || !uriMatches(params["uri"], req.URL) {
+			return "", false, false
+		}
+		password, found := a.Password(params["username"])
+		if !found {
+			return "", false, false
+		}
+		digest := client.DigestAuthencitation{
+			UserName: params["username"],
+			Password: password,
+			Realm:    a.Realm,
+			Nonce:    params["nonce"],
+		}
+		var nc string
+		switch params["qop"] {
+		case "":
+		case "auth":
+			// RFC 2617 3.2.2.1, the nonce count keeps responses from being replayed.
+			if nc = params["nc"]; nc == "" {
+				return "", false, false
+			}
+		default:
+			return "", false, false
+		}
+		expected := digest.DigestResponse(req.Method, params["uri"], nc, params["cnonce"])
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+			return "", false, false
+		}
+		if known, stale := a.useNonce(params["nonce"], nc); !known || stale {
+			return "", false, stale
+		}
+		return params["username"], true, false
+	}
+	return "", false, false
+}
+
+// challenge fills a 401 response with the challenges a client can answer.
+func (a *Authenticator) challenge(res *client.Response, stale bool) {
+	digest := fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth"`, a.Realm, a.newNonce())
+	if stale {
+		digest += ", stale=TRUE"
+	}
+	res.Header.Add("WWW-Authenticate", digest)
+	if a.Basic {
+		res.Header.Add("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, a.Realm))
+	}
+}
+
+// check authenticates and authorizes a request, it returns the response to
+// reject the request with, or nil to let it go ahead.
+func (a *Authenticator) check(req *client.Request, path string, action Action, ip net.IP) *client.Response {
+	if !a.ipAllowed(ip) {
+		return newResponse(req, client.Forbidden)
+	}
+
+	user, ok, stale := a.authenticate(req)
+	if ok {
+		var allowed bool
+		if a.Authorize != nil {
+			allowed = a.Authorize(AuthRequest{Path: path, Method: req.Method, Action: action, User: user, IP: ip})
+		} else {
+			allowed = a.Password == nil || user != ""
+		}
+		if allowed {
+			return nil
+		}
+		// a known user, or a server without users, gains nothing by retrying.
+		if user != "" || a.Password == nil {
+			return newResponse(req, client.Forbidden)
+		}
+	}
+
+	res := newResponse(req, client.Unauthorized)
+	a.challenge(res, stale)
+	return res
+}
+
+// parseAuthParams parses the comma separated key=value pairs of an
+// authorization header, values may be quoted and contain commas.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = strings.TrimSpace(s[:comma]), s[comma+1:]
+		} else {
+			value, s = strings.TrimSpace(s), ""
+		}
+		params[key] = value
+	}
+	return params
+}
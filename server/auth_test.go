@@ -0,0 +1,216 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/solomondong/rtsp/client"
+)
+
+func newAuthTestRequest(method, uri, authorization string) *client.Request {
+	u, _ := url.Parse(uri)
+	req := &client.Request{Method: method, URL: u, Header: make(http.Header)}
+	req.Header.Set("CSeq", "1")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return req
+}
+
+func TestAuthenticatorDigest(t *testing.T) {
+	a := &Authenticator{
+		Realm: "rtsp",
+		Password: func(user string) (string, bool) {
+			return "secret", user == "admin"
+		},
+	}
+	const uri = "rtsp://127.0.0.1/live"
+	ip := net.ParseIP("127.0.0.1")
+
+	res := a.check(newAuthTestRequest(client.DESCRIBE, uri, ""), "/live", ActionRead, ip)
+	if res == nil || res.StatusCode != client.Unauthorized {
+		t.Fatalf("anonymous request not challenged: %v", res)
+	}
+	params := parseAuthParams(res.Header.Get("WWW-Authenticate")[len("Digest "):])
+	if params["realm"] != "rtsp" || params["nonce"] == "" {
+		t.Fatalf("bad challenge %q", res.Header.Get("WWW-Authenticate"))
+	}
+
+	answer := func(password string) string {
+		d := client.DigestAuthencitation{UserName: "admin", Password: password, Realm: "rtsp", Nonce: params["nonce"]}
+		return fmt.Sprintf(`Digest username="admin", realm="rtsp", nonce="%s", uri="%s", response="%s"`,
+			d.Nonce, uri, d.GetDigestResponse(client.DESCRIBE, uri))
+	}
+	if res := a.check(newAuthTestRequest(client.DESCRIBE, uri, answer("secret")), "/live", ActionRead, ip); res != nil {
+		t.Fatalf("valid digest rejected: %v", res)
+	}
+	if res := a.check(newAuthTestRequest(client.DESCRIBE, uri, answer("wrong")), "/live", ActionRead, ip); res == nil || res.StatusCode != client.Unauthorized {
+		t.Fatalf("wrong password accepted: %v", res)
+	}
+
+	a.nonces[params["nonce"]].expires = time.Now().Add(-time.Second)
+	res = a.check(newAuthTestRequest(client.DESCRIBE, uri, answer("secret")), "/live", ActionRead, ip)
+	if res == nil || parseAuthParams(res.Header.Get("WWW-Authenticate")[len("Digest "):])["stale"] != "TRUE" {
+		t.Fatalf("expired nonce not reported stale: %v", res)
+	}
+}
+
+// challengeNonce returns the nonce of the digest challenge of a 401 response.
+func challengeNonce(t *testing.T, res *client.Response) string {
+	if res == nil || res.StatusCode != client.Unauthorized {
+		t.Fatalf("not challenged: %v", res)
+	}
+	params := parseAuthParams(res.Header.Get("WWW-Authenticate")[len("Digest "):])
+	if params["qop"] != "auth" {
+		t.Fatalf("challenge without qop %q", res.Header.Get("WWW-Authenticate"))
+	}
+	return params["nonce"]
+}
+
+func isStale(res *client.Response) bool {
+	return res != nil && res.StatusCode == client.Unauthorized &&
+		parseAuthParams(res.Header.Get("WWW-Authenticate")[len("Digest "):])["stale"] == "TRUE"
+}
+
+func TestAuthenticatorDigestReplay(t *testing.T) {
+	a := &Authenticator{
+		Realm: "rtsp",
+		Password: func(user string) (string, bool) {
+			return "secret", user == "admin"
+		},
+	}
+	const uri = "rtsp://127.0.0.1/live"
+	ip := net.ParseIP("127.0.0.1")
+	check := func(authorization string) *client.Response {
+		return a.check(newAuthTestRequest(client.DESCRIBE, uri, authorization), "/live", ActionRead, ip)
+	}
+
+	// without qop a nonce is good until it expires.
+	nonce := challengeNonce(t, check(""))
+	d := client.DigestAuthencitation{UserName: "admin", Password: "secret", Realm: "rtsp", Nonce: nonce}
+	answer := fmt.Sprintf(`Digest username="admin", realm="rtsp", nonce="%s", uri="%s", response="%s"`,
+		nonce, uri, d.GetDigestResponse(client.DESCRIBE, uri))
+	for i := 0; i < 2; i++ {
+		if res := check(answer); res != nil {
+			t.Fatalf("valid digest %d rejected: %v", i, res)
+		}
+	}
+	a.mu.Lock()
+	a.nonces[nonce].expires = time.Now().Add(-time.Second)
+	a.mu.Unlock()
+	if res := check(answer); !isStale(res) {
+		t.Errorf("expired nonce not stale: %v", res)
+	}
+
+	// the digest must be for the request's url.
+	nonce = challengeNonce(t, check(""))
+	d.Nonce = nonce
+	const other = "rtsp://127.0.0.1/other"
+	if res := check(fmt.Sprintf(`Digest username="admin", realm="rtsp", nonce="%s", uri="%s", response="%s"`,
+		nonce, other, d.GetDigestResponse(client.DESCRIBE, other))); res == nil || isStale(res) {
+		t.Errorf("digest of another uri accepted: %v", res)
+	}
+
+	// with qop=auth the nonce count must go up.
+	qop := func(nc string) string {
+		response := d.DigestResponse(client.DESCRIBE, uri, nc, "0a4f113b")
+		return fmt.Sprintf(`Digest username="admin", realm="rtsp", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="0a4f113b", response="%s"`,
+			nonce, uri, nc, response)
+	}
+	if res := check(qop("00000001")); res != nil {
+		t.Fatalf("valid qop digest rejected: %v", res)
+	}
+	if res := check(qop("00000002")); res != nil {
+		t.Fatalf("next nonce count rejected: %v", res)
+	}
+	if res := check(qop("00000002")); !isStale(res) {
+		t.Errorf("replayed nonce count not stale: %v", res)
+	}
+
+	// the client counts up for every request.
+	d = client.DigestAuthencitation{UserName: "admin", Password: "secret", Realm: "rtsp",
+		Nonce: challengeNonce(t, check("")), Qop: "auth"}
+	for i := 0; i < 3; i++ {
+		if res := check(d.Authorization(client.DESCRIBE, uri)); res != nil {
+			t.Fatalf("client digest %d rejected: %v", i, res)
+		}
+	}
+}
+
+func TestAuthenticatorNonceExpiry(t *testing.T) {
+	a := &Authenticator{NonceExpiry: 10 * time.Millisecond}
+	a.newNonce()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		n := len(a.nonces)
+		a.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired nonce not forgotten")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAuthenticatorAuthorize(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+	_, blocked, _ := net.ParseCIDR("192.168.9.0/24")
+	a := &Authenticator{
+		Realm: "rtsp",
+		Basic: true,
+		Password: func(user string) (string, bool) {
+			return "secret", user == "encoder"
+		},
+		// anyone may read, only the encoder may publish.
+		Authorize: func(req AuthRequest) bool {
+			return req.Action == ActionRead || req.User == "encoder"
+		},
+		Allow: []*net.IPNet{lan},
+		Deny:  []*net.IPNet{blocked},
+	}
+	const uri = "rtsp://192.168.1.10/live"
+	basic := "Basic ZW5jb2RlcjpzZWNyZXQ=" // encoder:secret
+
+	tests := []struct {
+		method string
+		action Action
+		auth   string
+		ip     string
+		status int
+	}{
+		{client.DESCRIBE, ActionRead, "", "192.168.1.2", 0},
+		{client.ANNOUNCE, ActionPublish, "", "192.168.1.2", client.Unauthorized},
+		{client.ANNOUNCE, ActionPublish, basic, "192.168.1.2", 0},
+		{client.ANNOUNCE, ActionPublish, basic, "192.168.9.2", client.Forbidden},
+		{client.DESCRIBE, ActionRead, "", "10.0.0.1", client.Forbidden},
+	}
+	for _, tst := range tests {
+		res := a.check(newAuthTestRequest(tst.method, uri, tst.auth), "/live", tst.action, net.ParseIP(tst.ip))
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		if status != tst.status {
+			t.Errorf("%s from %s: status %d, expected %d", tst.method, tst.ip, status, tst.status)
+		}
+	}
+}
+
+func TestServerDeniesAddress(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	s := NewServer("")
+	s.Auth = &Authenticator{Deny: []*net.IPNet{loopback}}
+	c := dialTest(t, startServer(t, s))
+	// the connection is closed before any request is read.
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("denied connection left open, %v", err)
+	}
+}
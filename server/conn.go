@@ -0,0 +1,490 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nareix/joy4/utils/bits/pio"
+	"github.com/solomondong/rtsp/client"
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// conn defines a rtsp connection accepted by the server.
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	bufConn *bufio.Reader
+
+	writeMu  sync.Mutex
+	sessions map[string]*session
+
+	// afterResponse runs once the current response is written, so that
+	// media never goes out ahead of the PLAY response.
+	afterResponse func()
+
+	// closed is closed once the connection and its sessions are torn down.
+	closed chan struct{}
+}
+
+func newConn(server *Server, netConn net.Conn) *conn {
+	return &conn{
+		server:   server,
+		netConn:  netConn,
+		bufConn:  bufio.NewReader(netConn),
+		sessions: make(map[string]*session),
+		closed:   make(chan struct{}),
+	}
+}
+
+// serve reads requests off the connection until it's closed. Sessions
+// created on the connection are torn down with it.
+func (c *conn) serve() {
+	defer c.close()
+	for {
+		b, err := c.bufConn.Peek(1)
+		if err != nil {
+			return
+		}
+		if b[0] == '$' {
+			channel, data, err := c.readInterleaved()
+			if err != nil {
+				return
+			}
+			c.handleInterleaved(channel, data)
+			continue
+		}
+
+		req, err := client.ReadRequest(c.bufConn)
+		if err == client.ErrMalformedRequest || err == client.ErrContentLength {
+			// the rest of the stream can't be framed, answer and hang up.
+			c.writeResponse(newResponse(req, client.BadRequest))
+			return
+		} else if err != nil {
+			return
+		}
+		if c.server.debug {
+			fmt.Println(req)
+		}
+		res := c.handleRequest(req)
+		if c.server.debug {
+			fmt.Println(res)
+		}
+		if err = c.writeResponse(res); err != nil {
+			return
+		}
+		if c.afterResponse != nil {
+			c.afterResponse()
+			c.afterResponse = nil
+		}
+	}
+}
+
+// readInterleaved reads a $ framed packet sent by the client.
+func (c *conn) readInterleaved() (channel int, data []byte, err error) {
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(c.bufConn, header); err != nil {
+		return
+	}
+	channel = int(header[1])
+	data = make([]byte, pio.U16BE(header[2:4]))
+	_, err = io.ReadFull(c.bufConn, data)
+	return
+}
+
+// handleInterleaved hands rtp of a publisher to its session, anything else,
+// such as rtcp of readers, is dropped.
+func (c *conn) handleInterleaved(channel int, data []byte) {
+	for _, sess := range c.sessions {
+		if t, ok := sess.interleavedTrack(channel); ok {
+			if packet, err := rtp.ParsePacket(data, uint(t.idx)); err == nil {
+				sess.handleRtp(packet)
+			}
+			return
+		}
+	}
+}
+
+// writeInterleaved writes a frame on an interleaved channel. The packet
+// follows 4 bytes left free at the start of frame for the header.
+func (c *conn) writeInterleaved(channel int, frame []byte) error {
+	frame[0] = '$'
+	frame[1] = byte(channel)
+	pio.PutU16BE(frame[2:4], uint16(len(frame)-4))
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.netConn.Write(frame)
+	return err
+}
+
+func (c *conn) writeResponse(res *client.Response) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return res.Write(c.netConn)
+}
+
+func (c *conn) close() {
+	for _, sess := range c.sessions {
+		sess.close()
+		c.server.removeSession(sess)
+	}
+	c.netConn.Close()
+	c.server.removeConn(c)
+	close(c.closed)
+}
+
+func newResponse(req *client.Request, code int) *client.Response {
+	res := &client.Response{
+		Proto:      "RTSP",
+		ProtoMajor: 1,
+		ProtoMinor: 0,
+		StatusCode: code,
+		Status:     client.StatusText(code),
+		Header:     make(http.Header),
+	}
+	res.Header["CSeq"] = []string{req.Header.Get("CSeq")}
+	return res
+}
+
+func (c *conn) handleRequest(req *client.Request) *client.Response {
+	if res := c.authorize(req); res != nil {
+		return res
+	}
+
+	switch req.Method {
+	case client.OPTIONS:
+		res := newResponse(req, client.OK)
+		res.Header.Set("Public", strings.Join([]string{
+			client.OPTIONS, client.DESCRIBE, client.ANNOUNCE, client.SETUP, client.PLAY,
+			client.PAUSE, client.RECORD, client.TEARDOWN, client.GETPARAMETER,
+		}, ", "))
+		return res
+	case client.DESCRIBE:
+		return c.handleDescribe(req)
+	case client.ANNOUNCE:
+		return c.handleAnnounce(req)
+	case client.RECORD:
+		return c.handleRecord(req)
+	case client.SETUP:
+		return c.handleSetup(req)
+	case client.PLAY:
+		return c.handlePlay(req)
+	case client.PAUSE:
+		return c.handlePause(req)
+	case client.TEARDOWN:
+		return c.handleTeardown(req)
+	case client.GETPARAMETER:
+		// clients use this as a keep alive.
+		res := newResponse(req, client.OK)
+		if id := req.Header.Get("Session"); id != "" {
+			res.Header.Set("Session", id)
+		}
+		return res
+	default:
+		return newResponse(req, client.NotImplemented)
+	}
+}
+
+// authorize checks the requests which open a path for reading or publishing
+// against the server's authenticator. Requests on an existing session only
+// reach the connection's own sessions, so they aren't checked again.
+func (c *conn) authorize(req *client.Request) *client.Response {
+	if c.server.Auth == nil {
+		return nil
+	}
+
+	var path string
+	var action Action
+	switch req.Method {
+	case client.DESCRIBE, client.PLAY:
+		path, action = cleanPath(req.URL.Path), ActionRead
+	case client.SETUP:
+		if t, err := parseTransport(req.Header.Get("Transport")); err == nil && t.Mode == "record" {
+			path, action = c.publishPath(req), ActionPublish
+		} else {
+			path, _, _ = splitTrackPath(req.URL.Path)
+			action = ActionRead
+		}
+	case client.ANNOUNCE:
+		path, action = cleanPath(req.URL.Path), ActionPublish
+	case client.RECORD:
+		path, action = c.publishPath(req), ActionPublish
+	default:
+		return nil
+	}
+
+	host, _, _ := net.SplitHostPort(c.netConn.RemoteAddr().String())
+	return c.server.Auth.check(req, path, action, net.ParseIP(host))
+}
+
+// publishPath returns the path a publisher's request refers to.
+func (c *conn) publishPath(req *client.Request) string {
+	if sess := c.recordSession(req, cleanPath(req.URL.Path)); sess != nil {
+		return sess.path
+	}
+	return cleanPath(req.URL.Path)
+}
+
+// baseURL returns the request url without a trailing slash.
+func baseURL(req *client.Request) string {
+	return strings.TrimSuffix(req.URL.String(), "/")
+}
+
+func (c *conn) handleDescribe(req *client.Request) *client.Response {
+	open, ok := c.server.lookup(cleanPath(req.URL.Path))
+	if !ok {
+		return newResponse(req, client.NotFound)
+	}
+	source, err := open()
+	if err != nil {
+		return newResponse(req, client.InternalServerError)
+	}
+	defer source.Close()
+
+	streams, err := source.Streams()
+	if err != nil {
+		return newResponse(req, client.InternalServerError)
+	}
+	base := baseURL(req)
+	body, err := makeSdp(streams, base)
+	if err != nil {
+		return newResponse(req, client.UnsupportedMediaType)
+	}
+
+	res := newResponse(req, client.OK)
+	res.Header.Set("Content-Base", base+"/")
+	res.Header.Set("Content-Type", "application/sdp")
+	res.Body = body
+	return res
+}
+
+// splitTrackPath splits a setup path such as /clip.mp4/trackID=1 into the
+// presentation path and the track index.
+func splitTrackPath(p string) (path string, idx int, ok bool) {
+	p = cleanPath(p)
+	i := strings.LastIndex(p, "/")
+	if !strings.HasPrefix(p[i+1:], "trackID=") {
+		return p, 0, false
+	}
+	idx, err := strconv.Atoi(strings.TrimPrefix(p[i+1:], "trackID="))
+	if err != nil {
+		return p, 0, false
+	}
+	return cleanPath(p[:i]), idx, true
+}
+
+// sessionID returns the session id of a request without its parameters.
+func sessionID(req *client.Request) string {
+	return strings.TrimSpace(strings.SplitN(req.Header.Get("Session"), ";", 2)[0])
+}
+
+func (c *conn) handleSetup(req *client.Request) *client.Response {
+	path, idx, ok := splitTrackPath(req.URL.Path)
+	if !ok {
+		// a presentation with a single stream may be set up by its own url.
+		idx = 0
+	}
+
+	t, err := parseTransport(req.Header.Get("Transport"))
+	if err != nil {
+		return newResponse(req, client.UnsupportedTransport)
+	}
+	if t.Mode == "record" {
+		return c.handleSetupRecord(req, t)
+	}
+
+	sess := c.sessions[sessionID(req)]
+	if id := sessionID(req); id != "" && sess == nil {
+		return newResponse(req, client.SessionNotFound)
+	}
+	if sess != nil && (sess.path != path || sess.publication != nil) {
+		return newResponse(req, client.AggregateOperationNotAllowed)
+	}
+	if sess == nil {
+		open, ok := c.server.lookup(path)
+		if !ok {
+			return newResponse(req, client.NotFound)
+		}
+		source, err := open()
+		if err != nil {
+			return newResponse(req, client.InternalServerError)
+		}
+		if sess, err = newSession(c, path, source); err != nil {
+			source.Close()
+			return newResponse(req, client.InternalServerError)
+		}
+		c.sessions[sess.id] = sess
+		c.server.addSession(sess)
+	}
+
+	if t, err = sess.setup(idx, t); err != nil {
+		return newResponse(req, client.NotFound)
+	}
+
+	res := newResponse(req, client.OK)
+	res.Header.Set("Transport", t.String())
+	res.Header.Set("Session", fmt.Sprintf("%s;timeout=%d", sess.id, sessionTimeout))
+	return res
+}
+
+func (c *conn) handlePlay(req *client.Request) *client.Response {
+	sess := c.sessions[sessionID(req)]
+	if sess == nil {
+		return newResponse(req, client.SessionNotFound)
+	}
+	if sess.publication != nil {
+		return newResponse(req, client.MethodNotValidInThisState)
+	}
+
+	scale := 1.0
+	if header := req.Header.Get("Scale"); header != "" {
+		var err error
+		if scale, err = parseScale(header); err != nil {
+			return newResponse(req, client.HeaderFieldNotValid)
+		}
+	}
+
+	var start time.Duration
+	var seek bool
+	if header := req.Header.Get("Range"); header != "" {
+		var err error
+		if start, seek, err = parseRange(header); err != nil {
+			return newResponse(req, client.InvalidRange)
+		}
+	}
+	if seek {
+		sess.pause()
+		// the range and rtp times answer where the seek landed, the key
+		// frame next to start.
+		var err error
+		if start, err = sess.seek(start); err != nil {
+			return newResponse(req, client.InvalidRange)
+		}
+	}
+
+	res := newResponse(req, client.OK)
+	res.Header.Set("Session", sess.id)
+	if seek {
+		res.Header.Set("Range", "npt="+formatNpt(start)+"-")
+	}
+	if scale != 1 {
+		res.Header.Set("Scale", strconv.FormatFloat(scale, 'f', -1, 64))
+	}
+	var rtpInfo []string
+	base := baseURL(req)
+	for _, t := range sess.tracks {
+		if t == nil {
+			continue
+		}
+		info := fmt.Sprintf("url=%s;seq=%d", trackURL(base, t.idx), t.packetizer.sequence)
+		if seek {
+			info += fmt.Sprintf(";rtptime=%d", t.packetizer.rtpTime(start))
+		}
+		rtpInfo = append(rtpInfo, info)
+	}
+	res.Header.Set("RTP-Info", strings.Join(rtpInfo, ","))
+
+	c.afterResponse = func() {
+		sess.play(scale)
+	}
+	return res
+}
+
+func (c *conn) handleAnnounce(req *client.Request) *client.Response {
+	path := cleanPath(req.URL.Path)
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/sdp") {
+		return newResponse(req, client.UnsupportedMediaType)
+	}
+	p, err := sdp.ParseSdp(bytes.NewReader(req.Body))
+	if err != nil || len(p.Medias) == 0 {
+		return newResponse(req, client.BadRequest)
+	}
+
+	sess := newRecordSession(c, path, p.Medias)
+	if !c.server.addPublication(sess.publication) {
+		// the path already has a publisher.
+		return newResponse(req, client.MethodNotValidInThisState)
+	}
+	c.sessions[sess.id] = sess
+	c.server.addSession(sess)
+
+	res := newResponse(req, client.OK)
+	res.Header.Set("Session", fmt.Sprintf("%s;timeout=%d", sess.id, sessionTimeout))
+	return res
+}
+
+// recordSession finds the publishing session of a request, either by its
+// Session header or by the path announced on this connection.
+func (c *conn) recordSession(req *client.Request, path string) *session {
+	if sess := c.sessions[sessionID(req)]; sess != nil {
+		return sess
+	}
+	for _, sess := range c.sessions {
+		if sess.publication != nil && (sess.path == path || strings.HasPrefix(path, sess.path+"/")) {
+			return sess
+		}
+	}
+	return nil
+}
+
+func (c *conn) handleSetupRecord(req *client.Request, t transport) *client.Response {
+	sess := c.recordSession(req, cleanPath(req.URL.Path))
+	if sess == nil || sess.publication == nil {
+		return newResponse(req, client.MethodNotValidInThisState)
+	}
+	idx, ok := sess.inputIndex(req.URL.String())
+	if !ok {
+		return newResponse(req, client.NotFound)
+	}
+	t, err := sess.setupRecord(idx, t)
+	if err != nil {
+		return newResponse(req, client.InternalServerError)
+	}
+
+	res := newResponse(req, client.OK)
+	res.Header.Set("Transport", t.String())
+	res.Header.Set("Session", fmt.Sprintf("%s;timeout=%d", sess.id, sessionTimeout))
+	return res
+}
+
+func (c *conn) handleRecord(req *client.Request) *client.Response {
+	sess := c.recordSession(req, cleanPath(req.URL.Path))
+	if sess == nil || sess.publication == nil {
+		return newResponse(req, client.SessionNotFound)
+	}
+	res := newResponse(req, client.OK)
+	res.Header.Set("Session", sess.id)
+	c.afterResponse = sess.record
+	return res
+}
+
+func (c *conn) handlePause(req *client.Request) *client.Response {
+	sess := c.sessions[sessionID(req)]
+	if sess == nil {
+		return newResponse(req, client.SessionNotFound)
+	}
+	sess.pause()
+	res := newResponse(req, client.OK)
+	res.Header.Set("Session", sess.id)
+	return res
+}
+
+func (c *conn) handleTeardown(req *client.Request) *client.Response {
+	sess := c.sessions[sessionID(req)]
+	if sess == nil {
+		return newResponse(req, client.SessionNotFound)
+	}
+	sess.close()
+	delete(c.sessions, sess.id)
+	c.server.removeSession(sess)
+	return newResponse(req, client.OK)
+}
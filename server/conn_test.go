@@ -0,0 +1,348 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/format/flv"
+	"github.com/solomondong/rtsp/client"
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// startServer serves s on a loopback port for the length of the test and
+// returns its address.
+func startServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// testClient sends requests on a rtsp connection and reads the responses and
+// interleaved frames.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	cseq int
+	// frames keeps the interleaved frames read while waiting for a response.
+	frames []testFrame
+}
+
+type testFrame struct {
+	channel int
+	data    []byte
+}
+
+func dialTest(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a request with the given header lines and returns its response.
+func (c *testClient) do(method, url string, body []byte, header ...string) *client.Response {
+	c.cseq++
+	req := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\n", method, url, c.cseq)
+	for _, line := range header {
+		req += line + "\r\n"
+	}
+	if len(body) > 0 {
+		req += fmt.Sprintf("Content-Length: %d\r\n", len(body))
+	}
+	if _, err := io.WriteString(c.conn, req+"\r\n"+string(body)); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.response()
+}
+
+func (c *testClient) response() *client.Response {
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if b[0] == '$' {
+			c.frames = append(c.frames, c.frame())
+			continue
+		}
+		res, err := client.ReadResponse(c.r)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if cseq := res.Header.Get("CSeq"); cseq != strconv.Itoa(c.cseq) {
+			c.t.Fatalf("response CSeq %s, want %d", cseq, c.cseq)
+		}
+		if n, _ := strconv.Atoi(res.Header.Get("Content-Length")); n > 0 {
+			res.Body = make([]byte, n)
+			if _, err = io.ReadFull(c.r, res.Body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return res
+	}
+}
+
+// frame reads the next interleaved frame.
+func (c *testClient) frame() testFrame {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		c.t.Fatal(err)
+	}
+	if header[0] != '$' {
+		c.t.Fatalf("frame starts with %q", header[0])
+	}
+	f := testFrame{channel: int(header[1]), data: make([]byte, int(header[2])<<8|int(header[3]))}
+	if _, err := io.ReadFull(c.r, f.data); err != nil {
+		c.t.Fatal(err)
+	}
+	return f
+}
+
+// packet reads rtp off the interleaved channel.
+func (c *testClient) packet(channel int) rtp.Packet {
+	for {
+		f := c.frame()
+		if f.channel != channel {
+			continue
+		}
+		packet, err := rtp.ParsePacket(f.data, 0)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		return packet
+	}
+}
+
+func sessionOf(res *client.Response) string {
+	return strings.TrimSpace(strings.SplitN(res.Header.Get("Session"), ";", 2)[0])
+}
+
+// rtpTimeOf returns the rtptime of a track in a RTP-Info header.
+func rtpTimeOf(t *testing.T, rtpInfo, track string) uint32 {
+	for _, info := range strings.Split(rtpInfo, ",") {
+		if !strings.Contains(info, "url="+track+";") {
+			continue
+		}
+		for _, param := range strings.Split(info, ";") {
+			if strings.HasPrefix(param, "rtptime=") {
+				rtptime, err := strconv.ParseUint(strings.TrimPrefix(param, "rtptime="), 10, 32)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return uint32(rtptime)
+			}
+		}
+	}
+	t.Fatalf("no rtptime of %s in %q", track, rtpInfo)
+	return 0
+}
+
+// writeTestFile writes a flv file of 3 seconds of H264 video at 25 fps, with a
+// key frame every second, and AAC audio. Key frames are large enough to be
+// split into FU-A fragments.
+func writeTestFile(t *testing.T) string {
+	filename := filepath.Join(t.TempDir(), "clip.flv")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	muxer := flv.NewMuxer(f)
+	if err = muxer.WriteHeader([]av.CodecData{testH264(t), testAAC(t)}); err != nil {
+		t.Fatal(err)
+	}
+	frame := 40 * time.Millisecond
+	for i := 0; i < 75; i++ {
+		pkt := av.Packet{Idx: 0, Time: time.Duration(i) * frame, Data: avcc(testNalu(1, 500))}
+		if i%25 == 0 {
+			pkt.IsKeyFrame = true
+			pkt.Data = avcc(testNalu(5, 3000))
+		}
+		if err = muxer.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+		pkt = av.Packet{Idx: 1, Time: time.Duration(i) * frame, Data: bytes.Repeat([]byte{0x21}, 200)}
+		if err = muxer.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = muxer.WriteTrailer(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestPlayFile(t *testing.T) {
+	s := NewServer("")
+	s.HandleFile("/clip", writeTestFile(t))
+	addr := startServer(t, s)
+	c := dialTest(t, addr)
+	url := "rtsp://" + addr + "/clip"
+
+	res := c.do(client.DESCRIBE, url, nil, "Accept: application/sdp")
+	if res.StatusCode != client.OK || res.Header.Get("Content-Base") != url+"/" {
+		t.Fatalf("DESCRIBE %v", res)
+	}
+	session, err := sdp.ParseSdp(bytes.NewReader(res.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Medias) != 2 || session.Medias[0].CodecType != "H264" ||
+		session.Medias[1].CodecType != "MPEG4-GENERIC" {
+		t.Fatalf("DESCRIBE sdp %s", res.Body)
+	}
+
+	video, audio := url+"/trackID=0", url+"/trackID=1"
+	res = c.do(client.SETUP, video, nil, "Transport: RTP/AVP/TCP;unicast;interleaved=0-1")
+	id := sessionOf(res)
+	if res.StatusCode != client.OK || id == "" {
+		t.Fatalf("SETUP %v", res)
+	}
+	res = c.do(client.SETUP, audio, nil, "Transport: RTP/AVP/TCP;unicast;interleaved=2-3", "Session: "+id)
+	if res.StatusCode != client.OK || sessionOf(res) != id ||
+		!strings.Contains(res.Header.Get("Transport"), "interleaved=2-3") {
+		t.Fatalf("SETUP %v", res)
+	}
+
+	res = c.do(client.PLAY, url, nil, "Session: "+id)
+	if res.StatusCode != client.OK || !strings.Contains(res.Header.Get("RTP-Info"), "url="+video+";seq=") {
+		t.Fatalf("PLAY %v", res)
+	}
+	// the first key frame goes out with its parameter sets, then in fragments.
+	var types []byte
+	for len(types) < 5 {
+		packet := c.packet(0)
+		if packet.PayloadType != 96 {
+			t.Fatalf("video payload type %d", packet.PayloadType)
+		}
+		types = append(types, packet.Payload[0]&0x1f)
+	}
+	if !bytes.Equal(types, []byte{7, 8, 28, 28, 28}) {
+		t.Errorf("nalu types %v", types)
+	}
+
+	res = c.do(client.PAUSE, url, nil, "Session: "+id)
+	if res.StatusCode != client.OK {
+		t.Fatalf("PAUSE %v", res)
+	}
+	// nothing is sent once paused.
+	c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := c.r.Peek(1); err == nil {
+		t.Error("media sent after PAUSE")
+	}
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// the seek lands on the next key frame, the answer tells where.
+	res = c.do(client.PLAY, url, nil, "Session: "+id, "Range: npt=1.5-")
+	if res.StatusCode != client.OK || res.Header.Get("Range") != "npt=2.000-" {
+		t.Fatalf("PLAY %v", res)
+	}
+	videoTime := rtpTimeOf(t, res.Header.Get("RTP-Info"), video)
+	audioTime := rtpTimeOf(t, res.Header.Get("RTP-Info"), audio)
+	if packet := c.packet(0); uint32(packet.Timestamp) != videoTime || packet.Payload[0]&0x1f != 7 {
+		t.Errorf("video after seek at %d, type %d, want %d", packet.Timestamp, packet.Payload[0]&0x1f, videoTime)
+	}
+	if packet := c.packet(2); uint32(packet.Timestamp)-audioTime > 48000/10 {
+		t.Errorf("audio after seek at %d, want %d", packet.Timestamp, audioTime)
+	}
+
+	res = c.do(client.TEARDOWN, url, nil, "Session: "+id)
+	if res.StatusCode != client.OK {
+		t.Fatalf("TEARDOWN %v", res)
+	}
+	if res = c.do(client.PLAY, url, nil, "Session: "+id); res.StatusCode != client.SessionNotFound {
+		t.Errorf("PLAY after TEARDOWN %v", res)
+	}
+	if res = c.do(client.DESCRIBE, "rtsp://"+addr+"/missing", nil); res.StatusCode != client.NotFound {
+		t.Errorf("DESCRIBE missing path %v", res)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	s := NewServer("")
+	s.HandleFile("/clip", writeTestFile(t))
+	addr := startServer(t, s)
+	c := dialTest(t, addr)
+	url := "rtsp://" + addr + "/clip"
+	res := c.do(client.SETUP, url+"/trackID=0", nil, "Transport: RTP/AVP/TCP;unicast;interleaved=0-1")
+	if res.StatusCode != client.OK {
+		t.Fatalf("SETUP %v", res)
+	}
+
+	// the sessions are gone once Close returns.
+	s.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) != 0 || len(s.conns) != 0 {
+		t.Errorf("%d sessions and %d connections left", len(s.sessions), len(s.conns))
+	}
+}
+
+func TestSplitTrackPath(t *testing.T) {
+	tests := []struct {
+		p    string
+		path string
+		idx  int
+		ok   bool
+	}{
+		{"/clip.mp4/trackID=1", "/clip.mp4", 1, true},
+		{"/live/cam/trackID=0/", "/live/cam", 0, true},
+		{"clip.mp4/trackID=12", "/clip.mp4", 12, true},
+		{"/trackID=2", "/", 2, true},
+		{"/clip.mp4", "/clip.mp4", 0, false},
+		{"/clip.mp4/trackID=x", "/clip.mp4/trackID=x", 0, false},
+		{"/clip.mp4/streamid=0", "/clip.mp4/streamid=0", 0, false},
+		{"/../trackID=1", "/", 1, true},
+	}
+	for _, test := range tests {
+		path, idx, ok := splitTrackPath(test.p)
+		if path != test.path || idx != test.idx || ok != test.ok {
+			t.Errorf("%q: got %q %d %v", test.p, path, idx, ok)
+		}
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	addr := startServer(t, NewServer(""))
+	requests := []string{
+		"OPTIONS rtsp://host/ RTSP/1.0\r\nCSeq 1\r\n\r\n",
+		"OPTIONS rtsp://host/\r\nCSeq: 1\r\n\r\n",
+		"ANNOUNCE rtsp://host/ RTSP/1.0\r\nCSeq: 1\r\nContent-Length: -1\r\n\r\n",
+		"ANNOUNCE rtsp://host/ RTSP/1.0\r\nCSeq: 1\r\nContent-Length: 1000000000\r\n\r\n",
+	}
+	for _, request := range requests {
+		c := dialTest(t, addr)
+		io.WriteString(c.conn, request)
+		res, err := client.ReadResponse(c.r)
+		if err != nil {
+			t.Fatalf("%q: %v", request, err)
+		}
+		if res.StatusCode != client.BadRequest {
+			t.Errorf("%q: status %d", request, res.StatusCode)
+		}
+		// the connection is closed after the answer.
+		if _, err := c.r.ReadByte(); err != io.EOF {
+			t.Errorf("%q: connection left open, %v", request, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// maxPayloadSize keeps rtp packets below a common ethernet mtu.
+const maxPayloadSize = 1400
+
+// rtpFormat defines how a stream is carried over rtp.
+type rtpFormat struct {
+	PayloadType int
+	ClockRate   int
+}
+
+// newMedia describes the rtp format of a stream, dynamic payload types are
+// numbered from 96 by stream index.
+func newMedia(codec av.CodecData, idx int) (sdp.SessionSectionMedia, error) {
+	return sdp.NewMedia(codec, 96+idx)
+}
+
+// newRtpFormat chooses the rtp format of a stream.
+func newRtpFormat(codec av.CodecData, idx int) (format rtpFormat, err error) {
+	media, err := newMedia(codec, idx)
+	if err != nil {
+		return
+	}
+	return rtpFormat{PayloadType: media.PayloadType, ClockRate: media.TimeScale}, nil
+}
+
+// packetizer turns the av packets of one stream into rtp packets.
+type packetizer struct {
+	codec  av.CodecData
+	format rtpFormat
+
+	ssrc          uint32
+	sequence      uint16
+	timestampBase uint32
+}
+
+func newPacketizer(codec av.CodecData, idx int) (*packetizer, error) {
+	format, err := newRtpFormat(codec, idx)
+	if err != nil {
+		return nil, err
+	}
+	return &packetizer{
+		codec:         codec,
+		format:        format,
+		ssrc:          rand.Uint32(),
+		sequence:      uint16(rand.Uint32()),
+		timestampBase: rand.Uint32(),
+	}, nil
+}
+
+// rtpTime converts a media time into a rtp timestamp.
+func (p *packetizer) rtpTime(tm time.Duration) uint32 {
+	return p.timestampBase + uint32(int64(tm)*int64(p.format.ClockRate)/int64(time.Second))
+}
+
+// packetize splits an av packet into rtp packets.
+func (p *packetizer) packetize(pkt av.Packet) (packets []rtp.Packet) {
+	timestamp := p.rtpTime(pkt.Time + pkt.CompositionTime)
+
+	switch p.codec.Type() {
+	case av.H264:
+		nalus, _ := h264parser.SplitNALUs(pkt.Data)
+		if pkt.IsKeyFrame {
+			h264 := p.codec.(h264parser.CodecData)
+			nalus = append([][]byte{h264.SPS(), h264.PPS()}, nalus...)
+		}
+		for i, nalu := range nalus {
+			if len(nalu) == 0 {
+				continue
+			}
+			last := i == len(nalus)-1
+			if len(nalu) <= maxPayloadSize {
+				packets = append(packets, p.newPacket(timestamp, last, nalu))
+				continue
+			}
+			// FU-A, the nalu header is split between the FU indicator and the FU header.
+			fuIndicator := nalu[0]&0xe0 | 28
+			naluType := nalu[0] & 0x1f
+			data := nalu[1:]
+			for start := true; len(data) > 0; start = false {
+				n := len(data)
+				if n > maxPayloadSize-2 {
+					n = maxPayloadSize - 2
+				}
+				fuHeader := naluType
+				if start {
+					fuHeader |= 0x80
+				}
+				end := n == len(data)
+				if end {
+					fuHeader |= 0x40
+				}
+				packets = append(packets, p.newPacket(timestamp, last && end, []byte{fuIndicator, fuHeader}, data[:n]))
+				data = data[n:]
+			}
+		}
+
+	case av.AAC:
+		// RFC 3640 AAC-hbr, a single AU header of 13 bits size and 3 bits index.
+		size := len(pkt.Data)
+		header := []byte{0x00, 0x10, byte(size >> 5), byte(size<<3) & 0xf8}
+		packets = append(packets, p.newPacket(timestamp, true, header, pkt.Data))
+
+	default:
+		// G.711 carries one byte per sample.
+		data := pkt.Data
+		for len(data) > 0 {
+			n := len(data)
+			if n > maxPayloadSize {
+				n = maxPayloadSize
+			}
+			packets = append(packets, p.newPacket(timestamp, false, data[:n]))
+			data = data[n:]
+			timestamp += uint32(n)
+		}
+	}
+	return
+}
+
+func (p *packetizer) newPacket(timestamp uint32, marker bool, payload ...[]byte) rtp.Packet {
+	packet := rtp.Packet{
+		Version:        rtp.RTPVERSION,
+		Marker:         marker,
+		PayloadType:    byte(p.format.PayloadType),
+		SequenceNumber: uint(p.sequence),
+		Timestamp:      uint(timestamp),
+		SyncSource:     uint(p.ssrc),
+		Payload:        payload[0],
+	}
+	if len(payload) > 1 {
+		packet.Payload = nil
+		for _, b := range payload {
+			packet.Payload = append(packet.Payload, b...)
+		}
+	}
+	p.sequence++
+	return packet
+}
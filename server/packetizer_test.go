@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/solomondong/rtsp/rtp"
+)
+
+func testH264(t *testing.T) h264parser.CodecData {
+	sps, _ := base64.StdEncoding.DecodeString("Z2QAKqwsaoHgCJ+WbgICAgQA")
+	pps, _ := base64.StdEncoding.DecodeString("aO48sAA=")
+	h264, err := h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h264
+}
+
+func testAAC(t *testing.T) aacparser.CodecData {
+	aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x11, 0x90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aac
+}
+
+// avcc prefixes nalus with their length as in mp4 and flv packets.
+func avcc(nalus ...[]byte) (data []byte) {
+	for _, nalu := range nalus {
+		n := len(nalu)
+		data = append(data, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		data = append(data, nalu...)
+	}
+	return
+}
+
+func testNalu(typ byte, size int) []byte {
+	nalu := make([]byte, size)
+	nalu[0] = 0x60 | typ
+	for i := 1; i < size; i++ {
+		nalu[i] = byte(i)
+	}
+	return nalu
+}
+
+func newTestPacketizer(t *testing.T, c av.CodecData, idx int) *packetizer {
+	p, err := newPacketizer(c, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// checkSequence checks the packets are numbered one after another from the
+// packetizer's sequence before packetize.
+func checkSequence(t *testing.T, packets []rtp.Packet, first uint16) {
+	for i, packet := range packets {
+		if packet.SequenceNumber != uint(first+uint16(i)) {
+			t.Errorf("packet %d sequence %d, want %d", i, packet.SequenceNumber, first+uint16(i))
+		}
+	}
+}
+
+func TestPacketizeH264FUA(t *testing.T) {
+	p := newTestPacketizer(t, testH264(t), 0)
+	small := testNalu(6, 100)
+	large := testNalu(1, 3*(maxPayloadSize-2)+1)
+	sequence := p.sequence
+	packets := p.packetize(av.Packet{Time: time.Second, Data: avcc(small, large)})
+
+	// the small nalu goes as is, the large one in FU-A fragments.
+	if len(packets) != 4 {
+		t.Fatalf("%d packets", len(packets))
+	}
+	checkSequence(t, packets, sequence)
+	if !bytes.Equal(packets[0].Payload, small) || packets[0].Marker {
+		t.Errorf("single nalu packet %+v", packets[0])
+	}
+	var data []byte
+	for i, packet := range packets[1:] {
+		payload := packet.Payload
+		if len(payload) > maxPayloadSize {
+			t.Errorf("fragment %d of %d bytes", i, len(payload))
+		}
+		if payload[0] != large[0]&0xe0|28 {
+			t.Errorf("fragment %d indicator %#x", i, payload[0])
+		}
+		start, end := payload[1]&0x80 != 0, payload[1]&0x40 != 0
+		if start != (i == 0) || end != (i == 2) || payload[1]&0x1f != large[0]&0x1f {
+			t.Errorf("fragment %d header %#x", i, payload[1])
+		}
+		if packet.Marker != (i == 2) {
+			t.Errorf("fragment %d marker %v", i, packet.Marker)
+		}
+		if packet.Timestamp != uint(p.rtpTime(time.Second)) {
+			t.Errorf("fragment %d timestamp %d", i, packet.Timestamp)
+		}
+		data = append(data, payload[2:]...)
+	}
+	if !bytes.Equal(data, large[1:]) {
+		t.Error("fragments don't rebuild the nalu")
+	}
+}
+
+func TestPacketizeH264KeyFrame(t *testing.T) {
+	h264 := testH264(t)
+	p := newTestPacketizer(t, h264, 0)
+	idr := testNalu(5, 200)
+	packets := p.packetize(av.Packet{IsKeyFrame: true, Data: avcc(idr)})
+	if len(packets) != 3 {
+		t.Fatalf("%d packets", len(packets))
+	}
+	// the parameter sets go ahead of every key frame.
+	if !bytes.Equal(packets[0].Payload, h264.SPS()) || !bytes.Equal(packets[1].Payload, h264.PPS()) ||
+		!bytes.Equal(packets[2].Payload, idr) || !packets[2].Marker {
+		t.Errorf("key frame packets %+v", packets)
+	}
+	if packets[0].PayloadType != 96 {
+		t.Errorf("payload type %d", packets[0].PayloadType)
+	}
+}
+
+func TestPacketizeAAC(t *testing.T) {
+	p := newTestPacketizer(t, testAAC(t), 1)
+	frame := bytes.Repeat([]byte{0xaa}, 300)
+	packets := p.packetize(av.Packet{Idx: 1, Data: frame})
+	if len(packets) != 1 {
+		t.Fatalf("%d packets", len(packets))
+	}
+	payload := packets[0].Payload
+	// AU-headers-length of 16 bits, then one AU header of 13 bits size and 3 bits index.
+	if headersLength := int(payload[0])<<8 | int(payload[1]); headersLength != 16 {
+		t.Errorf("AU headers length %d", headersLength)
+	}
+	if size, index := int(payload[2])<<5|int(payload[3])>>3, payload[3]&0x07; size != len(frame) || index != 0 {
+		t.Errorf("AU header size %d index %d", size, index)
+	}
+	if !bytes.Equal(payload[4:], frame) || !packets[0].Marker || packets[0].PayloadType != 97 {
+		t.Errorf("aac packet %+v", packets[0])
+	}
+}
+
+func TestPacketizeG711(t *testing.T) {
+	p := newTestPacketizer(t, codec.NewPCMMulawCodecData(), 1)
+	sequence := p.sequence
+	packets := p.packetize(av.Packet{Time: 20 * time.Millisecond, Data: make([]byte, 2*maxPayloadSize+200)})
+	if len(packets) != 3 {
+		t.Fatalf("%d packets", len(packets))
+	}
+	checkSequence(t, packets, sequence)
+	// each chunk is stamped with the sample it starts at.
+	timestamp := p.rtpTime(20 * time.Millisecond)
+	for i, size := range []int{maxPayloadSize, maxPayloadSize, 200} {
+		if len(packets[i].Payload) != size || packets[i].Timestamp != uint(timestamp) || packets[i].PayloadType != 0 {
+			t.Errorf("chunk %d: %d bytes, timestamp %d", i, len(packets[i].Payload), packets[i].Timestamp)
+		}
+		timestamp += uint32(size)
+	}
+}
+
+func TestPacketizeTimestamp(t *testing.T) {
+	tests := []struct {
+		codec av.CodecData
+		delta time.Duration
+		ticks uint32
+	}{
+		{testH264(t), 40 * time.Millisecond, 3600},
+		{testAAC(t), time.Second, 48000},
+		{codec.NewPCMAlawCodecData(), 20 * time.Millisecond, 160},
+	}
+	for _, test := range tests {
+		p := newTestPacketizer(t, test.codec, 0)
+		// the second timestamp wraps around like any other rtp timestamp.
+		p.timestampBase = 0
+		p.timestampBase = -p.rtpTime(time.Hour) - test.ticks/2
+		data := avcc(testNalu(1, 10))
+		first := p.packetize(av.Packet{Time: time.Hour, Data: data})
+		second := p.packetize(av.Packet{Time: time.Hour + test.delta, Data: data})
+		if advance := uint32(second[0].Timestamp) - uint32(first[0].Timestamp); advance != test.ticks {
+			t.Errorf("%v: advanced %d, want %d", test.codec.Type(), advance, test.ticks)
+		}
+		if second[0].SyncSource != first[0].SyncSource {
+			t.Errorf("%v: ssrc changed", test.codec.Type())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/nareix/joy4/av"
+)
+
+// readerQueueSize is how many packets a slow reader may fall behind before it
+// starts losing packets.
+const readerQueueSize = 256
+
+// publication defines a stream pushed to the server through ANNOUNCE/RECORD,
+// which readers subscribe to.
+type publication struct {
+	path string
+
+	mu      sync.Mutex
+	streams []av.CodecData
+	readers map[*reader]bool
+	closed  bool
+}
+
+func newPublication(path string, streams int) *publication {
+	return &publication{
+		path:    path,
+		streams: make([]av.CodecData, streams),
+		readers: make(map[*reader]bool),
+	}
+}
+
+// setCodecData records the codec data of a stream once the publisher has sent it.
+func (p *publication) setCodecData(idx int, codec av.CodecData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams[idx] = codec
+}
+
+func (p *publication) hasVideo() bool {
+	for _, stream := range p.streams {
+		if stream != nil && stream.Type().IsVideo() {
+			return true
+		}
+	}
+	return false
+}
+
+// write hands a packet to every reader. Readers start, and restart after
+// falling behind, at a video key frame.
+func (p *publication) write(pkt av.Packet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hasVideo := p.hasVideo()
+	isKeyFrame := pkt.IsKeyFrame && p.streams[pkt.Idx] != nil && p.streams[pkt.Idx].Type().IsVideo()
+	for r := range p.readers {
+		if r.waitKeyFrame {
+			if hasVideo && !isKeyFrame {
+				continue
+			}
+			r.waitKeyFrame = false
+		}
+		select {
+		case r.packets <- pkt:
+		default:
+			r.waitKeyFrame = true
+		}
+	}
+}
+
+// subscribe adds a new reader.
+func (p *publication) subscribe() (Source, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, io.EOF
+	}
+	r := &reader{
+		publication:  p,
+		packets:      make(chan av.Packet, readerQueueSize),
+		done:         make(chan struct{}),
+		waitKeyFrame: true,
+	}
+	p.readers[r] = true
+	return r, nil
+}
+
+// close ends the publication, its readers get io.EOF.
+func (p *publication) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for r := range p.readers {
+		close(r.done)
+	}
+	p.readers = nil
+}
+
+// reader defines a Source reading packets from a publication.
+type reader struct {
+	publication  *publication
+	packets      chan av.Packet
+	done         chan struct{}
+	waitKeyFrame bool
+}
+
+// Streams returns the codec data of the publication.
+func (r *reader) Streams() ([]av.CodecData, error) {
+	r.publication.mu.Lock()
+	defer r.publication.mu.Unlock()
+	streams := make([]av.CodecData, len(r.publication.streams))
+	for i, stream := range r.publication.streams {
+		if stream == nil {
+			return nil, errors.New("rtsp: publication codec data not ready")
+		}
+		streams[i] = stream
+	}
+	return streams, nil
+}
+
+// ReadPacket waits for the next packet of the publication.
+func (r *reader) ReadPacket() (av.Packet, error) {
+	select {
+	case pkt := <-r.packets:
+		return pkt, nil
+	case <-r.done:
+		return av.Packet{}, io.EOF
+	}
+}
+
+// Close unsubscribes the reader.
+func (r *reader) Close() error {
+	p := r.publication
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.readers[r] {
+		delete(p.readers, r)
+		close(r.done)
+	}
+	return nil
+}
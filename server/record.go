@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/solomondong/rtsp/client"
+	"github.com/solomondong/rtsp/rtp"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// newRecordSession creates a session receiving the streams announced by a publisher.
+func newRecordSession(c *conn, path string, medias []sdp.SessionSectionMedia) *session {
+	s := &session{
+		id:          newSessionID(),
+		path:        path,
+		conn:        c,
+		tracks:      make([]*track, len(medias)),
+		publication: newPublication(path, len(medias)),
+	}
+	for idx, media := range medias {
+		stream := &client.Stream{Sdp: media}
+		if stream.MakeCodecData() == nil {
+			s.publication.setCodecData(idx, stream.CodecData)
+		}
+		s.inputs = append(s.inputs, stream)
+	}
+	return s
+}
+
+// inputIndex finds the announced stream a SETUP url refers to by its control attribute.
+func (s *session) inputIndex(url string) (int, bool) {
+	if len(s.inputs) == 1 && s.inputs[0].Sdp.Control == "" {
+		return 0, true
+	}
+	for idx, stream := range s.inputs {
+		control := stream.Sdp.Control
+		if control != "" && (url == control || strings.HasSuffix(url, "/"+control)) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// setupRecord sets up an announced stream for receiving.
+func (s *session) setupRecord(idx int, t transport) (transport, error) {
+	if idx < 0 || idx >= len(s.inputs) {
+		return t, errors.New("rtsp: no such track")
+	}
+	tr := &track{idx: idx, transport: t}
+	if t.TCP {
+		if len(t.Interleaved) != 2 {
+			tr.transport.Interleaved = []int{2 * idx, 2*idx + 1}
+		}
+	} else {
+		var err error
+		if tr.rtpConn, tr.rtcpConn, err = rtp.ListenUDPPair(); err != nil {
+			return t, err
+		}
+		tr.transport.ServerPort = []int{
+			tr.rtpConn.LocalAddr().(*net.UDPAddr).Port,
+			tr.rtcpConn.LocalAddr().(*net.UDPAddr).Port,
+		}
+	}
+
+	if old := s.tracks[idx]; old != nil {
+		old.close()
+	}
+	s.tracks[idx] = tr
+	return tr.transport, nil
+}
+
+// record starts receiving the streams set up over udp, interleaved packets
+// are handed over by the connection.
+func (s *session) record() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recording {
+		return
+	}
+	s.recording = true
+
+	host, _, _ := net.SplitHostPort(s.conn.netConn.RemoteAddr().String())
+	source := &net.UDPAddr{IP: net.ParseIP(host)}
+	for _, t := range s.tracks {
+		if t == nil || t.transport.TCP {
+			continue
+		}
+		if s.udp == nil {
+			s.udp = rtp.NewUDPSessionConfig(rtp.DefaultUDPConfig)
+		}
+		// the source port is left open, publishers behind a NAT don't send
+		// from the client port they announced.
+		s.udp.AddStream(&rtp.UDPStream{Idx: uint(t.idx), Rtp: t.rtpConn, Rtcp: t.rtcpConn, RtpSource: source, RtcpSource: source})
+	}
+	if s.udp != nil {
+		go s.readUDP(s.udp)
+	}
+}
+
+// readUDP depacketizes the packets received over udp until the session closes.
+func (s *session) readUDP(udp *rtp.UDPSession) {
+	go func() {
+		// rtcp of publishers is not used yet.
+		for range udp.RtcpChan {
+		}
+	}()
+	for packet := range udp.RtpChan {
+		s.handleRtp(packet)
+	}
+}
+
+// interleavedTrack finds the track receiving rtp on an interleaved channel.
+func (s *session) interleavedTrack(channel int) (*track, bool) {
+	if !s.recording {
+		return nil, false
+	}
+	for _, t := range s.tracks {
+		if t != nil && t.transport.TCP && t.transport.Interleaved[0] == channel {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// handleRtp depacketizes a rtp packet of a published stream and hands the
+// resulting av packet to the readers.
+func (s *session) handleRtp(packet rtp.Packet) {
+	idx := int(packet.StreamIdx)
+	stream := s.inputs[idx]
+	pkt, ok, err := stream.HandleRtpPacket(packet)
+	rtp.Release(packet)
+	if stream.CodecData == nil && stream.MakeCodecData() == nil {
+		s.publication.setCodecData(idx, stream.CodecData)
+	}
+	if err != nil || !ok {
+		return
+	}
+	s.publication.write(pkt)
+}
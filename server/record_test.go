@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/solomondong/rtsp/client"
+	"github.com/solomondong/rtsp/rtp"
+)
+
+const testAnnounceSdp = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=publisher\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 0 RTP/AVP 0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=control:streamid=0\r\n"
+
+// writeFrame sends a packet on an interleaved channel.
+func (c *testClient) writeFrame(channel int, packet rtp.Packet) {
+	data, err := packet.Marshal()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	frame := append([]byte{'$', byte(channel), byte(len(data) >> 8), byte(len(data))}, data...)
+	if _, err = c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// announce publishes the test sdp on url and starts recording over
+// interleaved tcp.
+func (c *testClient) announce(url string) *client.Response {
+	res := c.do(client.ANNOUNCE, url, []byte(testAnnounceSdp), "Content-Type: application/sdp")
+	if res.StatusCode != client.OK {
+		return res
+	}
+	id := sessionOf(res)
+	res = c.do(client.SETUP, url+"/streamid=0", nil, "Session: "+id,
+		"Transport: RTP/AVP/TCP;unicast;interleaved=0-1;mode=record")
+	if res.StatusCode != client.OK {
+		c.t.Fatalf("SETUP record %v", res)
+	}
+	if res = c.do(client.RECORD, url, nil, "Session: "+id); res.StatusCode != client.OK {
+		c.t.Fatalf("RECORD %v", res)
+	}
+	return res
+}
+
+func TestRecord(t *testing.T) {
+	s := NewServer("")
+	addr := startServer(t, s)
+	url := "rtsp://" + addr + "/live"
+
+	publisher := dialTest(t, addr)
+	if res := publisher.announce(url); res.StatusCode != client.OK {
+		t.Fatalf("ANNOUNCE %v", res)
+	}
+
+	// a path has a single publisher.
+	if res := dialTest(t, addr).do(client.ANNOUNCE, url, []byte(testAnnounceSdp),
+		"Content-Type: application/sdp"); res.StatusCode != client.MethodNotValidInThisState {
+		t.Errorf("second ANNOUNCE %v", res)
+	}
+
+	reader := dialTest(t, addr)
+	if res := reader.do(client.DESCRIBE, url, nil); res.StatusCode != client.OK ||
+		!bytes.Contains(res.Body, []byte("a=rtpmap:0 PCMU/8000")) {
+		t.Fatalf("DESCRIBE %v %s", res, res.Body)
+	}
+	res := reader.do(client.SETUP, url+"/trackID=0", nil, "Transport: RTP/AVP/TCP;unicast;interleaved=0-1")
+	if res.StatusCode != client.OK {
+		t.Fatalf("SETUP %v", res)
+	}
+	if res = reader.do(client.PLAY, url, nil, "Session: "+sessionOf(res)); res.StatusCode != client.OK {
+		t.Fatalf("PLAY %v", res)
+	}
+
+	// the reader gets the publisher's samples in order.
+	for i := 0; i < 5; i++ {
+		publisher.writeFrame(0, rtp.Packet{
+			Version:        rtp.RTPVERSION,
+			SequenceNumber: uint(100 + i),
+			Timestamp:      uint(8000 + 160*i),
+			SyncSource:     0x1234,
+			Payload:        bytes.Repeat([]byte{byte(i)}, 160),
+		})
+	}
+	for i := 0; i < 5; i++ {
+		packet := reader.packet(0)
+		if packet.PayloadType != 0 || !bytes.Equal(packet.Payload, bytes.Repeat([]byte{byte(i)}, 160)) {
+			t.Fatalf("relayed packet %d: %+v", i, packet)
+		}
+	}
+
+	// the publication ends with its publisher's connection.
+	publisher.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		pub := s.publications["/live"]
+		s.mu.Unlock()
+		if pub == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("publication left after the publisher disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if res := dialTest(t, addr).do(client.DESCRIBE, url, nil); res.StatusCode != client.NotFound {
+		t.Errorf("DESCRIBE after publisher left %v", res)
+	}
+	if res := dialTest(t, addr).announce(url); res.StatusCode != client.OK {
+		t.Errorf("ANNOUNCE after publisher left %v", res)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/solomondong/rtsp/sdp"
+)
+
+// makeSdp describes the streams of a source as a sdp session. Control urls are
+// made absolute against base so that clients don't have to resolve them.
+func makeSdp(streams []av.CodecData, base string) ([]byte, error) {
+	id := strconv.FormatInt(time.Now().Unix(), 10)
+	session := sdp.SessionSection{
+		Originator: sdp.SessionSectionOriginator{
+			UserName:    "-",
+			SessionID:   id,
+			Version:     id,
+			NetworkType: "IN",
+			AddressType: "IP4",
+			Address:     "0.0.0.0",
+		},
+		SessionName:           "Media Server",
+		ConnectionInformation: sdp.ConnectionInformation{NetworkType: "IN", AddressType: "IP4", Address: "0.0.0.0"},
+		Attributes: []sdp.Attribute{
+			{Key: "control", Value: base},
+			{Key: "range", Value: "npt=0-"},
+		},
+	}
+
+	for idx, stream := range streams {
+		media, err := newMedia(stream, idx)
+		if err != nil {
+			return nil, err
+		}
+		media.Control = trackURL(base, idx)
+		media.Attributes = append(media.Attributes, sdp.Attribute{Key: "control", Value: media.Control})
+		session.Medias = append(session.Medias, media)
+	}
+	return session.Marshal(), nil
+}
+
+// trackURL returns the control url of a stream.
+func trackURL(base string, idx int) string {
+	return fmt.Sprintf("%s/trackID=%d", strings.TrimSuffix(base, "/"), idx)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Server defines a rtsp server serving media sources to rtsp clients.
+type Server struct {
+	// Addr is the tcp address to listen on, ":554" if empty.
+	Addr string
+	// Root is a directory media files are served from when no source is
+	// registered for a path, e.g. rtsp://host/clips/a.mp4 serves Root/clips/a.mp4.
+	Root string
+	// Auth, when set, authenticates clients and authorizes reading and
+	// publishing paths.
+	Auth *Authenticator
+
+	mu           sync.Mutex
+	sources      map[string]SourceFunc
+	publications map[string]*publication
+	sessions     map[string]*session
+	conns        map[*conn]bool
+	listener     net.Listener
+
+	debug bool
+}
+
+// NewServer creates a new rtsp server listening on addr.
+func NewServer(addr string) *Server {
+	return &Server{
+		Addr:         addr,
+		sources:      make(map[string]SourceFunc),
+		publications: make(map[string]*publication),
+		sessions:     make(map[string]*session),
+		conns:        make(map[*conn]bool),
+	}
+}
+
+// Debug turns on printing of requests and responses.
+func (s *Server) Debug(bl bool) {
+	s.debug = bl
+}
+
+// Handle registers a source for a path.
+func (s *Server) Handle(path string, open SourceFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[cleanPath(path)] = open
+}
+
+// HandleFile registers a media file for a path.
+func (s *Server) HandleFile(path, filename string) {
+	s.Handle(path, func() (Source, error) {
+		return OpenFile(filename)
+	})
+}
+
+// lookup finds the source for a path. A live publication comes first, then a
+// registered source, then a file under Root.
+func (s *Server) lookup(path string) (SourceFunc, bool) {
+	s.mu.Lock()
+	pub := s.publications[path]
+	open, ok := s.sources[path]
+	s.mu.Unlock()
+	if pub != nil {
+		return pub.subscribe, true
+	}
+	if ok || s.Root == "" {
+		return open, ok
+	}
+
+	filename := filepath.Join(s.Root, filepath.FromSlash(path))
+	if info, err := os.Stat(filename); err != nil || info.IsDir() {
+		return nil, false
+	}
+	return func() (Source, error) {
+		return OpenFile(filename)
+	}, true
+}
+
+// ListenAndServe listens on Addr and serves rtsp connections.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":554"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts rtsp connections on l until the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.mu.Unlock()
+		return errors.New("rtsp: server already serving")
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if !s.allowed(netConn) {
+			netConn.Close()
+			continue
+		}
+		c := newConn(s, netConn)
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// allowed checks the address of a new connection against the allow and deny
+// lists of Auth.
+func (s *Server) allowed(netConn net.Conn) bool {
+	if s.Auth == nil {
+		return true
+	}
+	host, _, _ := net.SplitHostPort(netConn.RemoteAddr().String())
+	return s.Auth.ipAllowed(net.ParseIP(host))
+}
+
+// Close stops listening and closes every connection. It returns once the
+// sessions and publications of the connections are closed too.
+func (s *Server) Close() (err error) {
+	s.mu.Lock()
+	l := s.listener
+	s.mu.Unlock()
+	if l != nil {
+		err = l.Close()
+	}
+
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.netConn.Close()
+	}
+	for _, c := range conns {
+		<-c.closed
+	}
+	return
+}
+
+func (s *Server) addSession(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sess.id] = sess
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess.id)
+	if sess.publication != nil && s.publications[sess.path] == sess.publication {
+		delete(s.publications, sess.path)
+	}
+}
+
+// addPublication reserves a path for a publisher, it fails when the path is
+// already being published.
+func (s *Server) addPublication(pub *publication) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.publications[pub.path] != nil {
+		return false
+	}
+	s.publications[pub.path] = pub
+	return true
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// cleanPath normalizes a request path, e.g. "live/cam1/" to "/live/cam1". The
+// result never climbs above the root.
+func cleanPath(p string) string {
+	return pathpkg.Clean("/" + strings.Trim(p, "/"))
+}
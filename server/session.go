@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/solomondong/rtsp/client"
+	"github.com/solomondong/rtsp/rtp"
+)
+
+// sessionTimeout is announced to clients in the Session header, in seconds.
+const sessionTimeout = 60
+
+// session defines the server side of a rtsp session.
+type session struct {
+	id     string
+	path   string
+	conn   *conn
+	source Source
+
+	streams []av.CodecData
+	tracks  []*track
+
+	mu      sync.Mutex
+	playing bool
+	stop    chan struct{}
+	done    chan struct{}
+	// pending is the first packet after a seek, read ahead to learn where
+	// the seek landed.
+	pending *av.Packet
+
+	// a publishing session depacketizes its inputs into the publication.
+	publication *publication
+	inputs      []*client.Stream
+	recording   bool
+	udp         *rtp.UDPSession
+}
+
+// track defines a stream set up in a session.
+type track struct {
+	idx        int
+	packetizer *packetizer
+	transport  transport
+
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+	rtpAddr  *net.UDPAddr
+	rtcpAddr *net.UDPAddr
+
+	// buf is reused to marshal outgoing packets, it keeps room in front
+	// for the interleaved frame header.
+	buf []byte
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newSession(c *conn, path string, source Source) (*session, error) {
+	streams, err := source.Streams()
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		id:      newSessionID(),
+		path:    path,
+		conn:    c,
+		source:  source,
+		streams: streams,
+		tracks:  make([]*track, len(streams)),
+	}, nil
+}
+
+// setup sets up the stream idx with the transport the client asked for and
+// returns the transport to reply with.
+func (s *session) setup(idx int, t transport) (transport, error) {
+	if idx < 0 || idx >= len(s.streams) {
+		return t, errors.New("rtsp: no such track")
+	}
+	p, err := newPacketizer(s.streams[idx], idx)
+	if err != nil {
+		return t, err
+	}
+	tr := &track{idx: idx, packetizer: p, transport: t}
+
+	if t.TCP {
+		if len(t.Interleaved) != 2 {
+			tr.transport.Interleaved = []int{2 * idx, 2*idx + 1}
+		}
+	} else {
+		host, _, _ := net.SplitHostPort(s.conn.netConn.RemoteAddr().String())
+		ip := net.ParseIP(host)
+		if tr.rtpConn, tr.rtcpConn, err = rtp.ListenUDPPair(); err != nil {
+			return t, err
+		}
+		tr.rtpAddr = &net.UDPAddr{IP: ip, Port: t.ClientPort[0]}
+		tr.rtcpAddr = &net.UDPAddr{IP: ip, Port: t.ClientPort[1]}
+		tr.transport.ServerPort = []int{
+			tr.rtpConn.LocalAddr().(*net.UDPAddr).Port,
+			tr.rtcpConn.LocalAddr().(*net.UDPAddr).Port,
+		}
+	}
+
+	if old := s.tracks[idx]; old != nil {
+		old.close()
+	}
+	s.tracks[idx] = tr
+	return tr.transport, nil
+}
+
+func (t *track) writeRtp(c *conn, packet rtp.Packet) error {
+	if size := 4 + packet.MarshalSize(); len(t.buf) < size {
+		t.buf = make([]byte, size)
+	}
+	n, err := packet.MarshalTo(t.buf[4:])
+	if err != nil {
+		return err
+	}
+	if t.transport.TCP {
+		return c.writeInterleaved(t.transport.Interleaved[0], t.buf[:4+n])
+	}
+	_, err = t.rtpConn.WriteToUDP(t.buf[4:4+n], t.rtpAddr)
+	return err
+}
+
+func (t *track) close() {
+	if t.rtpConn != nil {
+		t.rtpConn.Close()
+		t.rtcpConn.Close()
+	}
+}
+
+// seek moves the source to tm and returns the time of its first packet
+// there, sources which can't seek are left alone.
+func (s *session) seek(tm time.Duration) (time.Duration, error) {
+	seeker, ok := s.source.(Seeker)
+	if !ok {
+		return tm, nil
+	}
+	if err := seeker.SeekToTime(tm); err != nil {
+		return 0, err
+	}
+	pkt, err := s.source.ReadPacket()
+	if err != nil {
+		return 0, err
+	}
+	s.pending = &pkt
+	return pkt.Time, nil
+}
+
+// play starts sending packets, scale speeds up delivery relative to real time.
+func (s *session) play(scale float64) {
+	s.pause()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playing = true
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(s.stop, s.done, scale)
+}
+
+// pause stops sending packets and waits for the sender to return.
+func (s *session) pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.playing {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.playing = false
+}
+
+func (s *session) run(stop, done chan struct{}, scale float64) {
+	defer close(done)
+
+	begin := time.Now()
+	first := time.Duration(-1)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		var pkt av.Packet
+		var err error
+		if s.pending != nil {
+			pkt, s.pending = *s.pending, nil
+		} else if pkt, err = s.source.ReadPacket(); err != nil {
+			return
+		}
+		if int(pkt.Idx) >= len(s.tracks) || s.tracks[pkt.Idx] == nil {
+			continue
+		}
+		t := s.tracks[pkt.Idx]
+		// When playing faster than real time only video is delivered.
+		if scale != 1 && !s.streams[pkt.Idx].Type().IsVideo() {
+			continue
+		}
+
+		if first < 0 {
+			first = pkt.Time
+		}
+		if wait := time.Duration(float64(pkt.Time-first)/scale) - time.Since(begin); wait > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		for _, packet := range t.packetizer.packetize(pkt) {
+			if err = t.writeRtp(s.conn, packet); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close stops the session and releases its source and sockets. Closing the
+// source first unblocks a sender waiting on a live source.
+func (s *session) close() {
+	if s.source != nil {
+		s.source.Close()
+	}
+	s.pause()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.publication != nil {
+		s.publication.close()
+	}
+	for _, t := range s.tracks {
+		if t != nil {
+			t.close()
+		}
+	}
+}
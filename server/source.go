@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/format/flv"
+	"github.com/nareix/joy4/format/mp4"
+)
+
+// Source defines a media source which can be served to rtsp clients.
+// Every client session gets a source of its own.
+type Source interface {
+	av.Demuxer
+	Close() error
+}
+
+// Seeker is implemented by sources which honour the Range header.
+type Seeker interface {
+	SeekToTime(tm time.Duration) error
+}
+
+// SourceFunc opens a new source for a client session.
+type SourceFunc func() (Source, error)
+
+// FileSource defines a source reading packets from a local MP4 or FLV file.
+type FileSource struct {
+	filename string
+	file     *os.File
+	demuxer  av.Demuxer
+
+	// pending holds a packet read ahead while seeking.
+	pending *av.Packet
+}
+
+// OpenFile opens a media file as a source, the format is chosen by the file extension.
+func OpenFile(filename string) (*FileSource, error) {
+	f := &FileSource{filename: filename}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileSource) open() (err error) {
+	if f.file, err = os.Open(f.filename); err != nil {
+		return
+	}
+	switch strings.ToLower(filepath.Ext(f.filename)) {
+	case ".mp4", ".m4v", ".m4a", ".mov":
+		f.demuxer = mp4.NewDemuxer(f.file)
+	case ".flv":
+		f.demuxer = flv.NewDemuxer(f.file)
+	default:
+		f.file.Close()
+		err = fmt.Errorf("rtsp: unsupported media file %s", f.filename)
+	}
+	return
+}
+
+// Streams returns the codec data of every stream in the file.
+func (f *FileSource) Streams() ([]av.CodecData, error) {
+	return f.demuxer.Streams()
+}
+
+// ReadPacket reads the next packet of the file.
+func (f *FileSource) ReadPacket() (av.Packet, error) {
+	if f.pending != nil {
+		pkt := *f.pending
+		f.pending = nil
+		return pkt, nil
+	}
+	return f.demuxer.ReadPacket()
+}
+
+// SeekToTime moves the file to the key frame at or before tm. Formats without an
+// index are reopened and read forward up to the first key frame at or after tm.
+func (f *FileSource) SeekToTime(tm time.Duration) (err error) {
+	f.pending = nil
+	if seeker, ok := f.demuxer.(Seeker); ok {
+		return seeker.SeekToTime(tm)
+	}
+
+	var streams []av.CodecData
+	if streams, err = f.demuxer.Streams(); err != nil {
+		return
+	}
+	hasVideo := false
+	for _, stream := range streams {
+		if stream.Type().IsVideo() {
+			hasVideo = true
+		}
+	}
+
+	f.file.Close()
+	if err = f.open(); err != nil {
+		return
+	}
+	for {
+		var pkt av.Packet
+		if pkt, err = f.demuxer.ReadPacket(); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("rtsp: seek to %v beyond end of %s", tm, f.filename)
+			}
+			return
+		}
+		if pkt.Time < tm {
+			continue
+		}
+		if !hasVideo || (streams[pkt.Idx].Type().IsVideo() && pkt.IsKeyFrame) {
+			f.pending = &pkt
+			return
+		}
+	}
+}
+
+// Close closes the file.
+func (f *FileSource) Close() error {
+	return f.file.Close()
+}
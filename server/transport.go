@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transport defines one option of a Transport header.
+type transport struct {
+	Protocol    string
+	TCP         bool
+	Multicast   bool
+	Interleaved []int
+	ClientPort  []int
+	ServerPort  []int
+	Mode        string
+}
+
+// parseTransport returns the first option of a Transport header this server supports.
+func parseTransport(header string) (t transport, err error) {
+	for _, option := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(option), ";")
+		t = transport{Protocol: fields[0]}
+		switch fields[0] {
+		case "RTP/AVP", "RTP/AVP/UDP":
+		case "RTP/AVP/TCP":
+			t.TCP = true
+		default:
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			switch kv[0] {
+			case "multicast":
+				t.Multicast = true
+			case "interleaved", "client_port", "server_port":
+				if len(kv) != 2 {
+					return t, fmt.Errorf("rtsp: transport %s has no value", kv[0])
+				}
+				var ports []int
+				if ports, err = parsePortRange(kv[1]); err != nil {
+					return
+				}
+				switch kv[0] {
+				case "interleaved":
+					t.Interleaved = ports
+				case "client_port":
+					t.ClientPort = ports
+				case "server_port":
+					t.ServerPort = ports
+				}
+			case "mode":
+				if len(kv) == 2 {
+					t.Mode = strings.ToLower(strings.Trim(kv[1], "\""))
+				}
+			}
+		}
+		if t.Multicast {
+			continue
+		}
+		if !t.TCP && len(t.ClientPort) == 0 {
+			return t, errors.New("rtsp: transport without client_port")
+		}
+		return t, nil
+	}
+	return t, errors.New("rtsp: no supported transport")
+}
+
+func parsePortRange(s string) (ports []int, err error) {
+	for _, part := range strings.SplitN(s, "-", 2) {
+		var port int
+		if port, err = strconv.Atoi(part); err != nil {
+			return nil, fmt.Errorf("rtsp: invalid port range %s", s)
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 1 {
+		ports = append(ports, ports[0]+1)
+	}
+	return
+}
+
+// String formats the transport as a Transport header.
+func (t transport) String() string {
+	s := t.Protocol + ";unicast"
+	if len(t.Interleaved) == 2 {
+		s += fmt.Sprintf(";interleaved=%d-%d", t.Interleaved[0], t.Interleaved[1])
+	}
+	if len(t.ClientPort) == 2 {
+		s += fmt.Sprintf(";client_port=%d-%d", t.ClientPort[0], t.ClientPort[1])
+	}
+	if len(t.ServerPort) == 2 {
+		s += fmt.Sprintf(";server_port=%d-%d", t.ServerPort[0], t.ServerPort[1])
+	}
+	if t.Mode != "" {
+		s += ";mode=" + t.Mode
+	}
+	return s
+}
+
+// parseRange parses the start of a npt Range header, e.g. npt=10.5- or npt=00:01:30-.
+// ok is false when the range doesn't ask for a position, e.g. npt=now-.
+func parseRange(header string) (start time.Duration, ok bool, err error) {
+	if !strings.HasPrefix(header, "npt=") {
+		err = fmt.Errorf("rtsp: unsupported range %s", header)
+		return
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(header, "npt="), "-", 2)
+	if bounds[0] == "" || bounds[0] == "now" {
+		return
+	}
+	var seconds float64
+	for _, part := range strings.Split(bounds[0], ":") {
+		var v float64
+		if v, err = strconv.ParseFloat(part, 64); err != nil || v < 0 {
+			err = fmt.Errorf("rtsp: invalid range %s", header)
+			return
+		}
+		seconds = seconds*60 + v
+	}
+	return time.Duration(seconds * float64(time.Second)), true, nil
+}
+
+// formatNpt formats a media time as npt seconds.
+func formatNpt(tm time.Duration) string {
+	return strconv.FormatFloat(tm.Seconds(), 'f', 3, 64)
+}
+
+// parseScale parses a Scale header, only forward play is supported.
+func parseScale(header string) (scale float64, err error) {
+	if scale, err = strconv.ParseFloat(strings.TrimSpace(header), 64); err != nil || scale <= 0 {
+		return 0, fmt.Errorf("rtsp: unsupported scale %s", header)
+	}
+	return
+}
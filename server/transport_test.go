@@ -0,0 +1,106 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTransport(t *testing.T) {
+	tests := []struct {
+		header string
+		want   transport
+		err    bool
+	}{
+		{"RTP/AVP;unicast;client_port=5000-5001",
+			transport{Protocol: "RTP/AVP", ClientPort: []int{5000, 5001}}, false},
+		{"RTP/AVP/UDP;unicast;client_port=5000",
+			transport{Protocol: "RTP/AVP/UDP", ClientPort: []int{5000, 5001}}, false},
+		{"RTP/AVP/TCP;unicast;interleaved=2-3",
+			transport{Protocol: "RTP/AVP/TCP", TCP: true, Interleaved: []int{2, 3}}, false},
+		{"RTP/AVP/TCP;unicast;interleaved=0-1;mode=\"RECORD\"",
+			transport{Protocol: "RTP/AVP/TCP", TCP: true, Interleaved: []int{0, 1}, Mode: "record"}, false},
+		// the first option the server supports is chosen.
+		{"RTP/SAVP;unicast;client_port=5000-5001, RTP/AVP/TCP;interleaved=0-1",
+			transport{Protocol: "RTP/AVP/TCP", TCP: true, Interleaved: []int{0, 1}}, false},
+		{"RTP/AVP;multicast, RTP/AVP;unicast;client_port=6000-6001",
+			transport{Protocol: "RTP/AVP", ClientPort: []int{6000, 6001}}, false},
+		{"RTP/AVP;unicast", transport{}, true},
+		{"RTP/AVP;unicast;client_port", transport{}, true},
+		{"RTP/AVP;unicast;client_port=a-b", transport{}, true},
+		{"RTP/SAVP;unicast;client_port=5000-5001", transport{}, true},
+		{"", transport{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseTransport(test.header)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: no error, got %+v", test.header, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.header, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestTransportString(t *testing.T) {
+	tr := transport{Protocol: "RTP/AVP", ClientPort: []int{5000, 5001}, ServerPort: []int{6000, 6001}}
+	if s := tr.String(); s != "RTP/AVP;unicast;client_port=5000-5001;server_port=6000-6001" {
+		t.Errorf("transport %s", s)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []int
+		err  bool
+	}{
+		{"5000-5001", []int{5000, 5001}, false},
+		{"5000", []int{5000, 5001}, false},
+		{"0-1", []int{0, 1}, false},
+		{"5000-", nil, true},
+		{"-5001", nil, true},
+		{"x", nil, true},
+		{"", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parsePortRange(test.s)
+		if (err != nil) != test.err || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, %v", test.s, got, err)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  time.Duration
+		ok     bool
+		err    bool
+	}{
+		{"npt=10.5-", 10500 * time.Millisecond, true, false},
+		{"npt=0-", 0, true, false},
+		{"npt=00:01:30-", 90 * time.Second, true, false},
+		{"npt=1:02:03.5-4:00:00", time.Hour + 2*time.Minute + 3500*time.Millisecond, true, false},
+		{"npt=now-", 0, false, false},
+		{"npt=-20", 0, false, false},
+		{"npt=x-", 0, false, true},
+		{"clock=19961108T143720.25Z-", 0, false, true},
+		{"smpte=10:07:00-10:07:33:05.01", 0, false, true},
+	}
+	for _, test := range tests {
+		start, ok, err := parseRange(test.header)
+		if (err != nil) != test.err {
+			t.Errorf("%q: error %v", test.header, err)
+			continue
+		}
+		if start != test.start || ok != test.ok {
+			t.Errorf("%q: got %v %v, want %v %v", test.header, start, ok, test.start, test.ok)
+		}
+	}
+}